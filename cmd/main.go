@@ -7,6 +7,7 @@ SPDX-License-Identifier: Apache-2.0
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"os"
 	"path/filepath"
@@ -19,6 +20,7 @@ import (
 
 	snapshotv1 "github.com/kubernetes-csi/external-snapshotter/client/v8/apis/volumesnapshot/v1"
 	"github.com/outscale/csi-snapshot-exporter/internal/controller"
+	"github.com/outscale/csi-snapshot-exporter/internal/objectstore"
 	"github.com/outscale/goutils/k8s/sdk"
 	"github.com/spf13/pflag"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -30,6 +32,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/metrics/filters"
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
 	// +kubebuilder:scaffold:imports
 )
 
@@ -45,15 +48,21 @@ func init() {
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "worker" {
+		workerMain()
+	}
+
 	var metricsAddr string
 	var metricsCertPath, metricsCertName, metricsCertKey string
 	var webhookCertPath, webhookCertName, webhookCertKey string
 	var enableLeaderElection bool
+	var enableWebhook bool
 	var probeAddr string
 	var secureMetrics bool
 	var enableHTTP2 bool
 	var tlsOpts []func(*tls.Config)
 	var sdkOptions sdk.Options
+	var exporterOptions controller.Options
 	fs := pflag.CommandLine
 	fs.StringVar(&metricsAddr, "metrics-bind-address", "0", "The address the metrics endpoint binds to. "+
 		"Use :8443 for HTTPS or :8080 for HTTP, or leave as 0 to disable the metrics service.")
@@ -63,6 +72,8 @@ func main() {
 			"Enabling this will ensure there is only one active controller manager.")
 	fs.BoolVar(&secureMetrics, "metrics-secure", true,
 		"If set, the metrics endpoint is served securely via HTTPS. Use --metrics-secure=false to use HTTP instead.")
+	fs.BoolVar(&enableWebhook, "enable-webhook", false,
+		"If set, the webhook validating the export parameters of VolumeSnapshotClasses is enabled.")
 	fs.StringVar(&webhookCertPath, "webhook-cert-path", "", "The directory that contains the webhook certificate.")
 	fs.StringVar(&webhookCertName, "webhook-cert-name", "tls.crt", "The name of the webhook certificate file.")
 	fs.StringVar(&webhookCertKey, "webhook-cert-key", "tls.key", "The name of the webhook key file.")
@@ -75,12 +86,17 @@ func main() {
 	logOptions := logs.NewOptions()
 	logsv1.AddFlags(logOptions, fs)
 	sdkOptions.AddFlags(fs)
+	exporterOptions.AddFlags(fs)
 	pflag.Parse()
 
 	if err := logsv1.ValidateAndApply(logOptions, nil); err != nil {
 		setupLog.Error(err, "unable to validate and apply log options")
 		os.Exit(1)
 	}
+	if err := exporterOptions.Validate(); err != nil {
+		setupLog.Error(err, "invalid exporter options")
+		os.Exit(1)
+	}
 	logger := klog.Background().WithValues("version", controller.Version)
 	ctrl.SetLogger(logger)
 
@@ -99,8 +115,34 @@ func main() {
 		tlsOpts = append(tlsOpts, disableHTTP2)
 	}
 
-	// Create watchers for metrics certificates
-	var metricsCertWatcher *certwatcher.CertWatcher
+	// Create watchers for metrics and webhooks certificates
+	var metricsCertWatcher, webhookCertWatcher *certwatcher.CertWatcher
+
+	// Initial webhook TLS options
+	webhookTLSOpts := tlsOpts
+
+	if len(webhookCertPath) > 0 {
+		logger.Info("Initializing webhook certificate watcher using provided certificates",
+			"webhook-cert-path", webhookCertPath, "webhook-cert-name", webhookCertName, "webhook-cert-key", webhookCertKey)
+
+		var err error
+		webhookCertWatcher, err = certwatcher.New(
+			filepath.Join(webhookCertPath, webhookCertName),
+			filepath.Join(webhookCertPath, webhookCertKey),
+		)
+		if err != nil {
+			logger.Error(err, "Failed to initialize webhook certificate watcher")
+			os.Exit(1)
+		}
+
+		webhookTLSOpts = append(webhookTLSOpts, func(c *tls.Config) {
+			c.GetCertificate = webhookCertWatcher.GetCertificate
+		})
+	}
+
+	webhookServer := webhook.NewServer(webhook.Options{
+		TLSOpts: webhookTLSOpts,
+	})
 
 	// Metrics endpoint is enabled in 'config/default/kustomization.yaml'. The Metrics options configure the server.
 	// More info:
@@ -150,6 +192,8 @@ func main() {
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme:                 scheme,
 		Metrics:                metricsServerOptions,
+		Cache:                  exporterOptions.CacheOptions(),
+		WebhookServer:          webhookServer,
 		HealthProbeBindAddress: probeAddr,
 		LeaderElection:         enableLeaderElection,
 		LeaderElectionID:       "controller-leader-elect-osc-csi-exporter",
@@ -171,16 +215,29 @@ func main() {
 	}
 
 	ctx := ctrl.SetupSignalHandler()
-	oapi, err := controller.NewOAPIClient(ctx, sdkOptions)
+	oscProfile, oapi, err := controller.NewOAPIClient(ctx, sdkOptions)
 	if err != nil {
 		logger.Error(err, "unable to configure OAPI client")
 		os.Exit(1)
 	}
+	oapi = controller.NewRateLimitedClient(oapi, exporterOptions)
+	// OOS is only used by some export features, the client is created on first use.
+	store := objectstore.NewLazy(func(ctx context.Context) (objectstore.Store, error) {
+		return controller.NewObjectStore(ctx, oscProfile)
+	})
 
-	if err := controller.NewVolumeSnaphotContentReconciler(mgr.GetClient(), mgr.GetScheme(), oapi).SetupWithManager(mgr); err != nil {
+	r := controller.NewVolumeSnaphotContentReconciler(mgr.GetClient(), mgr.GetScheme(), oapi, store, exporterOptions)
+	r.SetEventRecorder(mgr.GetEventRecorderFor("csi-snapshot-exporter"))
+	if err := r.SetupWithManager(mgr); err != nil {
 		logger.Error(err, "unable to create controller", "controller", "VolumeSnaphotContent")
 		os.Exit(1)
 	}
+	if enableWebhook {
+		if err := (&controller.VolumeSnapshotClassValidator{Store: store}).SetupWebhookWithManager(mgr); err != nil {
+			logger.Error(err, "unable to create webhook", "webhook", "VolumeSnapshotClass")
+			os.Exit(1)
+		}
+	}
 	// +kubebuilder:scaffold:builder
 
 	if metricsCertWatcher != nil {
@@ -191,6 +248,14 @@ func main() {
 		}
 	}
 
+	if webhookCertWatcher != nil {
+		logger.Info("Adding webhook certificate watcher to manager")
+		if err := mgr.Add(webhookCertWatcher); err != nil {
+			logger.Error(err, "unable to add webhook certificate watcher to manager")
+			os.Exit(1)
+		}
+	}
+
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
 		logger.Error(err, "unable to set up health check")
 		os.Exit(1)
@@ -0,0 +1,134 @@
+/*
+SPDX-FileCopyrightText: 2025 Outscale SAS <opensource@outscale.com>
+
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/outscale/csi-snapshot-exporter/internal/controller"
+	"github.com/outscale/csi-snapshot-exporter/internal/encryption"
+	"github.com/outscale/csi-snapshot-exporter/internal/objectstore"
+	"github.com/outscale/csi-snapshot-exporter/internal/oci"
+	"github.com/outscale/csi-snapshot-exporter/internal/worker"
+	"github.com/spf13/pflag"
+	"k8s.io/component-base/logs"
+	logsv1 "k8s.io/component-base/logs/api/v1"
+	"k8s.io/klog/v2"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// runWorker runs a worker command, in a Job created by the controller.
+func runWorker(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing worker command")
+	}
+	fs := pflag.NewFlagSet("worker "+args[0], pflag.ContinueOnError)
+	logOptions := logs.NewOptions()
+	logsv1.AddFlags(logOptions, fs)
+	var bucket, key, keyFile, keyID string
+	fs.StringVar(&bucket, "bucket", "", "The bucket of the exported file.")
+	fs.StringVar(&key, "key", "", "The key of the exported file.")
+
+	var run func(ctx context.Context, store objectstore.Store) error
+	switch args[0] {
+	case "encrypt":
+		fs.StringVar(&keyFile, "key-file", "", "The file storing the encryption key.")
+		fs.StringVar(&keyID, "key-id", "", "The expected ID of the encryption key.")
+		run = func(ctx context.Context, store objectstore.Store) error {
+			return worker.Encrypt(ctx, store, encryption.FileKeyProvider{Path: keyFile}, bucket, key, keyID)
+		}
+	case "copy":
+		var endpoint, region, targetBucket, targetKey string
+		fs.StringVar(&endpoint, "target-endpoint", "", "The endpoint of the target.")
+		fs.StringVar(&region, "target-region", "", "The region of the target.")
+		fs.StringVar(&targetBucket, "target-bucket", "", "The bucket of the target.")
+		fs.StringVar(&targetKey, "target-key", "", "The key of the copy.")
+		run = func(ctx context.Context, store objectstore.Store) error {
+			target, err := objectstore.NewEndpoint(ctx, endpoint, region, os.Getenv("TARGET_ACCESS_KEY"), os.Getenv("TARGET_SECRET_KEY"))
+			if err != nil {
+				return fmt.Errorf("unable to create target client: %w", err)
+			}
+			return worker.Copy(ctx, store, bucket, key, target, targetBucket, targetKey)
+		}
+	case "push":
+		var registry, repository, tag, config string
+		fs.StringVar(&registry, "registry", "", "The URL of the registry, http URLs being insecure registries.")
+		fs.StringVar(&repository, "repository", "", "The repository of the artifact.")
+		fs.StringVar(&tag, "tag", "", "The tag of the artifact.")
+		fs.StringVar(&config, "config", "", "The config of the artifact, in JSON.")
+		run = func(ctx context.Context, store objectstore.Store) error {
+			var c oci.Config
+			if err := json.Unmarshal([]byte(config), &c); err != nil {
+				return fmt.Errorf("invalid config: %w", err)
+			}
+			target, err := oci.NewClient(registry, repository, os.Getenv("TARGET_USERNAME"), os.Getenv("TARGET_PASSWORD"))
+			if err != nil {
+				return fmt.Errorf("unable to create registry client: %w", err)
+			}
+			return worker.Push(ctx, store, bucket, key, target, tag, c)
+		}
+	case "diff":
+		var chain []string
+		var output string
+		fs.StringArrayVar(&chain, "parent", nil, "The files of the parent chain, from the full image to the parent (repeated).")
+		fs.StringVar(&output, "output", "", "The key of the diff.")
+		run = func(ctx context.Context, store objectstore.Store) error {
+			return worker.Diff(ctx, store, bucket, key, chain, output)
+		}
+	case "datamove":
+		var device, format, compression string
+		fs.StringVar(&device, "device", "", "The block device of the restored snapshot.")
+		fs.StringVar(&format, "format", "raw", "The format of the image (raw or qcow2).")
+		fs.StringVar(&compression, "compression", "gzip", "The compression of the image (gzip or none).")
+		run = func(ctx context.Context, store objectstore.Store) error {
+			return worker.DataMove(ctx, store, device, format, compression, bucket, key)
+		}
+	case "restore":
+		var output string
+		fs.StringVar(&output, "output", "-", "The file the raw image is written to, - for stdout.")
+		run = func(ctx context.Context, store objectstore.Store) error {
+			if output == "-" {
+				return worker.Restore(ctx, store, bucket, key, os.Stdout)
+			}
+			f, err := os.Create(output)
+			if err != nil {
+				return err
+			}
+			if err := worker.Restore(ctx, store, bucket, key, f); err != nil {
+				_ = f.Close()
+				return err
+			}
+			return f.Close()
+		}
+	default:
+		return fmt.Errorf("unknown worker command %q", args[0])
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if err := logsv1.ValidateAndApply(logOptions, nil); err != nil {
+		return err
+	}
+
+	ctx := ctrl.SetupSignalHandler()
+	store, err := controller.NewObjectStore(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("unable to create OOS client: %w", err)
+	}
+	return run(klog.NewContext(ctx, klog.Background()), store)
+}
+
+func workerMain() {
+	if err := runWorker(os.Args[2:]); err != nil {
+		klog.Background().Error(err, "Worker has failed")
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
@@ -0,0 +1,222 @@
+/*
+SPDX-FileCopyrightText: 2025 Outscale SAS <opensource@outscale.com>
+
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+// Package blockdiff computes and applies block-level diffs between raw disk images.
+//
+// A diff lists the blocks of an image differing from its parent image, sorted by index, followed by the size of the image:
+//
+//	header:  magic (4 bytes) | version (1 byte) | block size (4 bytes)
+//	blocks:  index (8 bytes) | length (4 bytes) | data (length bytes)
+//	trailer: 0xffffffffffffffff (8 bytes) | image size (8 bytes)
+//
+// Diffs being sorted, an image is rebuilt by streaming its base image and all the diffs of its chain at once.
+package blockdiff
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+const (
+	// BlockSize is the size of compared blocks.
+	BlockSize = 64 << 10
+	// ManifestSuffix is appended to the key of the files of a chain, to store their manifest.
+	ManifestSuffix = ".manifest.json"
+
+	version  = 1
+	trailer  = math.MaxUint64
+	maxBlock = 1 << 24
+)
+
+var magic = []byte("OSCD")
+
+var ErrInvalidStream = errors.New("invalid diff stream")
+
+// Stats describes a diff.
+type Stats struct {
+	Size          int64
+	Blocks        int64
+	ChangedBlocks int64
+}
+
+// Diff writes the blocks of current differing from parent to w.
+func Diff(w io.Writer, parent, current io.Reader) (Stats, error) {
+	var stats Stats
+	bw := bufio.NewWriter(w)
+	header := append(append([]byte{}, magic...), version, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(header[len(magic)+1:], BlockSize)
+	if _, err := bw.Write(header); err != nil {
+		return stats, err
+	}
+	pbuf, cbuf := make([]byte, BlockSize), make([]byte, BlockSize)
+	parentDone := false
+	for index := uint64(0); ; index++ {
+		n, err := io.ReadFull(current, cbuf)
+		switch {
+		case errors.Is(err, io.EOF):
+			return stats, writeTrailer(bw, stats.Size)
+		case err != nil && !errors.Is(err, io.ErrUnexpectedEOF):
+			return stats, fmt.Errorf("read image: %w", err)
+		}
+		stats.Size += int64(n)
+		stats.Blocks++
+		pn := 0
+		if !parentDone {
+			pn, err = io.ReadFull(parent, pbuf)
+			switch {
+			case errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF):
+				parentDone = true
+			case err != nil:
+				return stats, fmt.Errorf("read parent image: %w", err)
+			}
+		}
+		// blocks missing from the parent are rebuilt as zeros
+		clear(pbuf[pn:])
+		if bytes.Equal(cbuf[:n], pbuf[:n]) {
+			continue
+		}
+		stats.ChangedBlocks++
+		var entry [12]byte
+		binary.BigEndian.PutUint64(entry[:], index)
+		binary.BigEndian.PutUint32(entry[8:], uint32(n))
+		if _, err := bw.Write(entry[:]); err != nil {
+			return stats, err
+		}
+		if _, err := bw.Write(cbuf[:n]); err != nil {
+			return stats, err
+		}
+	}
+}
+
+func writeTrailer(bw *bufio.Writer, size int64) error {
+	var t [16]byte
+	binary.BigEndian.PutUint64(t[:], trailer)
+	binary.BigEndian.PutUint64(t[8:], uint64(size))
+	if _, err := bw.Write(t[:]); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// diffReader reads the blocks of a diff, one at a time.
+type diffReader struct {
+	r         *bufio.Reader
+	blockSize int
+	index     uint64
+	started   bool
+	data      []byte
+	size      int64
+	done      bool
+}
+
+func newDiffReader(r io.Reader) (*diffReader, error) {
+	d := &diffReader{r: bufio.NewReader(r)}
+	var header [9]byte
+	if _, err := io.ReadFull(d.r, header[:]); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidStream, err)
+	}
+	if !bytes.Equal(header[:len(magic)], magic) || header[len(magic)] != version {
+		return nil, ErrInvalidStream
+	}
+	d.blockSize = int(binary.BigEndian.Uint32(header[len(magic)+1:]))
+	if d.blockSize == 0 || d.blockSize > maxBlock {
+		return nil, fmt.Errorf("%w: invalid block size %d", ErrInvalidStream, d.blockSize)
+	}
+	d.data = make([]byte, d.blockSize)
+	return d, d.next()
+}
+
+// next reads the next block, or the trailer.
+func (d *diffReader) next() error {
+	var entry [12]byte
+	if _, err := io.ReadFull(d.r, entry[:8]); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidStream, err)
+	}
+	index := binary.BigEndian.Uint64(entry[:])
+	if index == trailer {
+		if _, err := io.ReadFull(d.r, entry[:8]); err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidStream, err)
+		}
+		d.size, d.done = int64(binary.BigEndian.Uint64(entry[:])), true
+		return nil
+	}
+	if _, err := io.ReadFull(d.r, entry[8:]); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidStream, err)
+	}
+	n := int(binary.BigEndian.Uint32(entry[8:]))
+	if n > d.blockSize || (d.started && index <= d.index) {
+		return fmt.Errorf("%w: invalid block %d", ErrInvalidStream, index)
+	}
+	d.index, d.started = index, true
+	d.data = d.data[:n]
+	if _, err := io.ReadFull(d.r, d.data); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidStream, err)
+	}
+	return nil
+}
+
+// Apply rebuilds an image from its base image and the diffs of its chain, in order.
+func Apply(w io.Writer, base io.Reader, diffs ...io.Reader) error {
+	if len(diffs) == 0 {
+		_, err := io.Copy(w, base)
+		return err
+	}
+	readers := make([]*diffReader, 0, len(diffs))
+	for _, diff := range diffs {
+		d, err := newDiffReader(diff)
+		if err != nil {
+			return err
+		}
+		if d.blockSize != BlockSize {
+			return fmt.Errorf("%w: unsupported block size %d", ErrInvalidStream, d.blockSize)
+		}
+		readers = append(readers, d)
+	}
+	last := readers[len(readers)-1]
+	bw := bufio.NewWriter(w)
+	buf := make([]byte, BlockSize)
+	baseDone := false
+	for index := uint64(0); ; index++ {
+		// the size of the image is only known at the end of the last diff, blocks are written while it has blocks left
+		if last.done && int64(index)*BlockSize >= last.size {
+			break
+		}
+		n := 0
+		if !baseDone {
+			var err error
+			n, err = io.ReadFull(base, buf)
+			switch {
+			case errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF):
+				baseDone = true
+			case err != nil:
+				return fmt.Errorf("read base image: %w", err)
+			}
+		}
+		clear(buf[n:])
+		for _, d := range readers {
+			if d.done || d.index != index {
+				continue
+			}
+			copy(buf, d.data)
+			if err := d.next(); err != nil {
+				return err
+			}
+		}
+		n = BlockSize
+		if last.done {
+			n = int(min(int64(BlockSize), last.size-int64(index)*BlockSize))
+		}
+		if _, err := bw.Write(buf[:n]); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
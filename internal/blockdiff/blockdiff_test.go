@@ -0,0 +1,92 @@
+/*
+SPDX-FileCopyrightText: 2025 Outscale SAS <opensource@outscale.com>
+
+SPDX-License-Identifier: BSD-3-Clause
+*/
+package blockdiff_test
+
+import (
+	"bytes"
+	"io"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/outscale/csi-snapshot-exporter/internal/blockdiff"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func image(t *testing.T, size int, seed uint64) []byte {
+	t.Helper()
+	buf := make([]byte, size)
+	r := rand.New(rand.NewPCG(seed, seed))
+	for i := range buf {
+		buf[i] = byte(r.Uint32())
+	}
+	return buf
+}
+
+func diff(t *testing.T, parent, current []byte) ([]byte, blockdiff.Stats) {
+	t.Helper()
+	var buf bytes.Buffer
+	stats, err := blockdiff.Diff(&buf, bytes.NewReader(parent), bytes.NewReader(current))
+	require.NoError(t, err)
+	return buf.Bytes(), stats
+}
+
+func apply(t *testing.T, base []byte, diffs ...[]byte) []byte {
+	t.Helper()
+	readers := make([]io.Reader, 0, len(diffs))
+	for _, d := range diffs {
+		readers = append(readers, bytes.NewReader(d))
+	}
+	var buf bytes.Buffer
+	require.NoError(t, blockdiff.Apply(&buf, bytes.NewReader(base), readers...))
+	return buf.Bytes()
+}
+
+func TestDiff(t *testing.T) {
+	base := image(t, 10*blockdiff.BlockSize+123, 1)
+
+	t.Run("Only changed blocks are stored", func(t *testing.T) {
+		current := bytes.Clone(base)
+		current[3*blockdiff.BlockSize+5] ^= 0xff
+		current[len(current)-1] ^= 0xff
+		d, stats := diff(t, base, current)
+		assert.Equal(t, int64(len(current)), stats.Size)
+		assert.Equal(t, int64(11), stats.Blocks)
+		assert.Equal(t, int64(2), stats.ChangedBlocks)
+		assert.Less(t, len(d), 3*blockdiff.BlockSize)
+		assert.Equal(t, current, apply(t, base, d))
+	})
+	t.Run("Unchanged images have empty diffs", func(t *testing.T) {
+		d, stats := diff(t, base, base)
+		assert.Zero(t, stats.ChangedBlocks)
+		assert.Equal(t, base, apply(t, base, d))
+	})
+	t.Run("Chains rebuild each point", func(t *testing.T) {
+		// the volume is resized, with a zero tail
+		grown := append(bytes.Clone(base), make([]byte, 3*blockdiff.BlockSize)...)
+		copy(grown[2*blockdiff.BlockSize:], image(t, 100, 2))
+		last := bytes.Clone(grown)
+		copy(last[11*blockdiff.BlockSize:], image(t, blockdiff.BlockSize, 3))
+
+		d1, stats := diff(t, base, grown)
+		// zero blocks beyond the parent are not stored
+		assert.Equal(t, int64(1), stats.ChangedBlocks)
+		d2, _ := diff(t, grown, last)
+		assert.Equal(t, grown, apply(t, base, d1))
+		assert.Equal(t, last, apply(t, base, d1, d2))
+	})
+	t.Run("Empty images are supported", func(t *testing.T) {
+		d, _ := diff(t, base, nil)
+		assert.Empty(t, apply(t, base, d))
+	})
+	t.Run("Invalid diffs are rejected", func(t *testing.T) {
+		d, _ := diff(t, base, image(t, blockdiff.BlockSize, 4))
+		err := blockdiff.Apply(io.Discard, bytes.NewReader(base), bytes.NewReader(d[:len(d)-10]))
+		require.ErrorIs(t, err, blockdiff.ErrInvalidStream)
+		err = blockdiff.Apply(io.Discard, bytes.NewReader(base), bytes.NewReader([]byte("foo")))
+		require.ErrorIs(t, err, blockdiff.ErrInvalidStream)
+	})
+}
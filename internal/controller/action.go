@@ -0,0 +1,214 @@
+/*
+SPDX-FileCopyrightText: 2025 Outscale SAS <opensource@outscale.com>
+
+SPDX-License-Identifier: BSD-3-Clause
+*/
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/outscale/osc-sdk-go/v3/pkg/osc"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+
+const (
+	// AnnotationExportAction requests a manual transition of the export, consumed once by the controller.
+	AnnotationExportAction = "bsu.csi.outscale.com/export-action"
+	// AnnotationExportGeneration counts the re-exports of a content. Export tasks are tagged with it, to not adopt the tasks of previous exports.
+	AnnotationExportGeneration = "bsu.csi.outscale.com/export-generation"
+	TagExportGeneration        = AnnotationExportGeneration
+
+	// ActionRetry retries a failed or cancelled export immediately, even after a permanent error.
+	ActionRetry = "retry"
+	// ActionReexport exports a snapshot again, once its export is completed, failed, cancelled or skipped.
+	ActionReexport = "reexport"
+	// ActionCancel stops a running export.
+	ActionCancel = "cancel"
+
+	// ExportStateRetrying is set when a retry is requested, until the export is resumed.
+	ExportStateRetrying = "retrying"
+
+	// Reasons of the events recording actions.
+	EventReasonActionDone     = "ExportActionDone"
+	EventReasonActionRejected = "ExportActionRejected"
+)
+
+// runAction consumes the action requested on a content, and records the field manager that requested it, when, and its result in an event.
+func (r *VolumeSnaphotContentReconciler) runAction(ctx context.Context, scope *Scope) error {
+	action, found := scope.snap.Annotations[AnnotationExportAction]
+	if !found {
+		return nil
+	}
+	log := klog.FromContext(ctx)
+	manager, at := actionManager(scope)
+	var err error
+	switch action {
+	case ActionRetry:
+		err = r.retry(scope)
+	case ActionReexport:
+		err = r.reexport(ctx, scope)
+	case ActionCancel:
+		err = r.cancel(ctx, scope)
+	default:
+		err = rejectAction("unknown action, allowed values are %s,%s,%s", ActionCancel, ActionReexport, ActionRetry)
+	}
+	delete(scope.snap.Annotations, AnnotationExportAction)
+	when := at.UTC().Format(time.RFC3339)
+	if rerr, ok := errors.AsType[*actionError](err); ok {
+		log.V(2).Info("Export action rejected", "action", action, "manager", manager, "reason", rerr.Error())
+		r.recorder.Eventf(scope.snap, corev1.EventTypeWarning, EventReasonActionRejected,
+			"Export action %q requested by field manager %s at %s was rejected: %s", action, manager, when, rerr.Error())
+		return nil
+	}
+	if err != nil {
+		// the annotation is kept, to run the action again
+		scope.snap.Annotations[AnnotationExportAction] = action
+		return fmt.Errorf("unable to run export action %s: %w", action, err)
+	}
+	log.V(2).Info("Export action done", "action", action, "manager", manager)
+	r.recorder.Eventf(scope.snap, corev1.EventTypeNormal, EventReasonActionDone,
+		"Export action %q requested by field manager %s at %s was done", action, manager, when)
+	return nil
+}
+
+// actionError is returned when an action cannot be done in the current state of the export.
+type actionError struct {
+	reason string
+}
+
+func (e *actionError) Error() string {
+	return e.reason
+}
+
+func rejectAction(format string, args ...any) error {
+	return &actionError{reason: fmt.Sprintf(format, args...)}
+}
+
+// retry resumes a failed or cancelled export. Permanent errors are cleared, cancelled tasks are followed again or replaced if cancelled by OAPI.
+func (r *VolumeSnaphotContentReconciler) retry(scope *Scope) error {
+	_, hasError := scope.snap.Annotations[AnnotationExportError]
+	switch state := scope.ExportState(); {
+	case scope.params[ParamExportEnabled] != "true":
+		return rejectAction("export is not enabled")
+	case !hasError && state != string(osc.SnapshotExportTaskStateFailed) && state != string(osc.SnapshotExportTaskStateCancelled):
+		return rejectAction("export is %s, only failed or cancelled exports can be retried", describeState(state))
+	}
+	scope.ClearExportError()
+	scope.SetExportState(ExportStateRetrying)
+	return nil
+}
+
+// reexport resets the export of a content, a new export being started with the frozen configuration.
+func (r *VolumeSnaphotContentReconciler) reexport(ctx context.Context, scope *Scope) error {
+	switch state := scope.ExportState(); {
+	case scope.SnapshotDeleted():
+		return rejectAction("snapshot was deleted after its export")
+	case scope.params[ParamExportEnabled] != "true":
+		return rejectAction("export is not enabled")
+	case !isTerminal(state) && !scope.HasPermanentError():
+		return rejectAction("export is %s, cancel it first", describeState(state))
+	}
+	if err := r.stopExport(ctx, scope); err != nil {
+		return err
+	}
+	for _, annotation := range []string{
+		AnnotationExportState, AnnotationExportTask, AnnotationExportTaskUntagged, AnnotationExportManifest, AnnotationExportPath, AnnotationExportSkipReason,
+		AnnotationExportError, AnnotationExportErrorConfig, AnnotationExportSnapshotProgress, AnnotationExportTagged, AnnotationExportCopies,
+		AnnotationExportCompletedAt, AnnotationExportLockedUntil, AnnotationExportLegalHold, AnnotationExportLockError, AnnotationExportJobFailures,
+	} {
+		delete(scope.snap.Annotations, annotation)
+	}
+	scope.snap.Annotations[AnnotationExportGeneration] = strconv.Itoa(scope.ExportGeneration() + 1)
+	return nil
+}
+
+// cancel stops a running or failing export. OAPI export tasks cannot be cancelled: they are no longer followed, and may still complete.
+func (r *VolumeSnaphotContentReconciler) cancel(ctx context.Context, scope *Scope) error {
+	if !scope.NeedsExport() {
+		return rejectAction("export is %s, only running or failing exports can be cancelled", describeState(scope.ExportState()))
+	}
+	if err := r.stopExport(ctx, scope); err != nil {
+		return err
+	}
+	scope.ClearSnapshotProgress()
+	scope.SetExportState(string(osc.SnapshotExportTaskStateCancelled))
+	return nil
+}
+
+// stopExport stops following the export task, and deletes the worker Jobs and data mover resources of a content.
+func (r *VolumeSnaphotContentReconciler) stopExport(ctx context.Context, scope *Scope) error {
+	if id := scope.ExportTaskID(); id != "" {
+		r.tasks.Untrack(id)
+	}
+	if r.workerNamespace == "" {
+		return nil
+	}
+	var jobs batchv1.JobList
+	if err := r.k8s.List(ctx, &jobs, client.InNamespace(r.workerNamespace)); err != nil {
+		return fmt.Errorf("unable to list jobs: %w", err)
+	}
+	for i := range jobs.Items {
+		job := &jobs.Items[i]
+		if owner := metav1.GetControllerOf(job); owner == nil || string(owner.UID) != scope.UID() {
+			continue
+		}
+		if err := r.k8s.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationBackground)); client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("unable to delete job: %w", err)
+		}
+	}
+	return dataMover{r: r}.cleanup(ctx, scope)
+}
+
+// actionManager returns the field manager that set the action annotation, and when, from the managed fields of the content.
+// Field managers name clients (e.g. kubectl-annotate), not users. The manager is unknown if managed fields are not tracked.
+func actionManager(scope *Scope) (string, time.Time) {
+	manager, at := "unknown", time.Now()
+	var latest time.Time
+	for _, mf := range scope.snap.ManagedFields {
+		if mf.FieldsV1 == nil || mf.Time == nil || mf.Time.Time.Before(latest) {
+			continue
+		}
+		var fields struct {
+			Metadata struct {
+				Annotations map[string]any `json:"f:annotations"`
+			} `json:"f:metadata"`
+		}
+		if err := json.Unmarshal(mf.FieldsV1.Raw, &fields); err != nil {
+			continue
+		}
+		if _, found := fields.Metadata.Annotations["f:"+AnnotationExportAction]; found {
+			manager, at, latest = mf.Manager, mf.Time.Time, mf.Time.Time
+		}
+	}
+	return manager, at
+}
+
+// isTerminal checks if an export state is terminal: the export is not running.
+func isTerminal(state string) bool {
+	switch state {
+	case string(osc.SnapshotExportTaskStateCompleted), string(osc.SnapshotExportTaskStateFailed),
+		string(osc.SnapshotExportTaskStateCancelled), ExportStateSkipped:
+		return true
+	default:
+		return false
+	}
+}
+
+func describeState(state string) string {
+	if state == "" {
+		return "not started"
+	}
+	return state
+}
@@ -0,0 +1,74 @@
+/*
+SPDX-FileCopyrightText: 2025 Outscale SAS <opensource@outscale.com>
+
+SPDX-License-Identifier: BSD-3-Clause
+*/
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"k8s.io/klog/v2"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// errExportMissing is returned when the exported file of an archived snapshot is missing.
+var errExportMissing = errors.New("exported file not found")
+
+// archive deletes the snapshot of a completed export, once the archive delay has elapsed.
+// The exported file, and the files of its chain, are checked before deleting the snapshot.
+func (r *VolumeSnaphotContentReconciler) archive(ctx context.Context, scope *Scope, exporter Exporter) (ctrl.Result, error) {
+	log := klog.FromContext(ctx)
+	after, _, err := scope.ExportArchive()
+	if err != nil {
+		log.V(2).Error(err, "Unable to archive snapshot")
+		return ctrl.Result{}, nil
+	}
+	completedAt, found := scope.CompletedAt()
+	if !found {
+		// exports completed before archiving was enabled
+		completedAt = time.Now()
+		scope.SetCompletedAt(completedAt)
+	}
+	if wait := time.Until(completedAt.Add(after)); wait > 0 {
+		log.V(4).Info("Waiting before deleting snapshot", "after", wait)
+		return ctrl.Result{RequeueAfter: wait}, nil
+	}
+	m, err := scope.ExportManifest()
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	for _, path := range append(m.Chain, m.Path) {
+		found, err := r.store.Exists(ctx, m.Bucket, path)
+		switch {
+		case err != nil:
+			return ctrl.Result{}, fmt.Errorf("unable to check exported file: %w", err)
+		case !found:
+			err := fmt.Errorf("%w: %s/%s", errExportMissing, m.Bucket, path)
+			log.V(2).Error(err, "Unable to archive snapshot")
+			scope.SetArchiveError(err)
+			return ctrl.Result{RequeueAfter: time.Hour}, nil
+		}
+	}
+	if err := r.writeManifest(ctx, m); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	err = exporter.DeleteSnapshot(ctx, scope)
+	switch {
+	case err == nil:
+	case errors.Is(err, ErrDeleteUnsupported), ClassifyError(err) == ErrorPermanent:
+		log.V(2).Error(err, "Unable to archive snapshot")
+		scope.SetArchiveError(err)
+		return ctrl.Result{}, nil
+	default:
+		return ctrl.Result{}, err
+	}
+	scope.SetSnapshotDeleted(time.Now())
+	id, _ := scope.GetSnapshotID()
+	log.V(2).Info("Snapshot is archived", "snapshot_id", id, "path", m.Path)
+	return ctrl.Result{}, nil
+}
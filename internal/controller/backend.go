@@ -0,0 +1,67 @@
+/*
+SPDX-FileCopyrightText: 2025 Outscale SAS <opensource@outscale.com>
+
+SPDX-License-Identifier: BSD-3-Clause
+*/
+package controller
+
+import (
+	"context"
+	"errors"
+	"slices"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// DriverBSU is the name of the Outscale BSU CSI driver.
+const DriverBSU = "bsu.csi.outscale.com"
+
+// Exporter exports the snapshots of a CSI driver to a file in a bucket.
+type Exporter interface {
+	// Export starts or follows the export of the snapshot of a content.
+	// The manifest of the exported file is returned once the export is completed.
+	Export(ctx context.Context, scope *Scope) (*ExportManifest, ctrl.Result, error)
+	// DeleteSnapshot deletes the snapshot of a content, once exported.
+	DeleteSnapshot(ctx context.Context, scope *Scope) error
+	// CanDeleteSnapshot checks if the exporter is able to delete snapshots.
+	CanDeleteSnapshot() bool
+}
+
+// ErrDeleteUnsupported is returned by exporters unable to delete snapshots.
+var ErrDeleteUnsupported = errors.New("snapshot deletion is not supported by the export backend")
+
+// Backends is a registry of exporters, keyed by CSI driver name.
+// Only the exporters of enabled drivers are used, the default exporter being used by enabled drivers having no exporter.
+type Backends struct {
+	enabled   []string
+	exporters map[string]Exporter
+	fallback  Exporter
+}
+
+func NewBackends(enabled []string) *Backends {
+	return &Backends{enabled: enabled, exporters: map[string]Exporter{}}
+}
+
+// Register registers the exporter of a driver.
+func (b *Backends) Register(driver string, exporter Exporter) {
+	b.exporters[driver] = exporter
+}
+
+// SetDefault sets the exporter of the enabled drivers having no registered exporter.
+func (b *Backends) SetDefault(exporter Exporter) {
+	b.fallback = exporter
+}
+
+// Get returns the exporter of a driver, or the reason why the snapshots of the driver are ignored.
+func (b *Backends) Get(driver string) (Exporter, string) {
+	if !slices.Contains(b.enabled, driver) {
+		return nil, "driver is not enabled"
+	}
+	if exporter, found := b.exporters[driver]; found {
+		return exporter, ""
+	}
+	if b.fallback != nil {
+		return b.fallback, ""
+	}
+	return nil, "no exporter is registered for the driver"
+}
@@ -0,0 +1,190 @@
+/*
+SPDX-FileCopyrightText: 2025 Outscale SAS <opensource@outscale.com>
+
+SPDX-License-Identifier: BSD-3-Clause
+*/
+package controller
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	volumesnapshotv1 "github.com/kubernetes-csi/external-snapshotter/client/v8/apis/volumesnapshot/v1"
+	"github.com/outscale/csi-snapshot-exporter/internal/blockdiff"
+	"github.com/outscale/osc-sdk-go/v3/pkg/osc"
+	batchv1 "k8s.io/api/batch/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// Kinds of the files of incremental chains.
+const (
+	ManifestKindFull        = "full"
+	ManifestKindIncremental = "incremental"
+)
+
+// DefaultExportFullEvery is the default length of incremental chains.
+const DefaultExportFullEvery = 7
+
+// diffPath returns the path of the diff replacing a full raw image.
+func diffPath(path string) string {
+	return strings.TrimSuffix(path, ".raw.gz") + ".diff.gz"
+}
+
+// chain replaces the exported image by a diff against the previous export of the volume, run by a worker Job.
+// A full image is kept when the volume has no previous export, or when the chain of the previous export is full.
+func (r *VolumeSnaphotContentReconciler) chain(ctx context.Context, scope *Scope) (ctrl.Result, error) {
+	log := klog.FromContext(ctx)
+	m, err := scope.ExportManifest()
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	fullEvery, err := scope.ExportIncremental()
+	if err != nil {
+		log.V(2).Error(err, "Unable to diff export")
+		return ctrl.Result{}, nil
+	}
+	if m.Kind == "" {
+		parent, err := r.chainParent(ctx, scope, m)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if parent == nil || len(parent.Chain)+1 >= fullEvery {
+			m.Kind = ManifestKindFull
+		} else {
+			m.Kind = ManifestKindIncremental
+			m.Parent = parent.Path
+			m.Chain = append(slices.Clone(parent.Chain), parent.Path)
+		}
+		scope.SetExportManifest(m)
+		log.V(3).Info("Export added to chain", "kind", m.Kind, "parent", m.Parent)
+	}
+	if m.Kind == ManifestKindFull {
+		return r.finalize(ctx, scope)
+	}
+	if !r.checkWorkers(ctx, scope) {
+		return ctrl.Result{}, nil
+	}
+	scope.SetExportState(ExportStateDiffing)
+
+	var job batchv1.Job
+	err = r.k8s.Get(ctx, types.NamespacedName{Namespace: r.workerNamespace, Name: workerJobName("diff", scope)}, &job)
+	switch {
+	case apierrors.IsNotFound(err):
+		return ctrl.Result{}, r.createDiffJob(ctx, scope, m)
+	case err != nil:
+		return ctrl.Result{}, fmt.Errorf("unable to fetch job: %w", err)
+	}
+	switch {
+	case job.Status.Succeeded > 0:
+		m.Path = diffPath(m.Path)
+		scope.SetExportManifest(m)
+		scope.ClearJobFailures()
+		log.V(2).Info("Export is replaced by a diff", "path", m.Path, "parent", m.Parent)
+		return r.finalize(ctx, scope)
+	case jobFailed(&job):
+		failures, err := r.retryJob(ctx, scope, &job)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if failures < maxJobFailures {
+			log.V(2).Info("Diff has failed, retrying", "job", job.Name, "reason", jobFailure(&job))
+			return ctrl.Result{RequeueAfter: 2 * time.Minute}, nil
+		}
+		return r.unchain(ctx, scope, m, jobFailure(&job))
+	default:
+		log.V(4).Info("Export is being diffed", "job", job.Name)
+		return ctrl.Result{}, nil
+	}
+}
+
+// unchain keeps the full image of an export that could not be diffed, starting a new chain.
+func (r *VolumeSnaphotContentReconciler) unchain(ctx context.Context, scope *Scope, m ExportManifest, reason string) (ctrl.Result, error) {
+	log := klog.FromContext(ctx)
+	scope.ClearJobFailures()
+	found, err := r.store.Exists(ctx, m.Bucket, m.Path)
+	switch {
+	case err != nil:
+		return ctrl.Result{}, fmt.Errorf("unable to check exported file: %w", err)
+	case !found:
+		err := fmt.Errorf("diff has failed: %s", reason)
+		log.V(2).Error(err, "Export has permanently failed")
+		scope.SetExportError(err)
+		return ctrl.Result{}, nil
+	}
+	m.Kind, m.Parent, m.Chain = ManifestKindFull, "", nil
+	scope.SetExportManifest(m)
+	log.V(2).Info("Diff has failed, keeping the full image", "path", m.Path, "reason", reason)
+	return r.finalize(ctx, scope)
+}
+
+func (r *VolumeSnaphotContentReconciler) createDiffJob(ctx context.Context, scope *Scope, m ExportManifest) error {
+	args := []string{"diff", "--bucket=" + m.Bucket, "--key=" + m.Path}
+	for _, parent := range m.Chain {
+		args = append(args, "--parent="+parent)
+	}
+	args = append(args, "--output="+diffPath(m.Path))
+	job := r.workerJob("diff", scope, args...)
+	if err := controllerutil.SetControllerReference(scope.snap, job, r.Scheme); err != nil {
+		return err
+	}
+	if err := r.k8s.Create(ctx, job); err != nil {
+		return fmt.Errorf("unable to create job: %w", err)
+	}
+	klog.FromContext(ctx).V(2).Info("Diff job created", "job", job.Name, "parent", m.Parent)
+	return nil
+}
+
+// chainParent returns the manifest of the last completed export of the volume in the same bucket, taken before the content.
+func (r *VolumeSnaphotContentReconciler) chainParent(ctx context.Context, scope *Scope, m ExportManifest) (*ExportManifest, error) {
+	volumeID, found := scope.VolumeID()
+	if !found {
+		return nil, nil
+	}
+	var list volumesnapshotv1.VolumeSnapshotContentList
+	if err := r.k8s.List(ctx, &list, client.MatchingFields{IndexVolumeHandle: volumeID}); err != nil {
+		return nil, fmt.Errorf("unable to list snapshots: %w", err)
+	}
+	created := scope.CreationTime()
+	var (
+		parent *ExportManifest
+		last   time.Time
+	)
+	for i := range list.Items {
+		snap := &list.Items[i]
+		if string(snap.UID) == scope.UID() || snap.Annotations[AnnotationExportState] != string(osc.SnapshotExportTaskStateCompleted) {
+			continue
+		}
+		pm, err := NewScope(r.k8s, snap, nil).ExportManifest()
+		if err != nil || pm.Kind == "" || pm.Bucket != m.Bucket {
+			continue
+		}
+		if t := creationTime(snap); t.Before(created) && (parent == nil || t.After(last)) {
+			parent, last = &pm, t
+		}
+	}
+	return parent, nil
+}
+
+// writeManifest stores the manifest of a file of a chain next to it, for restores.
+func (r *VolumeSnaphotContentReconciler) writeManifest(ctx context.Context, m ExportManifest) error {
+	key := m.Path + blockdiff.ManifestSuffix
+	found, err := r.store.Exists(ctx, m.Bucket, key)
+	switch {
+	case err != nil:
+		return fmt.Errorf("unable to check manifest: %w", err)
+	case found:
+		return nil
+	}
+	if err := r.store.Put(ctx, m.Bucket, key, strings.NewReader(m.String())); err != nil {
+		return fmt.Errorf("unable to write manifest: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,330 @@
+/*
+SPDX-FileCopyrightText: 2025 Outscale SAS <opensource@outscale.com>
+
+SPDX-License-Identifier: BSD-3-Clause
+*/
+package controller
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/outscale/csi-snapshot-exporter/internal/oci"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/klog/v2"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// Copy states.
+const (
+	CopyStateCopying   = "copying"
+	CopyStateCompleted = "completed"
+	CopyStateFailed    = "failed"
+
+	// EventReasonCopyFailed is the reason of the event recorded when a copy is given up.
+	EventReasonCopyFailed = "ExportCopyFailed"
+)
+
+// maxCopyTargetName is the maximum length of target names, as Job names include the UID of the content.
+const maxCopyTargetName = 20
+
+// Copy target types.
+const (
+	CopyTargetS3  = "s3"
+	CopyTargetOCI = "oci"
+)
+
+// defaultOCITag is the default tag template of artifacts pushed to OCI targets.
+const defaultOCITag = `{{ .Namespace }}-{{ default .VolumeSnapshot .PVC }}-{{ date "20060102-150405" }}`
+
+// CopyTarget is a secondary target, exported files are copied to: a S3 compatible bucket, or an OCI registry.
+type CopyTarget struct {
+	// Name identifies the target in statuses.
+	Name string `json:"name"`
+	// Type is the type of the target, s3 (default) or oci.
+	Type     string `json:"type,omitempty"`
+	Endpoint string `json:"endpoint"`
+	Region   string `json:"region,omitempty"`
+	Bucket   string `json:"bucket,omitempty"`
+	// Prefix is prepended to the path of the exported file.
+	Prefix string `json:"prefix,omitempty"`
+	// Repository is the repository artifacts are pushed to, and Tag the template of their tag.
+	Repository string `json:"repository,omitempty"`
+	Tag        string `json:"tag,omitempty"`
+	// Secret is the name of the Secret storing the access_key and secret_key of S3 targets,
+	// or the username and password of OCI targets.
+	Secret string `json:"secret,omitempty"`
+}
+
+func (t CopyTarget) validate() error {
+	if errs := validation.IsDNS1123Label(t.Name); len(errs) > 0 || len(t.Name) > maxCopyTargetName {
+		return fmt.Errorf("invalid target name %q: must be a DNS label of at most %d characters", t.Name, maxCopyTargetName)
+	}
+	if u, err := url.Parse(t.Endpoint); err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("target %s: invalid endpoint %q", t.Name, t.Endpoint)
+	}
+	switch t.Type {
+	case "", CopyTargetS3:
+		switch {
+		case t.Bucket == "":
+			return fmt.Errorf("target %s: bucket is required", t.Name)
+		case t.Secret == "":
+			return fmt.Errorf("target %s: secret is required", t.Name)
+		}
+	case CopyTargetOCI:
+		if !oci.ValidRepository(t.Repository) {
+			return fmt.Errorf("target %s: invalid repository %q", t.Name, t.Repository)
+		}
+		if err := ValidateTemplate(cmp.Or(t.Tag, defaultOCITag)); err != nil {
+			return fmt.Errorf("target %s: invalid tag: %w", t.Name, err)
+		}
+	default:
+		return fmt.Errorf("target %s: unknown type %q, allowed values are %s,%s", t.Name, t.Type, CopyTargetOCI, CopyTargetS3)
+	}
+	return nil
+}
+
+// CopyStatus is the status of the copy to a target.
+type CopyStatus struct {
+	State    string `json:"state"`
+	Attempts int    `json:"attempts,omitempty"`
+	Error    string `json:"error,omitempty"`
+	// Reference is the reference of the artifact pushed to OCI targets, rendered once to be stable across attempts.
+	Reference string `json:"reference,omitempty"`
+}
+
+// copyToTargets runs a worker Job per target, copying the exported file. Targets are retried independently,
+// and given up after maxJobFailures attempts: the export is completed, with a warning event.
+func (r *VolumeSnaphotContentReconciler) copyToTargets(ctx context.Context, scope *Scope, m ExportManifest) (ctrl.Result, error) {
+	log := klog.FromContext(ctx)
+	targets, err := scope.ExportCopyTargets()
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if len(targets) == 0 {
+		return ctrl.Result{}, nil
+	}
+	if !r.checkWorkers(ctx, scope) {
+		return ctrl.Result{}, nil
+	}
+	statuses := scope.CopyStatuses()
+	defer scope.SetCopyStatuses(statuses)
+	var res ctrl.Result
+	done := 0
+	for _, t := range targets {
+		status := statuses[t.Name]
+		if status.State == CopyStateCompleted || (status.State == CopyStateFailed && status.Attempts >= maxJobFailures) {
+			done++
+			continue
+		}
+		var job batchv1.Job
+		err := r.k8s.Get(ctx, types.NamespacedName{Namespace: r.workerNamespace, Name: copyJobName(t, scope)}, &job)
+		switch {
+		case apierrors.IsNotFound(err):
+			if err := r.checkPushSource(ctx, t); err != nil {
+				log.V(2).Info("Unable to push export", "target", t.Name, "error", err.Error())
+				status = r.failCopy(scope, t, status, err.Error())
+				break
+			}
+			if err := r.createCopyJob(ctx, scope, m, t, &status); err != nil {
+				return ctrl.Result{}, err
+			}
+			status.State = CopyStateCopying
+		case err != nil:
+			return ctrl.Result{}, fmt.Errorf("unable to fetch job: %w", err)
+		case job.Status.Succeeded > 0:
+			log.V(2).Info("Export is copied", "target", t.Name)
+			status = CopyStatus{State: CopyStateCompleted, Attempts: status.Attempts, Reference: status.Reference}
+			done++
+		case jobFailed(&job):
+			log.V(2).Info("Copy has failed", "target", t.Name, "job", job.Name)
+			if err := r.k8s.Delete(ctx, &job, client.PropagationPolicy(metav1.DeletePropagationBackground)); client.IgnoreNotFound(err) != nil {
+				return ctrl.Result{}, fmt.Errorf("unable to delete job: %w", err)
+			}
+			status = r.failCopy(scope, t, status, jobFailure(&job))
+		default:
+			status.State = CopyStateCopying
+		}
+		switch {
+		case status.State != CopyStateFailed:
+		case status.Attempts >= maxJobFailures:
+			done++
+		default:
+			res.RequeueAfter = 2 * time.Minute
+		}
+		statuses[t.Name] = status
+	}
+	if done < len(targets) && res.IsZero() {
+		log.V(4).Info("Export is being copied", "completed", done, "targets", len(targets))
+		// jobs are owned by the content, which is reconciled when they complete
+		return ctrl.Result{RequeueAfter: time.Hour}, nil
+	}
+	return res, nil
+}
+
+// failCopy records a failed attempt of a copy, and a warning event once the copy is given up.
+func (r *VolumeSnaphotContentReconciler) failCopy(scope *Scope, t CopyTarget, status CopyStatus, reason string) CopyStatus {
+	status = CopyStatus{State: CopyStateFailed, Attempts: status.Attempts + 1, Error: reason, Reference: status.Reference}
+	if status.Attempts >= maxJobFailures {
+		r.recorder.Eventf(scope.snap, corev1.EventTypeWarning, EventReasonCopyFailed,
+			"Copy to target %s has failed after %d attempts: %s", t.Name, status.Attempts, reason)
+	}
+	return status
+}
+
+func copyJobName(t CopyTarget, scope *Scope) string {
+	return workerJobName("copy-"+t.Name, scope)
+}
+
+func (r *VolumeSnaphotContentReconciler) createCopyJob(ctx context.Context, scope *Scope, m ExportManifest, t CopyTarget, status *CopyStatus) error {
+	if t.Type == CopyTargetOCI {
+		return r.createPushJob(ctx, scope, m, t, status)
+	}
+	job := r.workerJob("copy-"+t.Name, scope, "copy",
+		"--bucket="+m.Bucket,
+		"--key="+m.Path,
+		"--target-endpoint="+t.Endpoint,
+		"--target-region="+t.Region,
+		"--target-bucket="+t.Bucket,
+		"--target-key="+t.Prefix+m.Path,
+	)
+	container := &job.Spec.Template.Spec.Containers[0]
+	container.Env = append(container.Env,
+		targetCredential(t, "TARGET_ACCESS_KEY", "access_key"),
+		targetCredential(t, "TARGET_SECRET_KEY", "secret_key"),
+	)
+	return r.createTargetJob(ctx, scope, job, t)
+}
+
+// createPushJob creates the Job pushing the exported file to an OCI target, as an artifact tagged by the tag template.
+func (r *VolumeSnaphotContentReconciler) createPushJob(ctx context.Context, scope *Scope, m ExportManifest, t CopyTarget, status *CopyStatus) error {
+	data, err := r.templateData(ctx, scope)
+	if err != nil {
+		return err
+	}
+	if status.Reference == "" {
+		tag, err := ExecuteTemplate(cmp.Or(t.Tag, defaultOCITag), data)
+		if err != nil {
+			return fmt.Errorf("target %s: %w", t.Name, err)
+		}
+		if tag = oci.SanitizeTag(tag); tag == "" {
+			return fmt.Errorf("target %s: tag is empty", t.Name)
+		}
+		u, _ := url.Parse(t.Endpoint)
+		status.Reference = u.Host + "/" + t.Repository + ":" + tag
+	}
+	config, _ := json.Marshal(oci.Config{
+		Namespace:      data.Namespace,
+		VolumeSnapshot: data.VolumeSnapshot,
+		PVC:            data.PVC,
+		PV:             data.PV,
+		StorageClass:   data.StorageClass,
+		Content:        scope.Name(),
+		Driver:         scope.Driver(),
+		SnapshotID:     m.SnapshotID,
+		Cluster:        data.Cluster,
+		Size:           scope.RestoreSize(),
+		CreationTime:   data.Time,
+		Format:         m.Format,
+		Compression:    m.Compression,
+		Encryption:     m.Encryption,
+		KeyID:          m.KeyID,
+		Kind:           m.Kind,
+		Parent:         m.Parent,
+	})
+	job := r.workerJob("copy-"+t.Name, scope, "push",
+		"--bucket="+m.Bucket,
+		"--key="+m.Path,
+		"--registry="+t.Endpoint,
+		"--repository="+t.Repository,
+		"--tag="+status.Reference[strings.LastIndex(status.Reference, ":")+1:],
+		"--config="+string(config),
+	)
+	if t.Secret != "" {
+		container := &job.Spec.Template.Spec.Containers[0]
+		container.Env = append(container.Env,
+			targetCredential(t, "TARGET_USERNAME", "username"),
+			targetCredential(t, "TARGET_PASSWORD", "password"),
+		)
+	}
+	return r.createTargetJob(ctx, scope, job, t)
+}
+
+// checkPushSource checks that the worker pushing to an OCI target is able to read the exported file:
+// artifacts are streamed from the export bucket, OCI targets do not remove the need to reach OOS.
+func (r *VolumeSnaphotContentReconciler) checkPushSource(ctx context.Context, t CopyTarget) error {
+	if t.Type != CopyTargetOCI {
+		return nil
+	}
+	err := r.k8s.Get(ctx, types.NamespacedName{Namespace: r.workerNamespace, Name: r.workerSecret}, &corev1.Secret{})
+	switch {
+	case apierrors.IsNotFound(err):
+		return fmt.Errorf("artifacts are pushed from the export bucket, and the OOS credentials secret %s/%s is not found",
+			r.workerNamespace, r.workerSecret)
+	case err != nil:
+		return fmt.Errorf("unable to fetch OOS credentials secret: %w", err)
+	}
+	return nil
+}
+
+func targetCredential(t CopyTarget, name, key string) corev1.EnvVar {
+	return corev1.EnvVar{Name: name, ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{
+		LocalObjectReference: corev1.LocalObjectReference{Name: t.Secret},
+		Key:                  key,
+	}}}
+}
+
+func (r *VolumeSnaphotContentReconciler) createTargetJob(ctx context.Context, scope *Scope, job *batchv1.Job, t CopyTarget) error {
+	if err := controllerutil.SetControllerReference(scope.snap, job, r.Scheme); err != nil {
+		return err
+	}
+	if err := r.k8s.Create(ctx, job); err != nil {
+		return fmt.Errorf("unable to create job: %w", err)
+	}
+	klog.FromContext(ctx).V(2).Info("Copy job created", "job", job.Name, "target", t.Name)
+	return nil
+}
+
+// parseCopyTargets parses a JSON list of targets.
+func parseCopyTargets(v string) ([]CopyTarget, error) {
+	if v == "" {
+		return nil, nil
+	}
+	var targets []CopyTarget
+	if err := json.Unmarshal([]byte(v), &targets); err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(targets))
+	for _, t := range targets {
+		if err := t.validate(); err != nil {
+			return nil, err
+		}
+		if slices.Contains(names, t.Name) {
+			return nil, fmt.Errorf("duplicate target %q", t.Name)
+		}
+		names = append(names, t.Name)
+	}
+	return targets, nil
+}
+
+func jobFailure(job *batchv1.Job) string {
+	for _, c := range job.Status.Conditions {
+		if c.Type == batchv1.JobFailed && c.Status == corev1.ConditionTrue {
+			return c.Message
+		}
+	}
+	return ""
+}
@@ -0,0 +1,320 @@
+/*
+SPDX-FileCopyrightText: 2025 Outscale SAS <opensource@outscale.com>
+
+SPDX-License-Identifier: BSD-3-Clause
+*/
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	volumesnapshotv1 "github.com/kubernetes-csi/external-snapshotter/client/v8/apis/volumesnapshot/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// +kubebuilder:rbac:groups=snapshot.storage.k8s.io,resources=volumesnapshotcontents,verbs=create;delete
+// +kubebuilder:rbac:groups=snapshot.storage.k8s.io,resources=volumesnapshots,verbs=create;delete,namespace=system
+// +kubebuilder:rbac:groups="",resources=persistentvolumeclaims,verbs=create;delete,namespace=system
+// +kubebuilder:rbac:groups=storage.k8s.io,resources=storageclasses,verbs=get;list;watch
+
+const (
+	// AnnotationAllowVolumeModeChange allows a snapshot of a filesystem volume to be restored as a block volume.
+	AnnotationAllowVolumeModeChange = "snapshot.storage.kubernetes.io/allow-volume-mode-change"
+	// annotationDefaultStorageClass marks the default storage class of the cluster.
+	annotationDefaultStorageClass = "storageclass.kubernetes.io/is-default-class"
+
+	dataMoverDevice = "/dev/snapshot"
+)
+
+// dataMover exports the snapshots of any CSI driver: the snapshot is restored to a temporary block PVC,
+// and a worker Job streams the block device to the bucket.
+type dataMover struct {
+	r *VolumeSnaphotContentReconciler
+}
+
+func dataMoverName(scope *Scope) string {
+	return "export-" + scope.UID()
+}
+
+// dataMoverExportID returns the ID of a data mover export, stored as the export task ID: the name of the data mover Job,
+// suffixed by the export generation, to be unique across re-exports.
+func dataMoverExportID(scope *Scope) string {
+	return workerJobName("datamove", scope) + "-" + strconv.Itoa(scope.ExportGeneration())
+}
+
+func (d dataMover) Export(ctx context.Context, scope *Scope) (*ExportManifest, ctrl.Result, error) {
+	log := klog.FromContext(ctx)
+	r := d.r
+	var job batchv1.Job
+	err := r.k8s.Get(ctx, types.NamespacedName{Namespace: r.workerNamespace, Name: workerJobName("datamove", scope)}, &job)
+	switch {
+	case apierrors.IsNotFound(err):
+		res, err := d.start(ctx, scope)
+		return nil, res, err
+	case err != nil:
+		return nil, ctrl.Result{}, fmt.Errorf("unable to fetch job: %w", err)
+	}
+	if scope.ExportTaskID() == "" {
+		// exports started before the ID was recorded
+		scope.SetExportTaskID(dataMoverExportID(scope))
+	}
+	switch {
+	case job.Status.Succeeded > 0:
+		var m ExportManifest
+		if err := json.Unmarshal([]byte(job.Annotations[AnnotationExportManifest]), &m); err != nil {
+			return nil, ctrl.Result{}, fmt.Errorf("invalid manifest on job %s: %w", job.Name, err)
+		}
+		if err := d.cleanup(ctx, scope); err != nil {
+			return nil, ctrl.Result{}, err
+		}
+		scope.ClearJobFailures()
+		return &m, ctrl.Result{}, nil
+	case jobFailed(&job):
+		failures, err := r.retryJob(ctx, scope, &job)
+		if err != nil {
+			return nil, ctrl.Result{}, err
+		}
+		if failures >= maxJobFailures {
+			err := fmt.Errorf("data mover has failed: %s", jobFailure(&job))
+			log.V(2).Error(err, "Export has permanently failed")
+			scope.SetExportError(err)
+			return nil, ctrl.Result{}, d.cleanup(ctx, scope)
+		}
+		log.V(2).Info("Data mover has failed, retrying", "job", job.Name, "reason", jobFailure(&job))
+		return nil, ctrl.Result{RequeueAfter: 2 * time.Minute}, nil
+	default:
+		log.V(4).Info("Snapshot is being moved", "job", job.Name)
+		return nil, ctrl.Result{}, nil
+	}
+}
+
+// start restores the snapshot and creates the data mover Job.
+func (d dataMover) start(ctx context.Context, scope *Scope) (ctrl.Result, error) {
+	log := klog.FromContext(ctx)
+	r := d.r
+	ok, err := r.checkStart(ctx, scope, scope.ExportState() == "" && scope.ExportGeneration() == 0)
+	if !ok || err != nil {
+		return ctrl.Result{}, err
+	}
+	handle, found := scope.GetSnapshotID()
+	if !found {
+		log.V(4).Info("Snapshot does not exist yet")
+		// a reconciliation is triggered when the snapshot handle is set, requeuing is only a safety net
+		return ctrl.Result{RequeueAfter: 10 * time.Minute}, nil
+	}
+	size, err := d.size(ctx, scope)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if size == 0 {
+		err := errors.New("unable to find the size of the snapshot: the content has no restore size and the source claim was not found")
+		log.V(2).Error(err, "Unable to export snapshot")
+		scope.SetExportError(err)
+		return ctrl.Result{}, nil
+	}
+	prefix, ok, err := r.exportPrefix(ctx, scope)
+	if !ok || err != nil {
+		return ctrl.Result{}, err
+	}
+	storageClass, err := d.storageClass(ctx, scope)
+	if errors.Is(err, errNoStorageClass) {
+		log.V(2).Error(err, "Unable to export snapshot")
+		scope.SetExportError(err)
+		return ctrl.Result{}, nil
+	}
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	format, _ := scope.ExportFormat()
+	compression, _ := scope.ExportCompression()
+	m := ExportManifest{
+		Bucket:     scope.ExportBucket(),
+		Path:       prefix + scope.Name() + fileExtension(format, compression),
+		Format:     format,
+		SnapshotID: handle,
+	}
+	if exportCompressions[compression].Extension != "" {
+		m.Compression = compression
+	}
+
+	name := dataMoverName(scope)
+	content := &volumesnapshotv1.VolumeSnapshotContent{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Annotations: map[string]string{AnnotationAllowVolumeModeChange: "true"},
+		},
+		Spec: volumesnapshotv1.VolumeSnapshotContentSpec{
+			Driver:           scope.Driver(),
+			DeletionPolicy:   volumesnapshotv1.VolumeSnapshotContentRetain,
+			Source:           volumesnapshotv1.VolumeSnapshotContentSource{SnapshotHandle: &handle},
+			SourceVolumeMode: scope.snap.Spec.SourceVolumeMode,
+			VolumeSnapshotRef: corev1.ObjectReference{
+				Kind:      "VolumeSnapshot",
+				Namespace: r.workerNamespace,
+				Name:      name,
+			},
+		},
+	}
+	snapshot := &volumesnapshotv1.VolumeSnapshot{
+		ObjectMeta: metav1.ObjectMeta{Namespace: r.workerNamespace, Name: name},
+		Spec: volumesnapshotv1.VolumeSnapshotSpec{
+			Source: volumesnapshotv1.VolumeSnapshotSource{VolumeSnapshotContentName: &name},
+		},
+	}
+	pvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Namespace: r.workerNamespace, Name: name},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes:      []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+			StorageClassName: &storageClass,
+			VolumeMode:       new(corev1.PersistentVolumeBlock),
+			DataSource: &corev1.TypedLocalObjectReference{
+				APIGroup: &volumesnapshotv1.SchemeGroupVersion.Group,
+				Kind:     "VolumeSnapshot",
+				Name:     name,
+			},
+			Resources: corev1.VolumeResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: *resource.NewQuantity(size, resource.BinarySI)},
+			},
+		},
+	}
+	job := r.workerJob("datamove", scope, "datamove",
+		"--bucket="+m.Bucket,
+		"--key="+m.Path,
+		"--format="+format,
+		"--compression="+compression,
+		"--device="+dataMoverDevice,
+	)
+	job.Annotations = map[string]string{AnnotationExportManifest: m.String()}
+	pod := &job.Spec.Template.Spec
+	// block devices are only readable by root
+	pod.SecurityContext.RunAsNonRoot = new(false)
+	pod.SecurityContext.RunAsUser = new(int64(0))
+	pod.Volumes = append(pod.Volumes, corev1.Volume{
+		Name: "snapshot",
+		VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+			ClaimName: name, ReadOnly: true,
+		}},
+	})
+	pod.Containers[0].VolumeDevices = append(pod.Containers[0].VolumeDevices, corev1.VolumeDevice{
+		Name: "snapshot", DevicePath: dataMoverDevice,
+	})
+
+	for _, obj := range []client.Object{content, snapshot, pvc, job} {
+		if err := controllerutil.SetControllerReference(scope.snap, obj, r.Scheme); err != nil {
+			return ctrl.Result{}, err
+		}
+		if err := r.k8s.Create(ctx, obj); client.IgnoreAlreadyExists(err) != nil {
+			return ctrl.Result{}, fmt.Errorf("unable to create %T %s: %w", obj, name, err)
+		}
+	}
+	scope.SetExportTaskID(dataMoverExportID(scope))
+	scope.SetExportState(ExportStateMoving)
+	scope.FreezeConfig()
+	log.V(2).Info("Data mover job created", "job", job.Name, "path", m.Path)
+	return ctrl.Result{}, nil
+}
+
+// errNoStorageClass is returned when the storage class of the temporary PVC cannot be chosen.
+var errNoStorageClass = errors.New("unable to choose a storage class")
+
+// size returns the restore size of the snapshot, or else the capacity of its source claim, as some drivers do not report restore sizes.
+// Zero is returned if both are unknown.
+func (d dataMover) size(ctx context.Context, scope *Scope) (int64, error) {
+	if size := scope.RestoreSize(); size > 0 {
+		return size, nil
+	}
+	ref := scope.VolumeSnapshotRef()
+	var vs volumesnapshotv1.VolumeSnapshot
+	err := d.r.k8s.Get(ctx, ref, &vs)
+	switch {
+	case apierrors.IsNotFound(err):
+		return 0, nil
+	case err != nil:
+		return 0, fmt.Errorf("unable to fetch snapshot: %w", err)
+	case vs.Spec.Source.PersistentVolumeClaimName == nil:
+		return 0, nil
+	}
+	var claim corev1.PersistentVolumeClaim
+	err = d.r.k8s.Get(ctx, types.NamespacedName{Namespace: ref.Namespace, Name: *vs.Spec.Source.PersistentVolumeClaimName}, &claim)
+	switch {
+	case apierrors.IsNotFound(err):
+		return 0, nil
+	case err != nil:
+		return 0, fmt.Errorf("unable to fetch claim: %w", err)
+	}
+	if capacity, found := claim.Status.Capacity[corev1.ResourceStorage]; found {
+		return capacity.Value(), nil
+	}
+	return claim.Spec.Resources.Requests.Storage().Value(), nil
+}
+
+// storageClass returns the storage class the snapshot is restored with: exportStorageClass, or else the storage class
+// of the driver of the snapshot, preferring the default one.
+func (d dataMover) storageClass(ctx context.Context, scope *Scope) (string, error) {
+	if sc := scope.ExportStorageClass(); sc != "" {
+		return sc, nil
+	}
+	var classes storagev1.StorageClassList
+	if err := d.r.k8s.List(ctx, &classes); err != nil {
+		return "", fmt.Errorf("unable to list storage classes: %w", err)
+	}
+	var names []string
+	for _, sc := range classes.Items {
+		if sc.Provisioner != scope.Driver() {
+			continue
+		}
+		if sc.Annotations[annotationDefaultStorageClass] == "true" {
+			return sc.Name, nil
+		}
+		names = append(names, sc.Name)
+	}
+	switch len(names) {
+	case 0:
+		return "", fmt.Errorf("%w: driver %s has no storage class, %s is required", errNoStorageClass, scope.Driver(), ParamExportStorageClass)
+	case 1:
+		return names[0], nil
+	default:
+		return "", fmt.Errorf("%w: driver %s has several storage classes, none being the default one, %s is required",
+			errNoStorageClass, scope.Driver(), ParamExportStorageClass)
+	}
+}
+
+// cleanup deletes the temporary PVC, VolumeSnapshot and content, the snapshot being retained.
+func (d dataMover) cleanup(ctx context.Context, scope *Scope) error {
+	r := d.r
+	name := dataMoverName(scope)
+	for _, obj := range []client.Object{
+		&corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Namespace: r.workerNamespace, Name: name}},
+		&volumesnapshotv1.VolumeSnapshot{ObjectMeta: metav1.ObjectMeta{Namespace: r.workerNamespace, Name: name}},
+		&volumesnapshotv1.VolumeSnapshotContent{ObjectMeta: metav1.ObjectMeta{Name: name}},
+	} {
+		if err := r.k8s.Delete(ctx, obj); client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("unable to delete %s: %w", name, err)
+		}
+	}
+	klog.FromContext(ctx).V(3).Info("Data mover resources deleted")
+	return nil
+}
+
+func (d dataMover) DeleteSnapshot(context.Context, *Scope) error {
+	return ErrDeleteUnsupported
+}
+
+func (d dataMover) CanDeleteSnapshot() bool {
+	return false
+}
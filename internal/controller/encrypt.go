@@ -0,0 +1,215 @@
+/*
+SPDX-FileCopyrightText: 2025 Outscale SAS <opensource@outscale.com>
+
+SPDX-License-Identifier: BSD-3-Clause
+*/
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/outscale/csi-snapshot-exporter/internal/encryption"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// +kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;delete,namespace=system
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch,namespace=system
+
+const (
+	// EncryptionKeySecretKey is the key of the encryption key in Secrets.
+	EncryptionKeySecretKey = "key"
+	// AnnotationWorkerKeyID stores the ID of the encryption key on worker Jobs.
+	AnnotationWorkerKeyID = "bsu.csi.outscale.com/export-key-id"
+
+	workerKeyPath = "/keys"
+)
+
+// encrypt runs a worker Job encrypting the exported file.
+func (r *VolumeSnaphotContentReconciler) encrypt(ctx context.Context, scope *Scope) (ctrl.Result, error) {
+	log := klog.FromContext(ctx)
+	m, err := scope.ExportManifest()
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	algorithm, secret, err := scope.ExportEncryption()
+	if err != nil {
+		log.V(2).Error(err, "Unable to encrypt export")
+		return ctrl.Result{}, nil
+	}
+	if !r.checkWorkers(ctx, scope) {
+		return ctrl.Result{}, nil
+	}
+	scope.SetExportState(ExportStateEncrypting)
+
+	var job batchv1.Job
+	err = r.k8s.Get(ctx, types.NamespacedName{Namespace: r.workerNamespace, Name: workerJobName("encrypt", scope)}, &job)
+	switch {
+	case apierrors.IsNotFound(err):
+		return r.createEncryptJob(ctx, scope, m, secret)
+	case err != nil:
+		return ctrl.Result{}, fmt.Errorf("unable to fetch job: %w", err)
+	}
+	switch {
+	case job.Status.Succeeded > 0:
+		m.Path += encryption.Extension
+		m.Encryption = algorithm
+		m.KeyID = job.Annotations[AnnotationWorkerKeyID]
+		scope.SetExportManifest(m)
+		scope.ClearJobFailures()
+		log.V(2).Info("Export is encrypted", "path", m.Path, "key_id", m.KeyID)
+		return r.finalize(ctx, scope)
+	case jobFailed(&job):
+		failures, err := r.retryJob(ctx, scope, &job)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if failures >= maxJobFailures {
+			err := fmt.Errorf("encryption has failed: %s", jobFailure(&job))
+			log.V(2).Error(err, "Export has permanently failed")
+			scope.SetExportError(err)
+			return ctrl.Result{}, nil
+		}
+		log.V(2).Info("Encryption has failed, retrying", "job", job.Name, "reason", jobFailure(&job))
+		return ctrl.Result{RequeueAfter: 2 * time.Minute}, nil
+	default:
+		log.V(4).Info("Export is being encrypted", "job", job.Name)
+		return ctrl.Result{}, nil
+	}
+}
+
+func (r *VolumeSnaphotContentReconciler) createEncryptJob(ctx context.Context, scope *Scope, m ExportManifest, secret string) (ctrl.Result, error) {
+	log := klog.FromContext(ctx)
+	var s corev1.Secret
+	if err := r.k8s.Get(ctx, types.NamespacedName{Namespace: r.workerNamespace, Name: secret}, &s); err != nil {
+		return ctrl.Result{}, fmt.Errorf("unable to fetch encryption key: %w", err)
+	}
+	key := s.Data[EncryptionKeySecretKey]
+	if len(key) != encryption.KeySize {
+		log.V(2).Error(fmt.Errorf("secret %s must store a %d bytes key in %q", secret, encryption.KeySize, EncryptionKeySecretKey), "Unable to encrypt export")
+		return ctrl.Result{}, nil
+	}
+	keyID := encryption.KeyID(key)
+	job := r.workerJob("encrypt", scope, "encrypt",
+		"--bucket="+m.Bucket,
+		"--key="+m.Path,
+		"--key-file="+workerKeyPath+"/"+EncryptionKeySecretKey,
+		"--key-id="+keyID,
+	)
+	job.Annotations = map[string]string{AnnotationWorkerKeyID: keyID}
+	pod := &job.Spec.Template.Spec
+	pod.Volumes = append(pod.Volumes, corev1.Volume{
+		Name:         "key",
+		VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: secret}},
+	})
+	pod.Containers[0].VolumeMounts = append(pod.Containers[0].VolumeMounts, corev1.VolumeMount{
+		Name: "key", MountPath: workerKeyPath, ReadOnly: true,
+	})
+	if err := controllerutil.SetControllerReference(scope.snap, job, r.Scheme); err != nil {
+		return ctrl.Result{}, err
+	}
+	if err := r.k8s.Create(ctx, job); err != nil {
+		return ctrl.Result{}, fmt.Errorf("unable to create job: %w", err)
+	}
+	log.V(2).Info("Encryption job created", "job", job.Name, "key_id", keyID)
+	return ctrl.Result{}, nil
+}
+
+// errNoWorkerImage is returned when a worker Job is required, and --worker-image is not set.
+var errNoWorkerImage = errors.New("--worker-image is required")
+
+// checkWorkers checks that worker Jobs can be run, the export failing otherwise.
+func (r *VolumeSnaphotContentReconciler) checkWorkers(ctx context.Context, scope *Scope) bool {
+	if r.workerImage != "" {
+		return true
+	}
+	klog.FromContext(ctx).V(2).Error(errNoWorkerImage, "Export has permanently failed")
+	scope.SetExportError(errNoWorkerImage)
+	return false
+}
+
+// maxJobFailures is the number of failures of a worker Job after which it is not retried.
+const maxJobFailures = 3
+
+// retryJob deletes a failed worker Job, to be created again, and returns the number of failures of the Job.
+func (r *VolumeSnaphotContentReconciler) retryJob(ctx context.Context, scope *Scope, job *batchv1.Job) (int, error) {
+	if err := r.k8s.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationBackground)); client.IgnoreNotFound(err) != nil {
+		return 0, fmt.Errorf("unable to delete job: %w", err)
+	}
+	return scope.AddJobFailure(), nil
+}
+
+func workerJobName(action string, scope *Scope) string {
+	return action + "-" + scope.UID()
+}
+
+// workerJob builds a Job running a worker command.
+func (r *VolumeSnaphotContentReconciler) workerJob(action string, scope *Scope, args ...string) *batchv1.Job {
+	credential := func(name, key string) corev1.EnvVar {
+		return corev1.EnvVar{Name: name, ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: r.workerSecret},
+			Key:                  key,
+			Optional:             new(true),
+		}}}
+	}
+	labels := map[string]string{
+		"app.kubernetes.io/name":      "csi-snapshot-exporter",
+		"app.kubernetes.io/component": "worker",
+	}
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: r.workerNamespace,
+			Name:      workerJobName(action, scope),
+			Labels:    labels,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit:            new(int32(3)),
+			TTLSecondsAfterFinished: new(int32(24 * 3600)),
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					RestartPolicy:                corev1.RestartPolicyNever,
+					AutomountServiceAccountToken: new(false),
+					SecurityContext: &corev1.PodSecurityContext{
+						RunAsNonRoot:   new(true),
+						SeccompProfile: &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault},
+					},
+					Containers: []corev1.Container{{
+						Name:    "worker",
+						Image:   r.workerImage,
+						Command: []string{"/manager"},
+						Args:    append([]string{"worker"}, args...),
+						Env: []corev1.EnvVar{
+							credential("OSC_ACCESS_KEY", "access_key"),
+							credential("OSC_SECRET_KEY", "secret_key"),
+							credential("OSC_REGION", "region"),
+						},
+						SecurityContext: &corev1.SecurityContext{
+							AllowPrivilegeEscalation: new(false),
+							Capabilities:             &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}},
+						},
+					}},
+				},
+			},
+		},
+	}
+}
+
+func jobFailed(job *batchv1.Job) bool {
+	for _, c := range job.Status.Conditions {
+		if c.Type == batchv1.JobFailed && c.Status == corev1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
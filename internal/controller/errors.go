@@ -0,0 +1,62 @@
+/*
+SPDX-FileCopyrightText: 2025 Outscale SAS <opensource@outscale.com>
+
+SPDX-License-Identifier: BSD-3-Clause
+*/
+package controller
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/outscale/osc-sdk-go/v3/pkg/osc"
+)
+
+// ErrorClass defines how an error is handled.
+type ErrorClass int
+
+const (
+	// ErrorTransient errors are retried with backoff.
+	ErrorTransient ErrorClass = iota
+	// ErrorPermanent errors are reported on the content, and are not retried until the configuration changes.
+	ErrorPermanent
+)
+
+func (c ErrorClass) String() string {
+	switch c {
+	case ErrorPermanent:
+		return "permanent"
+	default:
+		return "transient"
+	}
+}
+
+// ClassifyError returns the class of an error returned by OAPI.
+func ClassifyError(err error) ErrorClass {
+	if _, ok := errors.AsType[*ThrottledError](err); ok {
+		return ErrorTransient
+	}
+	oerr := osc.AsErrorResponse(err)
+	if oerr == nil {
+		// network errors, unexpected responses...
+		return ErrorTransient
+	}
+	switch {
+	case osc.IsAuthError(err):
+		// missing permissions
+		return ErrorPermanent
+	case osc.IsNotFound(err):
+		// unknown snapshot or bucket
+		return ErrorPermanent
+	case osc.IsConflict(err), osc.IsQuotaOrCapacity(err):
+		// resources in an invalid state, quotas exceeded
+		return ErrorTransient
+	}
+	for _, e := range oerr.Errors {
+		// invalid parameters
+		if c, err := strconv.Atoi(e.Code); err == nil && c >= 4000 && c < 5000 {
+			return ErrorPermanent
+		}
+	}
+	return ErrorTransient
+}
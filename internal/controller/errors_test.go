@@ -0,0 +1,44 @@
+/*
+SPDX-FileCopyrightText: 2025 Outscale SAS <opensource@outscale.com>
+
+SPDX-License-Identifier: BSD-3-Clause
+*/
+package controller_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/outscale/csi-snapshot-exporter/internal/controller"
+	"github.com/outscale/osc-sdk-go/v3/pkg/osc"
+	"github.com/stretchr/testify/assert"
+)
+
+func oapiError(status int, code, typ string) error {
+	return fmt.Errorf("HTTP %d: %w", status, &osc.ErrorResponse{Errors: []osc.Errors{{Code: code, Type: typ}}})
+}
+
+func TestClassifyError(t *testing.T) {
+	tcs := []struct {
+		name  string
+		err   error
+		class controller.ErrorClass
+	}{
+		{name: "network error", err: errors.New("connection refused"), class: controller.ErrorTransient},
+		{name: "throttling", err: &controller.ThrottledError{RetryAfter: time.Second, Err: errors.New("503")}, class: controller.ErrorTransient},
+		{name: "missing permissions", err: oapiError(401, "4120", "AccessDenied"), class: controller.ErrorPermanent},
+		{name: "authentication failure", err: oapiError(401, "1", "AccessDenied"), class: controller.ErrorPermanent},
+		{name: "unknown snapshot", err: oapiError(400, "5054", "InvalidResource"), class: controller.ErrorPermanent},
+		{name: "invalid parameter", err: oapiError(400, "4045", "InvalidParameterValue"), class: controller.ErrorPermanent},
+		{name: "conflict", err: oapiError(409, "6031", "InvalidState"), class: controller.ErrorTransient},
+		{name: "quota", err: oapiError(400, "10029", "TooManyResources"), class: controller.ErrorTransient},
+		{name: "server error", err: oapiError(500, "2000", "InternalError"), class: controller.ErrorTransient},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.class, controller.ClassifyError(fmt.Errorf("wrapped: %w", tc.err)))
+		})
+	}
+}
@@ -9,7 +9,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"strings"
+	"slices"
+	"strconv"
 	"time"
 
 	"github.com/outscale/goutils/sdk/ptr"
@@ -18,73 +19,348 @@ import (
 	ctrl "sigs.k8s.io/controller-runtime"
 )
 
-func (r *VolumeSnaphotContentReconciler) export(ctx context.Context, scope *Scope) (ctrl.Result, error) {
+func (r *VolumeSnaphotContentReconciler) export(ctx context.Context, scope *Scope, exporter Exporter) (ctrl.Result, error) {
 	log := klog.FromContext(ctx)
-	var task *osc.SnapshotExportTask
-	if taskID := scope.ExportTaskID(); taskID != "" {
-		res, err := r.oapi.ReadSnapshotExportTasks(ctx, osc.ReadSnapshotExportTasksRequest{
-			Filters: &osc.FiltersSnapshotExportTask{TaskIds: &[]string{taskID}},
-		})
+	if scope.HasPermanentError() {
+		log.V(3).Info("Export has permanently failed, waiting for a configuration change")
+		return ctrl.Result{}, nil
+	}
+	switch scope.ExportState() {
+	case ExportStateDiffing:
+		return r.chain(ctx, scope)
+	case ExportStateEncrypting:
+		return r.encrypt(ctx, scope)
+	case ExportStateFinalizing:
+		return r.finalize(ctx, scope)
+	}
+	manifest, res, err := exporter.Export(ctx, scope)
+	if manifest == nil || err != nil {
+		return res, err
+	}
+	scope.SetExportManifest(*manifest)
+	log.V(2).Info("Export is finished", "path", manifest.Path)
+	if fullEvery, _ := scope.ExportIncremental(); fullEvery > 0 {
+		return r.chain(ctx, scope)
+	}
+	if algorithm, _, _ := scope.ExportEncryption(); algorithm != "" {
+		return r.encrypt(ctx, scope)
+	}
+	return r.finalize(ctx, scope)
+}
+
+// checkStart checks that the export of a content may start: the snapshot is selected and not skipped by sampling
+// on its first attempt, and the configuration is valid. Snapshots not selected are skipped, as snapshots skipped by sampling.
+func (r *VolumeSnaphotContentReconciler) checkStart(ctx context.Context, scope *Scope, first bool) (bool, error) {
+	log := klog.FromContext(ctx)
+	selector, nsSelector, err := scope.ExportSelectors()
+	if err != nil {
+		log.V(2).Error(err, "Unable to export snapshot")
+		return false, nil
+	}
+	every, minInterval, err := scope.ExportSampling()
+	if err != nil {
+		log.V(2).Error(err, "Unable to export snapshot")
+		return false, nil
+	}
+	if first {
+		selected, err := r.isSelected(ctx, scope, selector, nsSelector)
+		switch {
+		case err != nil:
+			return false, err
+		case !selected:
+			log.V(3).Info("Skipping export", "reason", "snapshot does not match the export selectors")
+			scope.SetSkipped("snapshot does not match the export selectors")
+			return false, nil
+		}
+		reason, err := r.skipReason(ctx, scope, every, minInterval)
 		switch {
 		case err != nil:
-			return ctrl.Result{}, fmt.Errorf("unable to read task: %w", err)
-		case len(*res.SnapshotExportTasks) == 0:
-			return ctrl.Result{}, errors.New("no export task found")
+			return false, err
+		case reason != "":
+			log.V(3).Info("Skipping export", "reason", reason)
+			scope.SetSkipped(reason)
+			return false, nil
 		}
-		task = &(*res.SnapshotExportTasks)[0]
-		if task.State == osc.SnapshotExportTaskStateFailed {
+	}
+	if _, err := scope.ExportFormat(); err != nil {
+		log.V(2).Error(err, "Unable to export snapshot")
+		return false, nil
+	}
+	if _, err := scope.ExportCompression(); err != nil {
+		log.V(2).Error(err, "Unable to export snapshot")
+		return false, nil
+	}
+	if _, _, err := scope.ExportEncryption(); err != nil {
+		log.V(2).Error(err, "Unable to export snapshot")
+		return false, nil
+	}
+	if _, err := scope.ExportLock(); err != nil {
+		log.V(2).Error(err, "Unable to export snapshot")
+		return false, nil
+	}
+	if _, err := scope.ExportIncremental(); err != nil {
+		log.V(2).Error(err, "Unable to export snapshot")
+		return false, nil
+	}
+	if _, _, err := scope.ExportTags(&TemplateData{}); err != nil {
+		log.V(2).Error(err, "Unable to export snapshot")
+		return false, nil
+	}
+	if _, err := scope.ExportCopyTargets(); err != nil {
+		log.V(2).Error(err, "Unable to export snapshot")
+		return false, nil
+	}
+	if scope.ExportBucket() == "" {
+		log.V(2).Error(errors.New("bucket is required"), "Unable to export snapshot")
+		return false, nil
+	}
+	return true, nil
+}
+
+// exportPrefix resolves the prefix of the exported file.
+func (r *VolumeSnaphotContentReconciler) exportPrefix(ctx context.Context, scope *Scope) (string, bool, error) {
+	data, err := r.templateData(ctx, scope)
+	if err != nil {
+		return "", false, err
+	}
+	p, err := scope.ExportPrefix(data)
+	if err != nil {
+		klog.FromContext(ctx).V(2).Error(err, "Unable to export snapshot")
+		return "", false, nil
+	}
+	return p, true, nil
+}
+
+// oapiExporter exports BSU snapshots with OAPI snapshot export tasks.
+type oapiExporter struct {
+	r *VolumeSnaphotContentReconciler
+}
+
+func (e oapiExporter) Export(ctx context.Context, scope *Scope) (*ExportManifest, ctrl.Result, error) {
+	log := klog.FromContext(ctx)
+	r := e.r
+	var task *osc.SnapshotExportTask
+	if taskID := scope.ExportTaskID(); taskID != "" {
+		if scope.TaskTagPending() {
+			if err := r.tagTask(ctx, scope, taskID); err != nil {
+				return nil, ctrl.Result{}, err
+			}
+		}
+		if cached, found := r.tasks.Get(taskID); found {
+			task = &cached
+		} else {
+			res, err := r.oapi.ReadSnapshotExportTasks(ctx, osc.ReadSnapshotExportTasksRequest{
+				Filters: &osc.FiltersSnapshotExportTask{TaskIds: &[]string{taskID}},
+			})
+			switch {
+			case err != nil:
+				return nil, ctrl.Result{}, fmt.Errorf("unable to read task: %w", err)
+			case len(ptr.From(res.SnapshotExportTasks)) == 0:
+				return nil, ctrl.Result{}, errors.New("no export task found")
+			}
+			task = &(*res.SnapshotExportTasks)[0]
+			// the cache is refreshed with the task read
+			r.tasks.Untrack(taskID)
+		}
+		// cancelled tasks are terminal, unless a retry is requested
+		if task.State == osc.SnapshotExportTaskStateFailed ||
+			task.State == osc.SnapshotExportTaskStateCancelled && scope.ExportState() == ExportStateRetrying {
+			r.tasks.Untrack(taskID)
 			log.V(3).Info("Retrying failed export")
 			task = nil
 		}
 	}
 	if task == nil {
-		f, err := scope.ExportFormat()
-		if err != nil {
-			log.V(2).Error(err, "Unable to export snapshot")
-			return ctrl.Result{}, nil
-		}
-		b := scope.ExportBucket()
-		if b == "" {
-			log.V(2).Error(errors.New("bucket is required"), "Unable to export snapshot")
-			return ctrl.Result{}, nil
+		ok, err := r.checkStart(ctx, scope, scope.ExportTaskID() == "" && scope.ExportGeneration() == 0)
+		if !ok || err != nil {
+			return nil, ctrl.Result{}, err
 		}
 		id, found := scope.GetSnapshotID()
 		if !found {
 			log.V(4).Info("Snapshot does not exist yet")
-			return ctrl.Result{RequeueAfter: time.Minute}, nil
+			// a reconciliation is triggered when the snapshot handle is set, requeuing is only a safety net
+			return nil, ctrl.Result{RequeueAfter: 10 * time.Minute}, nil
 		}
+		f, _ := scope.ExportFormat()
 		req := osc.CreateSnapshotExportTaskRequest{
 			SnapshotId: id,
 			OsuExport: osc.OsuExportToCreate{
 				DiskImageFormat: f,
-				OsuBucket:       b,
+				OsuBucket:       scope.ExportBucket(),
 			},
 		}
-		if p := scope.ExportPrefix(); p != "" {
+		p, ok, err := r.exportPrefix(ctx, scope)
+		if !ok || err != nil {
+			return nil, ctrl.Result{}, err
+		}
+		if p != "" {
 			req.OsuExport.OsuPrefix = &p
 		}
-		res, err := r.oapi.CreateSnapshotExportTask(ctx, req)
+		task, err = r.findTask(ctx, scope, id)
 		if err != nil {
-			return ctrl.Result{}, fmt.Errorf("unable to create task: %w", err)
+			return nil, ctrl.Result{}, err
+		}
+		if task != nil {
+			log.V(2).Info("Adopting existing export task", "task_id", task.TaskId)
+		} else {
+			if res, err := r.checkSnapshot(ctx, scope, id); err != nil || !res.IsZero() || scope.HasPermanentError() {
+				return nil, res, err
+			}
+			res, err := r.oapi.CreateSnapshotExportTask(ctx, req)
+			if err != nil {
+				err = fmt.Errorf("unable to create task: %w", err)
+				if ClassifyError(err) == ErrorPermanent {
+					log.V(2).Error(err, "Export has permanently failed")
+					scope.SetExportError(err)
+					return nil, ctrl.Result{}, nil
+				}
+				return nil, ctrl.Result{}, err
+			}
+			task = res.SnapshotExportTask
+			scope.ClearExportError()
+			log.V(2).Info("New export task created", "task_id", task.TaskId)
+			// the task is recorded before being tagged: it is not adopted until it is tagged
+			scope.UpdateExportState(task.TaskId, task.State)
+			scope.SetTaskTagPending(true)
+			if err := r.tagTask(ctx, scope, task.TaskId); err != nil {
+				return nil, ctrl.Result{}, err
+			}
 		}
-		task = res.SnapshotExportTask
-		log.V(2).Info("New export task created", "task_id", task.TaskId)
+		// retries are driven by the configuration used to start the export
+		scope.FreezeConfig()
 	}
 	scope.UpdateExportState(task.TaskId, task.State)
 	switch task.State {
+	case osc.SnapshotExportTaskStateCompleted, osc.SnapshotExportTaskStateCancelled, osc.SnapshotExportTaskStateFailed:
+		r.tasks.Untrack(task.TaskId)
+	default:
+		r.tasks.Track(scope.Name(), task.TaskId, *task, scope.RestoreSize())
+	}
+	switch task.State {
 	case osc.SnapshotExportTaskStateCompleted:
-		path := ptr.From(task.OsuExport.OsuPrefix) + task.SnapshotId +
-			strings.TrimPrefix(task.TaskId, "snap-export") + "." + task.OsuExport.DiskImageFormat + ".gz"
-		scope.SetExportPath(path)
-		log.V(2).Info("Export is finished", "task_id", task.TaskId, "state", task.State, "path", path)
-		return ctrl.Result{}, nil
+		return &ExportManifest{
+			Bucket:      task.OsuExport.OsuBucket,
+			Path:        exportPath(task),
+			Format:      task.OsuExport.DiskImageFormat,
+			Compression: DefaultExportCompression,
+			SnapshotID:  task.SnapshotId,
+			TaskID:      task.TaskId,
+		}, ctrl.Result{}, nil
 	case osc.SnapshotExportTaskStateCancelled:
 		log.V(2).Info("Export was cancelled", "task_id", task.TaskId, "state", task.State)
-		return ctrl.Result{}, nil
+		return nil, ctrl.Result{}, nil
 	case osc.SnapshotExportTaskStateFailed:
 		log.V(3).Info("Export has failed, retrying", "task_id", task.TaskId, "state", task.State)
-		return ctrl.Result{RequeueAfter: 2 * time.Minute}, nil
+		return nil, ctrl.Result{RequeueAfter: 2 * time.Minute}, nil
 	}
 	log.V(4).Info("Export is still running", "task_id", task.TaskId, "state", task.State, "progress", task.Progress)
-	return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+	// the task poller triggers a reconciliation on changes, requeuing is only a safety net
+	return nil, ctrl.Result{RequeueAfter: 10 * time.Minute}, nil
+}
+
+func (e oapiExporter) DeleteSnapshot(ctx context.Context, scope *Scope) error {
+	id, found := scope.GetSnapshotID()
+	if !found {
+		return nil
+	}
+	_, err := e.r.oapi.DeleteSnapshot(ctx, osc.DeleteSnapshotRequest{SnapshotId: id})
+	if err != nil && !osc.IsNotFound(err) {
+		return fmt.Errorf("unable to delete snapshot: %w", err)
+	}
+	return nil
+}
+
+func (e oapiExporter) CanDeleteSnapshot() bool {
+	return true
+}
+
+// tagTask tags an export task with the content and the cluster. Tagging is retried until it succeeds.
+func (r *VolumeSnaphotContentReconciler) tagTask(ctx context.Context, scope *Scope, taskID string) error {
+	_, err := r.oapi.CreateTags(ctx, osc.CreateTagsRequest{
+		ResourceIds: []string{taskID},
+		Tags:        r.taskTags(scope),
+	})
+	if err != nil {
+		return fmt.Errorf("unable to tag export task: %w", err)
+	}
+	scope.SetTaskTagPending(false)
+	return nil
+}
+
+func (r *VolumeSnaphotContentReconciler) taskTags(scope *Scope) []osc.ResourceTag {
+	tags := []osc.ResourceTag{
+		{Key: TagContentUID, Value: scope.UID()},
+		{Key: TagClusterID, Value: r.clusterID},
+	}
+	if generation := scope.ExportGeneration(); generation > 0 {
+		tags = append(tags, osc.ResourceTag{Key: TagExportGeneration, Value: strconv.Itoa(generation)})
+	}
+	return tags
+}
+
+// checkSnapshot checks that the BSU snapshot is completed before exporting it.
+// Snapshots in error are permanent failures, the progress of pending snapshots is reported on the content.
+func (r *VolumeSnaphotContentReconciler) checkSnapshot(ctx context.Context, scope *Scope, id string) (ctrl.Result, error) {
+	log := klog.FromContext(ctx)
+	res, err := r.oapi.ReadSnapshots(ctx, osc.ReadSnapshotsRequest{
+		Filters: &osc.FiltersSnapshot{SnapshotIds: &[]string{id}},
+	})
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("unable to read snapshot: %w", err)
+	}
+	snaps := ptr.From(res.Snapshots)
+	if len(snaps) == 0 {
+		err := fmt.Errorf("snapshot %s not found", id)
+		log.V(2).Error(err, "Export has permanently failed")
+		scope.SetExportError(err)
+		return ctrl.Result{}, nil
+	}
+	snap := snaps[0]
+	switch snap.State {
+	case osc.SnapshotStateCompleted:
+		scope.ClearSnapshotProgress()
+		return ctrl.Result{}, nil
+	case osc.SnapshotStateError, osc.SnapshotStateDeleting:
+		err := fmt.Errorf("snapshot %s is in %s state", id, snap.State)
+		log.V(2).Error(err, "Export has permanently failed")
+		scope.ClearSnapshotProgress()
+		scope.SetExportError(err)
+		return ctrl.Result{}, nil
+	default:
+		progress := ptr.From(snap.Progress)
+		log.V(3).Info("Waiting for snapshot to be completed", "state", snap.State, "progress", progress)
+		scope.SetSnapshotProgress(progress)
+		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+	}
+}
+
+// findTask searches for a running or completed task previously created for the content.
+// This makes task creation idempotent if the content was not updated after the task was created.
+func (r *VolumeSnaphotContentReconciler) findTask(ctx context.Context, scope *Scope, snapshotID string) (*osc.SnapshotExportTask, error) {
+	res, err := r.oapi.ReadSnapshotExportTasks(ctx, osc.ReadSnapshotExportTasksRequest{
+		Filters: &osc.FiltersSnapshotExportTask{SnapshotIds: &[]string{snapshotID}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to search tasks: %w", err)
+	}
+	tags := r.taskTags(scope)
+	for _, task := range ptr.From(res.SnapshotExportTasks) {
+		switch task.State {
+		case osc.SnapshotExportTaskStateFailed, osc.SnapshotExportTaskStateCancelled:
+			continue
+		}
+		if hasTags(task.Tags, tags) {
+			return &task, nil
+		}
+	}
+	return nil, nil
+}
+
+func hasTags(tags, expected []osc.ResourceTag) bool {
+	for _, e := range expected {
+		if !slices.Contains(tags, e) {
+			return false
+		}
+	}
+	return true
 }
@@ -0,0 +1,116 @@
+/*
+SPDX-FileCopyrightText: 2025 Outscale SAS <opensource@outscale.com>
+
+SPDX-License-Identifier: BSD-3-Clause
+*/
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/outscale/csi-snapshot-exporter/internal/objectstore"
+	"github.com/outscale/osc-sdk-go/v3/pkg/osc"
+	"k8s.io/klog/v2"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// finalize runs the last post-processing steps on the exported file, then marks the export as completed.
+// Tags and metadata are applied before object lock, as replacing metadata creates a new version of the file.
+func (r *VolumeSnaphotContentReconciler) finalize(ctx context.Context, scope *Scope) (ctrl.Result, error) {
+	scope.SetExportState(ExportStateFinalizing)
+	m, err := scope.ExportManifest()
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if m.Kind != "" {
+		if err := r.writeManifest(ctx, m); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	if err := r.tag(ctx, scope, m); err != nil {
+		return ctrl.Result{}, err
+	}
+	if res, err := r.lock(ctx, scope, m); err != nil || !res.IsZero() {
+		return res, err
+	}
+	if res, err := r.copyToTargets(ctx, scope, m); err != nil || !res.IsZero() {
+		return res, err
+	}
+	scope.SetExportState(string(osc.SnapshotExportTaskStateCompleted))
+	scope.SetCompletedAt(time.Now())
+	if after, enabled, _ := scope.ExportArchive(); enabled {
+		return ctrl.Result{RequeueAfter: max(after, time.Second)}, nil
+	}
+	return ctrl.Result{}, nil
+}
+
+// tag applies tags and user metadata to the exported file.
+func (r *VolumeSnaphotContentReconciler) tag(ctx context.Context, scope *Scope, m ExportManifest) error {
+	if scope.IsTagged() {
+		return nil
+	}
+	data, err := r.templateData(ctx, scope)
+	if err != nil {
+		return err
+	}
+	tags, metadata, err := scope.ExportTags(data)
+	if err != nil {
+		return err
+	}
+	if len(metadata) > 0 {
+		if err := r.store.SetMetadata(ctx, m.Bucket, m.Path, metadata); err != nil {
+			return fmt.Errorf("unable to set metadata: %w", err)
+		}
+	}
+	if len(tags) > 0 {
+		if err := r.store.SetTags(ctx, m.Bucket, m.Path, tags); err != nil {
+			return fmt.Errorf("unable to set tags: %w", err)
+		}
+	}
+	if len(tags) > 0 || len(metadata) > 0 {
+		scope.SetTagged()
+		klog.FromContext(ctx).V(2).Info("Tags and metadata set on export", "tags", len(tags), "metadata", len(metadata))
+	}
+	return nil
+}
+
+// lock applies object lock to the exported file.
+func (r *VolumeSnaphotContentReconciler) lock(ctx context.Context, scope *Scope, m ExportManifest) (ctrl.Result, error) {
+	log := klog.FromContext(ctx)
+	cfg, err := scope.ExportLock()
+	if err == nil {
+		err = r.applyLock(ctx, scope, m, cfg)
+	}
+	scope.SetLockError(err)
+	switch {
+	case errors.Is(err, objectstore.ErrObjectLockDisabled):
+		log.V(2).Error(err, "Unable to lock export", "bucket", m.Bucket)
+		return ctrl.Result{RequeueAfter: time.Hour}, nil
+	case err != nil:
+		return ctrl.Result{}, fmt.Errorf("unable to lock export: %w", err)
+	}
+	return ctrl.Result{}, nil
+}
+
+func (r *VolumeSnaphotContentReconciler) applyLock(ctx context.Context, scope *Scope, m ExportManifest, cfg LockConfig) error {
+	log := klog.FromContext(ctx)
+	if _, found := scope.LockedUntil(); cfg.Mode != "" && !found {
+		until := time.Now().Add(cfg.Retention)
+		if err := r.store.SetRetention(ctx, m.Bucket, m.Path, cfg.Mode, until); err != nil {
+			return err
+		}
+		scope.SetLockedUntil(until)
+		log.V(2).Info("Export is locked", "mode", cfg.Mode, "until", until)
+	}
+	if cfg.LegalHold && !scope.HasLegalHold() {
+		if err := r.store.SetLegalHold(ctx, m.Bucket, m.Path); err != nil {
+			return err
+		}
+		scope.SetLegalHold()
+		log.V(2).Info("Legal hold set on export")
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+/*
+SPDX-FileCopyrightText: 2025 Outscale SAS <opensource@outscale.com>
+
+SPDX-License-Identifier: BSD-3-Clause
+*/
+package controller
+
+import (
+	"encoding/json"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/outscale/goutils/sdk/ptr"
+	"github.com/outscale/osc-sdk-go/v3/pkg/osc"
+)
+
+const (
+	DefaultExportFormat      = "qcow2"
+	DefaultExportCompression = "gzip"
+)
+
+// exportFormats lists the disk image formats written by OAPI snapshot exports and by the data mover, the format being the extension of files.
+// vmdk and vdi are only available for image exports, not snapshot exports.
+var exportFormats = []string{"qcow2", "raw"}
+
+// exportCompression is a compression of exported files.
+type exportCompression struct {
+	// Extension is appended to the path of files, none if empty.
+	Extension string
+	// OAPI is set if OAPI snapshot exports use the compression, other compressions being only written by the data mover.
+	OAPI bool
+}
+
+// exportCompressions lists the compressions of exported files. OAPI always compresses snapshot exports with gzip.
+var exportCompressions = map[string]exportCompression{
+	"gzip": {Extension: "gz", OAPI: true},
+	"none": {},
+}
+
+func allowedValues[V any](m map[string]V) string {
+	return strings.Join(slices.Sorted(maps.Keys(m)), ",")
+}
+
+// fileExtension returns the extension of files exported with a format and a compression.
+func fileExtension(format, compression string) string {
+	if ext := exportCompressions[compression].Extension; ext != "" {
+		return "." + format + "." + ext
+	}
+	return "." + format
+}
+
+// exportPath returns the path of the file exported by a task, always compressed with gzip.
+func exportPath(task *osc.SnapshotExportTask) string {
+	return ptr.From(task.OsuExport.OsuPrefix) + task.SnapshotId + strings.TrimPrefix(task.TaskId, "snap-export") +
+		fileExtension(task.OsuExport.DiskImageFormat, DefaultExportCompression)
+}
+
+// ExportManifest describes an exported file.
+type ExportManifest struct {
+	Bucket string `json:"bucket"`
+	Path   string `json:"path"`
+	Format string `json:"format"`
+	// Compression is the compression of the file, empty if not compressed.
+	Compression string `json:"compression,omitempty"`
+	SnapshotID  string `json:"snapshotId"`
+	TaskID      string `json:"taskId"`
+	// Encryption is the encryption algorithm, and KeyID the ID of the key, if the file is encrypted.
+	Encryption string `json:"encryption,omitempty"`
+	KeyID      string `json:"keyId,omitempty"`
+	// Kind is the kind of the file in an incremental chain. Diffs are applied on top of Chain,
+	// the files from the full image to Parent.
+	Kind   string   `json:"kind,omitempty"`
+	Parent string   `json:"parent,omitempty"`
+	Chain  []string `json:"chain,omitempty"`
+}
+
+func (m ExportManifest) String() string {
+	buf, _ := json.Marshal(m)
+	return string(buf)
+}
@@ -7,13 +7,28 @@ package controller
 
 import (
 	"context"
+	"fmt"
 
+	"github.com/outscale/csi-snapshot-exporter/internal/objectstore"
 	"github.com/outscale/goutils/k8s/sdk"
 	"github.com/outscale/osc-sdk-go/v3/pkg/osc"
+	"github.com/outscale/osc-sdk-go/v3/pkg/profile"
 )
 
-func NewOAPIClient(ctx context.Context, opts sdk.Options) (osc.ClientInterface, error) {
+// NewOAPIClient creates an OAPI client, and returns the profile it uses.
+func NewOAPIClient(ctx context.Context, opts sdk.Options) (*profile.Profile, osc.ClientInterface, error) {
 	ua := "csi-snapshot-exporter/" + Version
-	_, c, err := sdk.NewSDKClient(ctx, ua, opts)
-	return c, err
+	return sdk.NewSDKClient(ctx, ua, opts)
+}
+
+// NewObjectStore creates an OOS client, using p or, if nil, the profile of the environment or of the default configuration file.
+func NewObjectStore(ctx context.Context, p *profile.Profile) (objectstore.Store, error) {
+	if p == nil {
+		var err error
+		p, err = profile.New()
+		if err != nil {
+			return nil, fmt.Errorf("unable to load profile: %w", err)
+		}
+	}
+	return objectstore.NewOOS(ctx, p)
 }
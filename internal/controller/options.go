@@ -0,0 +1,91 @@
+/*
+SPDX-FileCopyrightText: 2025 Outscale SAS <opensource@outscale.com>
+
+SPDX-License-Identifier: BSD-3-Clause
+*/
+package controller
+
+import (
+	"errors"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/spf13/pflag"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Options configures the exporter controller.
+type Options struct {
+	// PollInterval is the interval between two reads of a running export task, when its duration cannot be estimated.
+	// The interval of other tasks is derived from their progress rate or volume size, between MinPollInterval and MaxPollInterval.
+	PollInterval    time.Duration
+	MinPollInterval time.Duration
+	MaxPollInterval time.Duration
+	// Drivers are the CSI drivers whose snapshots are exported, snapshots of other drivers are ignored.
+	Drivers []string
+	// ClusterID identifies the cluster in export task tags.
+	ClusterID string
+	// ExportRequestBuckets are the buckets users may request on-demand exports to, by annotating VolumeSnapshots.
+	ExportRequestBuckets []string
+
+	// OAPIRateLimit is the maximum number of OAPI calls per second, and OAPIBurst the bucket size.
+	OAPIRateLimit float64
+	OAPIBurst     int
+	// ThrottlingDelay is the delay before retrying a throttled call, if OAPI did not send a Retry-After header.
+	ThrottlingDelay time.Duration
+
+	// WorkerImage is the image of worker Jobs, WorkerNamespace their namespace.
+	WorkerImage     string
+	WorkerNamespace string
+	// WorkerCredentialsSecret is the Secret storing the OOS credentials of worker Jobs.
+	WorkerCredentialsSecret string
+}
+
+func (o *Options) AddFlags(fs *pflag.FlagSet) {
+	fs.DurationVar(&o.PollInterval, "export-poll-interval", 30*time.Second,
+		"The interval between two polls of a running export task, when its duration cannot be estimated.")
+	fs.DurationVar(&o.MinPollInterval, "export-min-poll-interval", 5*time.Second, "The minimum interval between two polls of the running export tasks.")
+	fs.DurationVar(&o.MaxPollInterval, "export-max-poll-interval", 10*time.Minute, "The maximum interval between two polls of a running export task.")
+	fs.StringSliceVar(&o.Drivers, "drivers", []string{DriverBSU}, "The CSI drivers whose snapshots are exported.")
+	fs.StringVar(&o.ClusterID, "cluster-id", "", "The ID of the cluster, used to tag export tasks.")
+	fs.StringSliceVar(&o.ExportRequestBuckets, "export-request-buckets", nil,
+		"The buckets on-demand exports may be requested to, by annotating VolumeSnapshots. On-demand exports are disabled if empty.")
+	fs.Float64Var(&o.OAPIRateLimit, "oapi-rate-limit", 5, "The maximum number of OAPI calls per second.")
+	fs.IntVar(&o.OAPIBurst, "oapi-burst", 10, "The maximum burst of OAPI calls.")
+	fs.DurationVar(&o.ThrottlingDelay, "oapi-throttling-delay", 30*time.Second,
+		"The delay before retrying a throttled OAPI call, when no Retry-After header is sent.")
+	fs.StringVar(&o.WorkerImage, "worker-image", "", "The image of worker Jobs, required by the data mover, encryption, incremental exports and copies.")
+	fs.StringVar(&o.WorkerNamespace, "worker-namespace", os.Getenv("POD_NAMESPACE"),
+		"The namespace of worker Jobs and encryption key Secrets, defaults to the namespace of the controller (POD_NAMESPACE).")
+	fs.StringVar(&o.WorkerCredentialsSecret, "worker-credentials-secret", "osc-csi-bsu",
+		"The Secret storing the OOS credentials (access_key, secret_key and region) of worker Jobs.")
+}
+
+// PollIntervals returns the poll intervals of export tasks.
+func (o *Options) PollIntervals() PollIntervals {
+	return PollIntervals{Default: o.PollInterval, Min: o.MinPollInterval, Max: o.MaxPollInterval}
+}
+
+// Validate checks the options.
+func (o *Options) Validate() error {
+	if o.WorkerNamespace == "" {
+		return errors.New("--worker-namespace is required when POD_NAMESPACE is not set")
+	}
+	if o.WorkerImage == "" && slices.ContainsFunc(o.Drivers, func(driver string) bool { return driver != DriverBSU }) {
+		return errors.New("--worker-image is required to export the snapshots of other drivers than " + DriverBSU)
+	}
+	return nil
+}
+
+// CacheOptions restricts the cache of worker Jobs and Secrets to the worker namespace.
+func (o *Options) CacheOptions() cache.Options {
+	byNamespace := cache.ByObject{Namespaces: map[string]cache.Config{o.WorkerNamespace: {}}}
+	return cache.Options{ByObject: map[client.Object]cache.ByObject{
+		&batchv1.Job{}:   byNamespace,
+		&corev1.Secret{}: byNamespace,
+	}}
+}
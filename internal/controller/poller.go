@@ -0,0 +1,262 @@
+/*
+SPDX-FileCopyrightText: 2025 Outscale SAS <opensource@outscale.com>
+
+SPDX-License-Identifier: BSD-3-Clause
+*/
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	volumesnapshotv1 "github.com/kubernetes-csi/external-snapshotter/client/v8/apis/volumesnapshot/v1"
+	"github.com/outscale/osc-sdk-go/v3/pkg/osc"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+const (
+	pollPageSize = 1000
+	// estimatedExportRate is the export throughput assumed until the progress rate of a task is known, in bytes per second.
+	estimatedExportRate = 50 << 20
+)
+
+// PollIntervals configures the interval between two polls of a task.
+type PollIntervals struct {
+	Default, Min, Max time.Duration
+}
+
+// Next returns the interval before the next poll of a task, about half of its estimated remaining time.
+func (i PollIntervals) Next(size int64, progress int, rate float64) time.Duration {
+	var next time.Duration
+	switch {
+	case rate > 0:
+		next = time.Duration(float64(100-progress) / rate / 2 * float64(time.Second))
+	case size > 0:
+		next = time.Duration(size/estimatedExportRate) * time.Second / 10
+	default:
+		next = i.Default
+	}
+	return min(max(next, i.Min), i.Max)
+}
+
+// TaskPoller polls the export tasks of all contents in batch, and triggers a reconciliation when a task changes.
+type TaskPoller struct {
+	oapi      osc.ClientInterface
+	intervals PollIntervals
+	events    chan event.GenericEvent
+
+	mu    sync.RWMutex
+	tasks map[string]*trackedTask
+}
+
+type trackedTask struct {
+	task  osc.SnapshotExportTask
+	owner string
+	size  int64
+
+	due             time.Time
+	polledAt        time.Time
+	sampledAt       time.Time
+	sampledProgress int
+	rate            float64
+}
+
+func NewTaskPoller(oapi osc.ClientInterface, intervals PollIntervals) *TaskPoller {
+	return &TaskPoller{
+		oapi:      oapi,
+		intervals: intervals,
+		events:    make(chan event.GenericEvent, 100),
+		tasks:     map[string]*trackedTask{},
+	}
+}
+
+// Get returns the cached version of a task, unless it was not read for more than Max.
+func (p *TaskPoller) Get(taskID string) (osc.SnapshotExportTask, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	t, found := p.tasks[taskID]
+	if !found || time.Since(t.polledAt) > p.intervals.Max {
+		return osc.SnapshotExportTask{}, false
+	}
+	return t.task, true
+}
+
+// Track adds a task to the cache, owned by the content named owner. size is the size of the volume, in bytes.
+func (p *TaskPoller) Track(owner, taskID string, task osc.SnapshotExportTask, size int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if t, found := p.tasks[taskID]; found {
+		t.owner, t.size = owner, size
+		return
+	}
+	now := time.Now()
+	p.tasks[taskID] = &trackedTask{
+		task:            task,
+		owner:           owner,
+		size:            size,
+		due:             now,
+		polledAt:        now,
+		sampledAt:       now,
+		sampledProgress: task.Progress,
+	}
+}
+
+// Untrack removes a task from the cache.
+func (p *TaskPoller) Untrack(taskID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.tasks, taskID)
+}
+
+// Source returns the source of the events triggered by task changes.
+func (p *TaskPoller) Source() source.Source {
+	return source.Channel(p.events, &handler.EnqueueRequestForObject{})
+}
+
+// Start polls tasks until the context is cancelled.
+func (p *TaskPoller) Start(ctx context.Context) error {
+	log := klog.FromContext(ctx).WithName("poller")
+	timer := time.NewTimer(p.intervals.Min)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-timer.C:
+		}
+		err := p.Poll(ctx)
+		next := p.untilNextPoll()
+		if terr, ok := errors.AsType[*ThrottledError](err); ok {
+			next = max(next, terr.RetryAfter)
+		}
+		if err != nil {
+			log.V(2).Error(err, "Unable to poll export tasks")
+		}
+		timer.Reset(next)
+	}
+}
+
+// untilNextPoll returns the delay until the next due task, at least Min to batch calls.
+func (p *TaskPoller) untilNextPoll() time.Duration {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	next := p.intervals.Max
+	for _, t := range p.tasks {
+		next = min(next, time.Until(t.due))
+	}
+	if len(p.tasks) == 0 {
+		next = p.intervals.Min
+	}
+	return max(next, p.intervals.Min)
+}
+
+// NeedLeaderElection ensures that only the leader polls tasks.
+func (p *TaskPoller) NeedLeaderElection() bool {
+	return true
+}
+
+// Poll reads all due tasks, and triggers a reconciliation of the owners of the tasks that have changed.
+func (p *TaskPoller) Poll(ctx context.Context) error {
+	window := time.Now().Add(p.intervals.Min / 2)
+	p.mu.RLock()
+	var ids []string
+	for id, t := range p.tasks {
+		if !t.due.After(window) {
+			ids = append(ids, id)
+		}
+	}
+	p.mu.RUnlock()
+	if len(ids) == 0 {
+		return nil
+	}
+	slices.Sort(ids)
+
+	req := osc.ReadSnapshotExportTasksRequest{
+		Filters:        &osc.FiltersSnapshotExportTask{TaskIds: &ids},
+		ResultsPerPage: new(pollPageSize),
+	}
+	missing := map[string]bool{}
+	for _, id := range ids {
+		missing[id] = true
+	}
+	for {
+		res, err := p.oapi.ReadSnapshotExportTasks(ctx, req)
+		if err != nil {
+			return fmt.Errorf("unable to read tasks: %w", err)
+		}
+		if res.SnapshotExportTasks != nil {
+			for _, task := range *res.SnapshotExportTasks {
+				delete(missing, task.TaskId)
+				if err := p.update(ctx, task); err != nil {
+					return err
+				}
+			}
+		}
+		if res.NextPageToken == nil || *res.NextPageToken == "" {
+			break
+		}
+		req.NextPageToken = res.NextPageToken
+	}
+	p.postpone(ctx, missing)
+	return nil
+}
+
+// postpone postpones the next poll of tasks missing from the responses, e.g. deleted tasks.
+func (p *TaskPoller) postpone(ctx context.Context, missing map[string]bool) {
+	if len(missing) == 0 {
+		return
+	}
+	due := time.Now().Add(p.intervals.Max)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for id := range missing {
+		if t, tracked := p.tasks[id]; tracked {
+			klog.FromContext(ctx).V(3).Info("Export task not found", "task_id", id, "next_poll", due)
+			t.due = due
+		}
+	}
+}
+
+func (p *TaskPoller) update(ctx context.Context, task osc.SnapshotExportTask) error {
+	now := time.Now()
+	p.mu.Lock()
+	t, tracked := p.tasks[task.TaskId]
+	if !tracked {
+		p.mu.Unlock()
+		return nil
+	}
+	changed := t.task.State != task.State || t.task.Progress != task.Progress
+	if task.Progress > t.sampledProgress {
+		if elapsed := now.Sub(t.sampledAt).Seconds(); elapsed > 0 {
+			t.rate = float64(task.Progress-t.sampledProgress) / elapsed
+		}
+		t.sampledAt, t.sampledProgress = now, task.Progress
+	}
+	t.task, t.polledAt = task, now
+	next := p.intervals.Next(t.size, task.Progress, t.rate)
+	t.due = now.Add(next)
+	owner := t.owner
+	p.mu.Unlock()
+
+	log := klog.FromContext(ctx)
+	log.V(5).Info("Next poll of export task", "task_id", task.TaskId, "in", next)
+	if !changed {
+		return nil
+	}
+
+	log.V(5).Info("Export task has changed", "task_id", task.TaskId, "state", task.State, "progress", task.Progress)
+	select {
+	case p.events <- event.GenericEvent{Object: &volumesnapshotv1.VolumeSnapshotContent{ObjectMeta: metav1.ObjectMeta{Name: owner}}}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
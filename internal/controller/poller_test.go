@@ -0,0 +1,105 @@
+/*
+SPDX-FileCopyrightText: 2025 Outscale SAS <opensource@outscale.com>
+
+SPDX-License-Identifier: BSD-3-Clause
+*/
+package controller_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/outscale/csi-snapshot-exporter/internal/controller"
+	"github.com/outscale/goutils/sdk/mocks_osc"
+	"github.com/outscale/osc-sdk-go/v3/pkg/osc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+var intervals = controller.PollIntervals{Default: 30 * time.Second, Min: 5 * time.Second, Max: 10 * time.Minute}
+
+func TestTaskPoller(t *testing.T) {
+	t.Run("Nothing is polled when no task is tracked", func(t *testing.T) {
+		mockCtl := gomock.NewController(t)
+		defer mockCtl.Finish()
+		p := controller.NewTaskPoller(mocks_osc.NewMockClient(mockCtl), intervals)
+		require.NoError(t, p.Poll(t.Context()))
+	})
+	t.Run("All pages are read and only changed tasks trigger a reconciliation", func(t *testing.T) {
+		mockCtl := gomock.NewController(t)
+		defer mockCtl.Finish()
+		mockOAPI := mocks_osc.NewMockClient(mockCtl)
+		p := controller.NewTaskPoller(mockOAPI, intervals)
+		p.Track("vsc-a", "snap-export-a", osc.SnapshotExportTask{TaskId: "snap-export-a", State: osc.SnapshotExportTaskStatePending}, 0)
+		p.Track("vsc-b", "snap-export-b", osc.SnapshotExportTask{TaskId: "snap-export-b", State: osc.SnapshotExportTaskStateUploading, Progress: 10}, 10<<30)
+		mockOAPI.EXPECT().ReadSnapshotExportTasks(gomock.Any(), gomock.Eq(osc.ReadSnapshotExportTasksRequest{
+			Filters:        &osc.FiltersSnapshotExportTask{TaskIds: &[]string{"snap-export-a", "snap-export-b"}},
+			ResultsPerPage: new(1000),
+		})).
+			Return(&osc.ReadSnapshotExportTasksResponse{
+				SnapshotExportTasks: &[]osc.SnapshotExportTask{{TaskId: "snap-export-a", State: osc.SnapshotExportTaskStatePending}},
+				NextPageToken:       new("next"),
+			}, nil)
+		mockOAPI.EXPECT().ReadSnapshotExportTasks(gomock.Any(), gomock.Eq(osc.ReadSnapshotExportTasksRequest{
+			Filters:        &osc.FiltersSnapshotExportTask{TaskIds: &[]string{"snap-export-a", "snap-export-b"}},
+			ResultsPerPage: new(1000),
+			NextPageToken:  new("next"),
+		})).
+			Return(&osc.ReadSnapshotExportTasksResponse{
+				SnapshotExportTasks: &[]osc.SnapshotExportTask{{TaskId: "snap-export-b", State: osc.SnapshotExportTaskStateUploading, Progress: 50}},
+			}, nil)
+		require.NoError(t, p.Poll(t.Context()))
+		task, found := p.Get("snap-export-b")
+		require.True(t, found)
+		assert.Equal(t, 50, task.Progress)
+	})
+	t.Run("Tasks are not polled before they are due", func(t *testing.T) {
+		mockCtl := gomock.NewController(t)
+		defer mockCtl.Finish()
+		mockOAPI := mocks_osc.NewMockClient(mockCtl)
+		p := controller.NewTaskPoller(mockOAPI, intervals)
+		p.Track("vsc-a", "snap-export-a", osc.SnapshotExportTask{TaskId: "snap-export-a", State: osc.SnapshotExportTaskStatePending}, 0)
+		mockOAPI.EXPECT().ReadSnapshotExportTasks(gomock.Any(), gomock.Any()).
+			Return(&osc.ReadSnapshotExportTasksResponse{
+				SnapshotExportTasks: &[]osc.SnapshotExportTask{{TaskId: "snap-export-a", State: osc.SnapshotExportTaskStatePending}},
+			}, nil)
+		require.NoError(t, p.Poll(t.Context()))
+		require.NoError(t, p.Poll(t.Context()))
+	})
+	t.Run("Missing tasks are postponed, and expire from the cache", func(t *testing.T) {
+		mockCtl := gomock.NewController(t)
+		defer mockCtl.Finish()
+		mockOAPI := mocks_osc.NewMockClient(mockCtl)
+		p := controller.NewTaskPoller(mockOAPI, controller.PollIntervals{Default: time.Millisecond, Min: time.Millisecond, Max: 50 * time.Millisecond})
+		p.Track("vsc-a", "snap-export-a", osc.SnapshotExportTask{TaskId: "snap-export-a", State: osc.SnapshotExportTaskStatePending}, 0)
+		mockOAPI.EXPECT().ReadSnapshotExportTasks(gomock.Any(), gomock.Any()).
+			Return(&osc.ReadSnapshotExportTasksResponse{SnapshotExportTasks: &[]osc.SnapshotExportTask{}}, nil)
+		require.NoError(t, p.Poll(t.Context()))
+		require.NoError(t, p.Poll(t.Context()))
+		_, found := p.Get("snap-export-a")
+		assert.True(t, found)
+		time.Sleep(60 * time.Millisecond)
+		_, found = p.Get("snap-export-a")
+		assert.False(t, found)
+	})
+}
+
+func TestPollIntervals(t *testing.T) {
+	t.Run("The default interval is used when nothing is known", func(t *testing.T) {
+		assert.Equal(t, 30*time.Second, intervals.Next(0, 0, 0))
+	})
+	t.Run("Small volumes are polled often", func(t *testing.T) {
+		assert.Equal(t, 5*time.Second, intervals.Next(1<<30, 0, 0))
+	})
+	t.Run("Huge volumes are polled less often", func(t *testing.T) {
+		assert.Equal(t, 10*time.Minute, intervals.Next(2<<40, 0, 0))
+		assert.Equal(t, 2048*time.Second/10, intervals.Next(100<<30, 0, 0))
+	})
+	t.Run("The progress rate is used when known", func(t *testing.T) {
+		// 1% per minute, 60% left
+		assert.Equal(t, 10*time.Minute, intervals.Next(1<<30, 40, 1.0/60))
+		// 1% per second, 20% left
+		assert.Equal(t, 10*time.Second, intervals.Next(2<<40, 80, 1))
+	})
+}
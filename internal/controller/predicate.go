@@ -0,0 +1,50 @@
+/*
+SPDX-FileCopyrightText: 2025 Outscale SAS <opensource@outscale.com>
+
+SPDX-License-Identifier: BSD-3-Clause
+*/
+package controller
+
+import (
+	"maps"
+	"slices"
+
+	volumesnapshotv1 "github.com/kubernetes-csi/external-snapshotter/client/v8/apis/volumesnapshot/v1"
+	"github.com/outscale/goutils/sdk/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// ContentPredicate filters the updates of contents that may change the export:
+// spec, annotations, finalizers, deletion, and readiness of the snapshot.
+func ContentPredicate() predicate.Predicate {
+	return predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			old, ok := e.ObjectOld.(*volumesnapshotv1.VolumeSnapshotContent)
+			if !ok {
+				return true
+			}
+			updated, ok := e.ObjectNew.(*volumesnapshotv1.VolumeSnapshotContent)
+			if !ok {
+				return true
+			}
+			return old.Generation != updated.Generation ||
+				!maps.Equal(old.Annotations, updated.Annotations) ||
+				!slices.Equal(old.Finalizers, updated.Finalizers) ||
+				!old.DeletionTimestamp.Equal(updated.DeletionTimestamp) ||
+				readyToUse(old) != readyToUse(updated) ||
+				snapshotHandle(old) != snapshotHandle(updated)
+		},
+	}
+}
+
+func readyToUse(snap *volumesnapshotv1.VolumeSnapshotContent) bool {
+	return snap.Status != nil && ptr.From(snap.Status.ReadyToUse)
+}
+
+func snapshotHandle(snap *volumesnapshotv1.VolumeSnapshotContent) string {
+	if snap.Status == nil {
+		return ""
+	}
+	return ptr.From(snap.Status.SnapshotHandle)
+}
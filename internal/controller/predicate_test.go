@@ -0,0 +1,81 @@
+/*
+SPDX-FileCopyrightText: 2025 Outscale SAS <opensource@outscale.com>
+
+SPDX-License-Identifier: BSD-3-Clause
+*/
+package controller_test
+
+import (
+	"testing"
+
+	snapshotv1 "github.com/kubernetes-csi/external-snapshotter/client/v8/apis/volumesnapshot/v1"
+	"github.com/outscale/csi-snapshot-exporter/internal/controller"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+func TestContentPredicate(t *testing.T) {
+	p := controller.ContentPredicate()
+	old := &snapshotv1.VolumeSnapshotContent{
+		ObjectMeta: metav1.ObjectMeta{Name: "vsc", Generation: 1},
+		Status:     &snapshotv1.VolumeSnapshotContentStatus{ReadyToUse: new(false)},
+	}
+	update := func(updated *snapshotv1.VolumeSnapshotContent) bool {
+		return p.Update(event.UpdateEvent{ObjectOld: old, ObjectNew: updated})
+	}
+	t.Run("Status updates are ignored", func(t *testing.T) {
+		updated := old.DeepCopy()
+		updated.Status.RestoreSize = new(int64(10 << 30))
+		assert.False(t, update(updated))
+	})
+	t.Run("Readiness changes trigger a reconciliation", func(t *testing.T) {
+		updated := old.DeepCopy()
+		updated.Status.ReadyToUse = new(true)
+		assert.True(t, update(updated))
+		updated = old.DeepCopy()
+		updated.Status.SnapshotHandle = new("snap-foo")
+		assert.True(t, update(updated))
+	})
+	t.Run("Annotation changes trigger a reconciliation", func(t *testing.T) {
+		updated := old.DeepCopy()
+		updated.Annotations = map[string]string{controller.AnnotationExportBucket: "bucket"}
+		assert.True(t, update(updated))
+	})
+	t.Run("Spec changes trigger a reconciliation", func(t *testing.T) {
+		updated := old.DeepCopy()
+		updated.Generation = 2
+		assert.True(t, update(updated))
+	})
+}
+
+func TestExportRequestPredicate(t *testing.T) {
+	p := controller.ExportRequestPredicate()
+	old := &snapshotv1.VolumeSnapshot{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "vs"},
+		Status:     &snapshotv1.VolumeSnapshotStatus{BoundVolumeSnapshotContentName: new("vsc")},
+	}
+	update := func(updated *snapshotv1.VolumeSnapshot) bool {
+		return p.Update(event.UpdateEvent{ObjectOld: old, ObjectNew: updated})
+	}
+	t.Run("Snapshots without request are ignored", func(t *testing.T) {
+		assert.False(t, p.Create(event.CreateEvent{Object: old}))
+		updated := old.DeepCopy()
+		updated.Labels = map[string]string{"team": "db"}
+		assert.False(t, update(updated))
+	})
+	t.Run("Requests trigger a reconciliation of the content", func(t *testing.T) {
+		updated := old.DeepCopy()
+		updated.Annotations = map[string]string{controller.AnnotationExportRequest: "bucket"}
+		assert.True(t, update(updated))
+		assert.True(t, p.Create(event.CreateEvent{Object: updated}))
+		assert.Equal(t, "vsc", controller.ContentOfSnapshot(t.Context(), updated)[0].Name)
+	})
+	t.Run("Status reports are ignored", func(t *testing.T) {
+		old := old.DeepCopy()
+		old.Annotations = map[string]string{controller.AnnotationExportRequest: "bucket"}
+		updated := old.DeepCopy()
+		updated.Annotations[controller.AnnotationExportRequestStatus] = `{"state":"pending"}`
+		assert.False(t, p.Update(event.UpdateEvent{ObjectOld: old, ObjectNew: updated}))
+	})
+}
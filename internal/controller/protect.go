@@ -0,0 +1,58 @@
+/*
+SPDX-FileCopyrightText: 2025 Outscale SAS <opensource@outscale.com>
+
+SPDX-License-Identifier: BSD-3-Clause
+*/
+package controller
+
+import (
+	"context"
+	"errors"
+
+	volumesnapshotv1 "github.com/kubernetes-csi/external-snapshotter/client/v8/apis/volumesnapshot/v1"
+	"k8s.io/klog/v2"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// FinalizerExportProtection prevents the deletion of contents until their export is completed.
+const FinalizerExportProtection = "bsu.csi.outscale.com/export-protection"
+
+// protect adds the export protection to contents whose export has started, and removes it once the export is done.
+// As the CSI sidecar deletes snapshots whatever the finalizers, their deletion policy is switched to Retain if the exporter can delete them.
+func (r *VolumeSnaphotContentReconciler) protect(ctx context.Context, scope *Scope, exporter Exporter) {
+	log := klog.FromContext(ctx)
+	switch {
+	case scope.ExportProtection() && scope.NeedsExport() && scope.ExportStarted() && !scope.ReleaseRequested():
+		if !scope.HasFinalizer() {
+			log.V(3).Info("Protecting snapshot until its export is completed")
+			scope.AddFinalizer(exporter.CanDeleteSnapshot())
+		}
+	case scope.HasFinalizer():
+		log.V(3).Info("Releasing snapshot protection")
+		scope.RemoveFinalizer()
+	}
+}
+
+// release releases the protection of a content being deleted, once its export is done or if its release is requested.
+func (r *VolumeSnaphotContentReconciler) release(ctx context.Context, scope *Scope, exporter Exporter) (ctrl.Result, error) {
+	log := klog.FromContext(ctx)
+	if scope.NeedsExport() && !scope.ReleaseRequested() {
+		log.V(3).Info("Snapshot is being deleted, waiting for its export")
+		return r.export(ctx, scope, exporter)
+	}
+	if scope.RetainedDeletionPolicy() == volumesnapshotv1.VolumeSnapshotContentDelete && !scope.SnapshotDeleted() {
+		err := exporter.DeleteSnapshot(ctx, scope)
+		switch {
+		case errors.Is(err, ErrDeleteUnsupported):
+			log.V(2).Error(err, "Unable to delete snapshot, keeping it")
+		case err != nil:
+			return ctrl.Result{}, err
+		default:
+			id, _ := scope.GetSnapshotID()
+			log.V(2).Info("Snapshot deleted", "snapshot_id", id)
+		}
+	}
+	log.V(3).Info("Releasing snapshot protection")
+	scope.RemoveFinalizer()
+	return ctrl.Result{}, nil
+}
@@ -0,0 +1,168 @@
+/*
+SPDX-FileCopyrightText: 2025 Outscale SAS <opensource@outscale.com>
+
+SPDX-License-Identifier: BSD-3-Clause
+*/
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/outscale/osc-sdk-go/v3/pkg/middleware"
+	"github.com/outscale/osc-sdk-go/v3/pkg/osc"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var throttledCalls = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "snapshot_exporter_oapi_throttled_calls_total",
+	Help: "Number of OAPI calls that were throttled or failed with a server error.",
+}, []string{"operation", "code"})
+
+func init() {
+	metrics.Registry.MustRegister(throttledCalls)
+}
+
+// ThrottledError is returned when OAPI throttles a call or fails with a server error.
+type ThrottledError struct {
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *ThrottledError) Error() string {
+	return fmt.Sprintf("throttled, retry after %s: %v", e.RetryAfter, e.Err)
+}
+
+func (e *ThrottledError) Unwrap() error {
+	return e.Err
+}
+
+// RateLimitedClient wraps an OAPI client with a client-side token bucket rate limiter.
+// Throttled calls return a ThrottledError.
+type RateLimitedClient struct {
+	osc.ClientInterface
+
+	limiter    *rate.Limiter
+	retryAfter time.Duration
+}
+
+func NewRateLimitedClient(c osc.ClientInterface, opts Options) *RateLimitedClient {
+	return &RateLimitedClient{
+		ClientInterface: c,
+		limiter:         rate.NewLimiter(rate.Limit(opts.OAPIRateLimit), opts.OAPIBurst),
+		retryAfter:      opts.ThrottlingDelay,
+	}
+}
+
+func (c *RateLimitedClient) ReadSnapshotExportTasks(ctx context.Context, req osc.ReadSnapshotExportTasksRequest,
+	opts ...middleware.MiddlewareChainOption) (*osc.ReadSnapshotExportTasksResponse, error) {
+	return call(ctx, c, "ReadSnapshotExportTasks", c.ClientInterface.ReadSnapshotExportTasks, req, opts)
+}
+
+func (c *RateLimitedClient) CreateSnapshotExportTask(ctx context.Context, req osc.CreateSnapshotExportTaskRequest,
+	opts ...middleware.MiddlewareChainOption) (*osc.CreateSnapshotExportTaskResponse, error) {
+	return call(ctx, c, "CreateSnapshotExportTask", c.ClientInterface.CreateSnapshotExportTask, req, opts)
+}
+
+func (c *RateLimitedClient) CreateTags(ctx context.Context, req osc.CreateTagsRequest,
+	opts ...middleware.MiddlewareChainOption) (*osc.CreateTagsResponse, error) {
+	return call(ctx, c, "CreateTags", c.ClientInterface.CreateTags, req, opts)
+}
+
+func (c *RateLimitedClient) ReadSnapshots(ctx context.Context, req osc.ReadSnapshotsRequest,
+	opts ...middleware.MiddlewareChainOption) (*osc.ReadSnapshotsResponse, error) {
+	return call(ctx, c, "ReadSnapshots", c.ClientInterface.ReadSnapshots, req, opts)
+}
+
+func (c *RateLimitedClient) DeleteSnapshot(ctx context.Context, req osc.DeleteSnapshotRequest,
+	opts ...middleware.MiddlewareChainOption) (*osc.DeleteSnapshotResponse, error) {
+	return call(ctx, c, "DeleteSnapshot", c.ClientInterface.DeleteSnapshot, req, opts)
+}
+
+type oapiCall[Req, Res any] func(context.Context, Req, ...middleware.MiddlewareChainOption) (Res, error)
+
+func call[Req, Res any](ctx context.Context, c *RateLimitedClient, op string, fn oapiCall[Req, Res], req Req,
+	opts []middleware.MiddlewareChainOption) (Res, error) {
+	throttling := &throttlingMiddleware{limiter: c.limiter}
+	// the SDK rate limiter is replaced by ours
+	opts = append(opts, middleware.WithMiddleware(middleware.MiddlewareSlotRateLimit, throttling))
+	res, err := fn(ctx, req, opts...)
+	if err == nil {
+		return res, nil
+	}
+	code, retryAfter, throttled := throttling.result()
+	if !throttled {
+		return res, err
+	}
+	throttledCalls.WithLabelValues(op, strconv.Itoa(code)).Inc()
+	if retryAfter == 0 {
+		retryAfter = c.retryAfter
+	}
+	return res, &ThrottledError{RetryAfter: retryAfter, Err: err}
+}
+
+// throttlingMiddleware waits for the rate limiter before each HTTP request, and records the last throttling response.
+type throttlingMiddleware struct {
+	limiter *rate.Limiter
+
+	mu         sync.Mutex
+	code       int
+	retryAfter time.Duration
+}
+
+func (m *throttlingMiddleware) Decorate(next http.RoundTripper) http.RoundTripper {
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if err := m.limiter.Wait(req.Context()); err != nil {
+			return nil, err
+		}
+		resp, err := next.RoundTrip(req)
+		if resp != nil {
+			m.record(resp)
+		}
+		return resp, err
+	})
+}
+
+func (m *throttlingMiddleware) record(resp *http.Response) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode >= http.StatusInternalServerError:
+		m.code = resp.StatusCode
+		m.retryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+	default:
+		m.code = 0
+		m.retryAfter = 0
+	}
+}
+
+func (m *throttlingMiddleware) result() (code int, retryAfter time.Duration, throttled bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.code, m.retryAfter, m.code != 0
+}
+
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if s, err := strconv.Atoi(v); err == nil && s > 0 {
+		return time.Duration(s) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(time.Until(t), 0)
+	}
+	return 0
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
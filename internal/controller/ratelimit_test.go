@@ -0,0 +1,106 @@
+/*
+SPDX-FileCopyrightText: 2025 Outscale SAS <opensource@outscale.com>
+
+SPDX-License-Identifier: BSD-3-Clause
+*/
+package controller_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/outscale/csi-snapshot-exporter/internal/controller"
+	"github.com/outscale/goutils/sdk/mocks_osc"
+	"github.com/outscale/osc-sdk-go/v3/pkg/middleware"
+	"github.com/outscale/osc-sdk-go/v3/pkg/osc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+// roundTrip simulates an SDK call, by sending a request through the middlewares passed to the client.
+func roundTrip(t *testing.T, url string) func(ctx context.Context, _ osc.CreateTagsRequest, opts ...middleware.MiddlewareChainOption) (*osc.CreateTagsResponse, error) {
+	return func(ctx context.Context, _ osc.CreateTagsRequest, opts ...middleware.MiddlewareChainOption) (*osc.CreateTagsResponse, error) {
+		chain, err := middleware.NewMiddlewareChain(opts...)
+		require.NoError(t, err)
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, nil)
+		require.NoError(t, err)
+		resp, err := chain.RoundTrip(req)
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("unexpected response status %s", resp.Status)
+		}
+		return &osc.CreateTagsResponse{}, nil
+	}
+}
+
+func TestRateLimitedClient(t *testing.T) {
+	opts := controller.Options{
+		OAPIRateLimit:   100,
+		OAPIBurst:       1,
+		ThrottlingDelay: time.Minute,
+	}
+	t.Run("Successful calls are returned as-is", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+		defer srv.Close()
+		mockCtl := gomock.NewController(t)
+		defer mockCtl.Finish()
+		mockOAPI := mocks_osc.NewMockClient(mockCtl)
+		mockOAPI.EXPECT().CreateTags(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(roundTrip(t, srv.URL))
+		c := controller.NewRateLimitedClient(mockOAPI, opts)
+		_, err := c.CreateTags(t.Context(), osc.CreateTagsRequest{})
+		require.NoError(t, err)
+	})
+	t.Run("Throttled calls return the Retry-After delay", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("Retry-After", "42")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}))
+		defer srv.Close()
+		mockCtl := gomock.NewController(t)
+		defer mockCtl.Finish()
+		mockOAPI := mocks_osc.NewMockClient(mockCtl)
+		mockOAPI.EXPECT().CreateTags(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(roundTrip(t, srv.URL))
+		c := controller.NewRateLimitedClient(mockOAPI, opts)
+		_, err := c.CreateTags(t.Context(), osc.CreateTagsRequest{})
+		var terr *controller.ThrottledError
+		require.True(t, errors.As(err, &terr))
+		assert.Equal(t, 42*time.Second, terr.RetryAfter)
+	})
+	t.Run("Server errors without Retry-After use the default delay", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer srv.Close()
+		mockCtl := gomock.NewController(t)
+		defer mockCtl.Finish()
+		mockOAPI := mocks_osc.NewMockClient(mockCtl)
+		mockOAPI.EXPECT().CreateTags(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(roundTrip(t, srv.URL))
+		c := controller.NewRateLimitedClient(mockOAPI, opts)
+		_, err := c.CreateTags(t.Context(), osc.CreateTagsRequest{})
+		var terr *controller.ThrottledError
+		require.True(t, errors.As(err, &terr))
+		assert.Equal(t, time.Minute, terr.RetryAfter)
+	})
+	t.Run("Client errors are not throttling errors", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusBadRequest)
+		}))
+		defer srv.Close()
+		mockCtl := gomock.NewController(t)
+		defer mockCtl.Finish()
+		mockOAPI := mocks_osc.NewMockClient(mockCtl)
+		mockOAPI.EXPECT().CreateTags(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(roundTrip(t, srv.URL))
+		c := controller.NewRateLimitedClient(mockOAPI, opts)
+		_, err := c.CreateTags(t.Context(), osc.CreateTagsRequest{})
+		require.Error(t, err)
+		var terr *controller.ThrottledError
+		assert.False(t, errors.As(err, &terr))
+	})
+}
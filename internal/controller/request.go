@@ -0,0 +1,195 @@
+/*
+SPDX-FileCopyrightText: 2025 Outscale SAS <opensource@outscale.com>
+
+SPDX-License-Identifier: BSD-3-Clause
+*/
+package controller
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+
+	volumesnapshotv1 "github.com/kubernetes-csi/external-snapshotter/client/v8/apis/volumesnapshot/v1"
+	"github.com/outscale/goutils/sdk/ptr"
+	"github.com/outscale/osc-sdk-go/v3/pkg/osc"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// +kubebuilder:rbac:groups=snapshot.storage.k8s.io,resources=volumesnapshots,verbs=patch
+
+const (
+	// AnnotationExportRequest requests a one-off export of a VolumeSnapshot to a bucket, even if its class does not export snapshots.
+	// AnnotationExportRequestStatus reports the status of the requested export on the VolumeSnapshot, in JSON.
+	AnnotationExportRequest       = "bsu.csi.outscale.com/export-request"
+	AnnotationExportRequestStatus = "bsu.csi.outscale.com/export-request-status"
+	// AnnotationExportRequested stores the bucket of an accepted request on the content.
+	AnnotationExportRequested = "bsu.csi.outscale.com/export-requested"
+
+	// RequestStateRejected is reported when the requested bucket is not allowed, RequestStatePending until the export starts.
+	// Other states are the export states of the content.
+	RequestStateRejected = "rejected"
+	RequestStatePending  = "pending"
+)
+
+// ExportRequestStatus is the status of an export request, reported on the VolumeSnapshot.
+type ExportRequestStatus struct {
+	State  string `json:"state"`
+	Bucket string `json:"bucket"`
+	// Task is the ID of the export task, or of the data mover export, once started.
+	Task string `json:"task,omitempty"`
+	// Path is the path of the exported file, once the export is completed.
+	Path  string `json:"path,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// exportRequest returns the bucket of the export requested on the VolumeSnapshot of a content, once accepted.
+// Requests of buckets not allowed, or other than the bucket of a started export, are rejected.
+func (r *VolumeSnaphotContentReconciler) exportRequest(
+	ctx context.Context, snap *volumesnapshotv1.VolumeSnapshotContent, params map[string]string, frozen bool,
+) (string, error) {
+	if bucket, found := snap.Annotations[AnnotationExportRequested]; found {
+		return bucket, nil
+	}
+	vs, err := r.boundSnapshot(ctx, snap)
+	if vs == nil || err != nil {
+		return "", err
+	}
+	bucket, found := vs.Annotations[AnnotationExportRequest]
+	if !found {
+		return "", nil
+	}
+	var reason string
+	switch {
+	case !slices.Contains(r.requestBuckets, bucket):
+		reason = fmt.Sprintf("bucket %q is not allowed", bucket)
+	case frozen && params[ParamExportBucket] != bucket:
+		reason = fmt.Sprintf("snapshot is already exported to bucket %q", params[ParamExportBucket])
+	default:
+		return bucket, nil
+	}
+	klog.FromContext(ctx).V(2).Info("Export request rejected", "bucket", bucket, "reason", reason)
+	return "", r.setRequestStatus(ctx, vs, ExportRequestStatus{
+		State:  RequestStateRejected,
+		Bucket: bucket,
+		Error:  reason,
+	})
+}
+
+// boundSnapshot fetches the VolumeSnapshot bound to a content, nil being returned if there is none.
+func (r *VolumeSnaphotContentReconciler) boundSnapshot(
+	ctx context.Context, snap *volumesnapshotv1.VolumeSnapshotContent,
+) (*volumesnapshotv1.VolumeSnapshot, error) {
+	ref := snap.Spec.VolumeSnapshotRef
+	var vs volumesnapshotv1.VolumeSnapshot
+	if err := r.k8s.Get(ctx, types.NamespacedName{Namespace: ref.Namespace, Name: ref.Name}, &vs); err != nil {
+		return nil, client.IgnoreNotFound(fmt.Errorf("unable to fetch snapshot: %w", err))
+	}
+	if vs.Status == nil || ptr.From(vs.Status.BoundVolumeSnapshotContentName) != snap.Name {
+		return nil, nil
+	}
+	return &vs, nil
+}
+
+// withExportRequest enables the export to the requested bucket, unless the parameters already export the snapshot.
+// Requested exports are not filtered by selectors and sampling.
+func withExportRequest(params map[string]string, bucket string) map[string]string {
+	if bucket == "" || params[ParamExportEnabled] == "true" {
+		return params
+	}
+	requested := map[string]string{}
+	maps.Copy(requested, params)
+	for _, param := range []string{ParamExportSelector, ParamExportNamespaceSelector, ParamExportEvery, ParamExportMinInterval} {
+		delete(requested, param)
+	}
+	requested[ParamExportEnabled] = "true"
+	requested[ParamExportBucket] = bucket
+	return requested
+}
+
+// reportRequest reports the status of the export on the VolumeSnapshot having requested it.
+func (r *VolumeSnaphotContentReconciler) reportRequest(ctx context.Context, scope *Scope) error {
+	vs, err := r.boundSnapshot(ctx, scope.snap)
+	if vs == nil || err != nil {
+		return err
+	}
+	status := ExportRequestStatus{
+		State:  cmp.Or(scope.ExportState(), RequestStatePending),
+		Bucket: scope.ExportBucket(),
+		Task:   scope.ExportTaskID(),
+		Error:  cmp.Or(scope.snap.Annotations[AnnotationExportError], scope.snap.Annotations[AnnotationExportSkipReason]),
+	}
+	if status.State == string(osc.SnapshotExportTaskStateCompleted) {
+		status.Path = scope.snap.Annotations[AnnotationExportPath]
+	}
+	return r.setRequestStatus(ctx, vs, status)
+}
+
+func (r *VolumeSnaphotContentReconciler) setRequestStatus(ctx context.Context, vs *volumesnapshotv1.VolumeSnapshot, status ExportRequestStatus) error {
+	buf, _ := json.Marshal(status)
+	if vs.Annotations[AnnotationExportRequestStatus] == string(buf) {
+		return nil
+	}
+	patch := client.MergeFrom(vs.DeepCopy())
+	if vs.Annotations == nil {
+		vs.Annotations = map[string]string{}
+	}
+	vs.Annotations[AnnotationExportRequestStatus] = string(buf)
+	if err := r.k8s.Patch(ctx, vs, patch); err != nil {
+		return fmt.Errorf("unable to report export request: %w", err)
+	}
+	klog.FromContext(ctx).V(3).Info("Export request status reported", "state", status.State)
+	return nil
+}
+
+// ExportRequestPredicate filters the VolumeSnapshots requesting an export, when the request or the bound content changes.
+func ExportRequestPredicate() predicate.Predicate {
+	requested := func(obj client.Object) bool {
+		_, found := obj.GetAnnotations()[AnnotationExportRequest]
+		return found
+	}
+	return predicate.Funcs{
+		CreateFunc: func(e event.CreateEvent) bool { return requested(e.Object) },
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			if !requested(e.ObjectNew) {
+				return false
+			}
+			old, ok := e.ObjectOld.(*volumesnapshotv1.VolumeSnapshot)
+			if !ok {
+				return true
+			}
+			updated, ok := e.ObjectNew.(*volumesnapshotv1.VolumeSnapshot)
+			if !ok {
+				return true
+			}
+			return old.Annotations[AnnotationExportRequest] != updated.Annotations[AnnotationExportRequest] ||
+				boundContent(old) != boundContent(updated)
+		},
+		DeleteFunc:  func(event.DeleteEvent) bool { return false },
+		GenericFunc: func(event.GenericEvent) bool { return false },
+	}
+}
+
+// ContentOfSnapshot maps a VolumeSnapshot to the request of its bound content.
+func ContentOfSnapshot(_ context.Context, obj client.Object) []reconcile.Request {
+	vs, ok := obj.(*volumesnapshotv1.VolumeSnapshot)
+	if !ok || boundContent(vs) == "" {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: boundContent(vs)}}}
+}
+
+func boundContent(vs *volumesnapshotv1.VolumeSnapshot) string {
+	if vs.Status == nil {
+		return ""
+	}
+	return ptr.From(vs.Status.BoundVolumeSnapshotContentName)
+}
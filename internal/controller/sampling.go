@@ -0,0 +1,90 @@
+/*
+SPDX-FileCopyrightText: 2025 Outscale SAS <opensource@outscale.com>
+
+SPDX-License-Identifier: BSD-3-Clause
+*/
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	volumesnapshotv1 "github.com/kubernetes-csi/external-snapshotter/client/v8/apis/volumesnapshot/v1"
+	"github.com/outscale/osc-sdk-go/v3/pkg/osc"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// IndexVolumeHandle indexes contents by source volume.
+const IndexVolumeHandle = "spec.source.volumeHandle"
+
+func IndexByVolumeHandle(obj client.Object) []string {
+	snap, ok := obj.(*volumesnapshotv1.VolumeSnapshotContent)
+	if !ok || snap.Spec.Source.VolumeHandle == nil {
+		return nil
+	}
+	return []string{*snap.Spec.Source.VolumeHandle}
+}
+
+// skipReason checks if the export of a content can be skipped, based on the exports of the previous snapshots of the same volume
+// which did not fail, whatever their exporter.
+func (r *VolumeSnaphotContentReconciler) skipReason(ctx context.Context, scope *Scope, every int, minInterval time.Duration) (string, error) {
+	if every <= 1 && minInterval == 0 {
+		return "", nil
+	}
+	volumeID, found := scope.VolumeID()
+	if !found {
+		return "", nil
+	}
+	var list volumesnapshotv1.VolumeSnapshotContentList
+	if err := r.k8s.List(ctx, &list, client.MatchingFields{IndexVolumeHandle: volumeID}); err != nil {
+		return "", fmt.Errorf("unable to list snapshots: %w", err)
+	}
+
+	created := scope.CreationTime()
+	var last *volumesnapshotv1.VolumeSnapshotContent
+	for i := range list.Items {
+		snap := &list.Items[i]
+		if string(snap.UID) == scope.UID() || !isSampleReference(snap.Annotations[AnnotationExportState]) {
+			continue
+		}
+		if t := creationTime(snap); t.Before(created) && (last == nil || t.After(creationTime(last))) {
+			last = snap
+		}
+	}
+	if last == nil {
+		return "", nil
+	}
+	if minInterval > 0 && created.Sub(creationTime(last)) < minInterval {
+		return fmt.Sprintf("last export of volume is less than %s old", minInterval), nil
+	}
+	if every > 1 {
+		var since int
+		for i := range list.Items {
+			if t := creationTime(&list.Items[i]); t.After(creationTime(last)) && !t.After(created) {
+				since++
+			}
+		}
+		if since < every {
+			return fmt.Sprintf("only %d snapshots of volume since last export, exporting every %d", since, every), nil
+		}
+	}
+	return "", nil
+}
+
+func creationTime(snap *volumesnapshotv1.VolumeSnapshotContent) time.Time {
+	if snap.Status != nil && snap.Status.CreationTime != nil {
+		return time.Unix(0, *snap.Status.CreationTime)
+	}
+	return snap.CreationTimestamp.Time
+}
+
+// isSampleReference checks if an export is the reference of sampling: started, and neither failed nor cancelled.
+func isSampleReference(state string) bool {
+	switch state {
+	case "", ExportStateSkipped, string(osc.SnapshotExportTaskStateFailed), string(osc.SnapshotExportTaskStateCancelled):
+		return false
+	default:
+		return true
+	}
+}
@@ -6,16 +6,30 @@ SPDX-License-Identifier: BSD-3-Clause
 package controller
 
 import (
+	"cmp"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"maps"
 	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
 	volumesnapshotv1 "github.com/kubernetes-csi/external-snapshotter/client/v8/apis/volumesnapshot/v1"
+	"github.com/outscale/csi-snapshot-exporter/internal/encryption"
+	"github.com/outscale/csi-snapshot-exporter/internal/objectstore"
+	"github.com/outscale/goutils/sdk/ptr"
 	"github.com/outscale/osc-sdk-go/v3/pkg/osc"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 )
 
 const (
@@ -23,72 +37,721 @@ const (
 	ParamExportFormat  = "exportImageFormat"
 	ParamExportBucket  = "exportBucket"
 	ParamExportPrefix  = "exportPrefix"
+	// ParamExportCompression is the compression of exported files, only configurable for the data mover.
+	ParamExportCompression = "exportCompression"
+	// Client-side encryption of exported files, by a worker Job, using the key stored in a Secret.
+	ParamExportEncryption          = "exportEncryption"
+	ParamExportEncryptionKeySecret = "exportEncryptionKeySecret"
+	// Object lock applied to exported files.
+	ParamExportLockMode      = "exportLockMode"
+	ParamExportLockRetention = "exportLockRetention"
+	ParamExportLegalHold     = "exportLegalHold"
+	// Object tags and user metadata of exported files, as comma separated key=template pairs.
+	ParamExportTags     = "exportTags"
+	ParamExportMetadata = "exportMetadata"
+	// Incremental exports: diffs against the previous export of the volume, with a full export every exportFullEvery exports.
+	ParamExportIncremental = "exportIncremental"
+	ParamExportFullEvery   = "exportFullEvery"
+	// ParamExportThenDeleteSnapshot deletes BSU snapshots after their export, with an `afterDays=N` delay.
+	ParamExportThenDeleteSnapshot = "exportThenDeleteSnapshot"
+	// ParamExportBeforeDelete protects contents from deletion until their export is completed.
+	ParamExportBeforeDelete = "exportBeforeDelete"
+	// ParamExportCopyTargets is a JSON list of secondary S3 compatible targets, exported files are copied to.
+	ParamExportCopyTargets = "exportCopyTargets"
+	// ParamExportStorageClass is the storage class used by the data mover to restore snapshots of other drivers than BSU.
+	ParamExportStorageClass = "exportStorageClass"
+	// Label selectors, evaluated against the source VolumeSnapshot/PVC and its namespace.
+	ParamExportSelector          = "exportSelector"
+	ParamExportNamespaceSelector = "exportNamespaceSelector"
+	// Sampling, evaluated per source volume.
+	ParamExportEvery       = "exportEvery"
+	ParamExportMinInterval = "exportMinInterval"
 
 	//
-	AnnotationExportPath  = "bsu.csi.outscale.com/export-path"
-	AnnotationExportState = "bsu.csi.outscale.com/export-state"
-	AnnotationExportTask  = "bsu.csi.outscale.com/export-task"
+	AnnotationExportPath = "bsu.csi.outscale.com/export-path"
+	// AnnotationExportManifest describes the exported file, in JSON.
+	AnnotationExportManifest = "bsu.csi.outscale.com/export-manifest"
+	AnnotationExportState    = "bsu.csi.outscale.com/export-state"
+	AnnotationExportTask     = "bsu.csi.outscale.com/export-task"
+	// AnnotationExportTaskUntagged is set until the export task is tagged, tags being required to adopt it.
+	AnnotationExportTaskUntagged = "bsu.csi.outscale.com/export-task-untagged"
+	// Export parameters of pre-provisioned contents, having no class.
+	AnnotationExportBucket      = "bsu.csi.outscale.com/export-bucket"
+	AnnotationExportFormat      = "bsu.csi.outscale.com/export-format"
+	AnnotationExportPrefix      = "bsu.csi.outscale.com/export-prefix"
+	AnnotationExportCompression = "bsu.csi.outscale.com/export-compression"
+	// AnnotationExportError is set when an export has permanently failed,
+	// AnnotationExportErrorConfig stores a hash of the configuration that failed.
+	AnnotationExportError       = "bsu.csi.outscale.com/export-error"
+	AnnotationExportErrorConfig = "bsu.csi.outscale.com/export-error-config"
+	AnnotationExportSkipReason  = "bsu.csi.outscale.com/export-skip-reason"
+	// Object lock applied to the exported file, and the last error while applying it.
+	AnnotationExportLockedUntil = "bsu.csi.outscale.com/export-locked-until"
+	AnnotationExportLegalHold   = "bsu.csi.outscale.com/export-legal-hold"
+	AnnotationExportLockError   = "bsu.csi.outscale.com/export-lock-error"
+	// AnnotationExportTagged is set once tags and metadata are applied to the exported file.
+	AnnotationExportTagged = "bsu.csi.outscale.com/export-tagged"
+	// AnnotationExportCopies stores the status of the copy to each target, in JSON.
+	AnnotationExportCopies = "bsu.csi.outscale.com/export-copies"
+	// AnnotationExportCompletedAt stores the time the export was completed.
+	AnnotationExportCompletedAt = "bsu.csi.outscale.com/export-completed-at"
+	// Archiving: the time the BSU snapshot was deleted, and the last error while deleting it.
+	AnnotationExportSnapshotDeleted = "bsu.csi.outscale.com/export-snapshot-deleted"
+	AnnotationExportArchiveError    = "bsu.csi.outscale.com/export-archive-error"
+	// AnnotationExportRelease releases the protection of a content, even if its export is not completed.
+	AnnotationExportRelease = "bsu.csi.outscale.com/export-release"
+	// AnnotationExportDeletionPolicy stores the deletion policy of a protected content.
+	AnnotationExportDeletionPolicy = "bsu.csi.outscale.com/export-deletion-policy"
+	// AnnotationExportSnapshotProgress stores the progress of the BSU snapshot, until it is completed.
+	AnnotationExportSnapshotProgress = "bsu.csi.outscale.com/export-snapshot-progress"
+	// AnnotationExportResolvedPrefix stores the prefix resolved at the first export attempt, reused by retries.
+	AnnotationExportResolvedPrefix = "bsu.csi.outscale.com/export-resolved-prefix"
+	// AnnotationExportConfig stores the export parameters frozen when the export starts, in JSON.
+	// AnnotationExportReresolve requests the parameters to be resolved again from the class.
+	AnnotationExportConfig    = "bsu.csi.outscale.com/export-config"
+	AnnotationExportReresolve = "bsu.csi.outscale.com/export-reresolve"
+	// AnnotationExportJobFailures counts the failures of the current worker Job.
+	AnnotationExportJobFailures = "bsu.csi.outscale.com/export-job-failures"
+
+	// ExportStateSkipped is set when the export is skipped by selectors or sampling.
+	ExportStateSkipped = "skipped"
+	// ExportStateWaitingSnapshot is set while the BSU snapshot is not completed.
+	ExportStateWaitingSnapshot = "waiting-snapshot"
+	// ExportStateMoving is set while the snapshot is restored and streamed to the bucket by the data mover.
+	ExportStateMoving = "moving"
+	// ExportStateDiffing is set while the exported image is replaced by a diff by a worker Job.
+	ExportStateDiffing = "diffing"
+	// ExportStateEncrypting is set while the exported file is encrypted by a worker Job.
+	ExportStateEncrypting = "encrypting"
+	// ExportStateFinalizing is set while tags, metadata and object lock are applied to the exported file,
+	// and while it is copied to secondary targets.
+	ExportStateFinalizing = "finalizing"
+
+	//
+	TagContentUID = "bsu.csi.outscale.com/export-content-uid"
+	TagClusterID  = "bsu.csi.outscale.com/export-cluster-id"
 )
 
 type Scope struct {
 	client     client.Client
 	snapBefore runtime.Object
 
-	snap      *volumesnapshotv1.VolumeSnapshotContent
-	snapClass *volumesnapshotv1.VolumeSnapshotClass
+	snap   *volumesnapshotv1.VolumeSnapshotContent
+	params map[string]string
 }
 
 // NewScope create new clusterScope from parameters which is called at each reconciliation iteration
-func NewScope(c client.Client, snap *volumesnapshotv1.VolumeSnapshotContent, snapClass *volumesnapshotv1.VolumeSnapshotClass) *Scope {
+func NewScope(c client.Client, snap *volumesnapshotv1.VolumeSnapshotContent, params map[string]string) *Scope {
 	return &Scope{
 		client:     c,
 		snapBefore: snap.DeepCopyObject(),
 		snap:       snap,
-		snapClass:  snapClass,
+		params:     params,
+	}
+}
+
+// AnnotationParameters returns the export parameters set by annotations on a pre-provisioned content.
+// Export is enabled if a bucket is set.
+func AnnotationParameters(snap *volumesnapshotv1.VolumeSnapshotContent) map[string]string {
+	params := map[string]string{}
+	for param, annotation := range map[string]string{
+		ParamExportBucket:      AnnotationExportBucket,
+		ParamExportFormat:      AnnotationExportFormat,
+		ParamExportPrefix:      AnnotationExportPrefix,
+		ParamExportCompression: AnnotationExportCompression,
+	} {
+		if v, found := snap.Annotations[annotation]; found {
+			params[param] = v
+		}
+	}
+	if params[ParamExportBucket] != "" {
+		params[ParamExportEnabled] = "true"
+	}
+	return params
+}
+
+// FrozenParameters returns the export parameters frozen on a content, if any.
+// Frozen parameters are ignored if re-resolution is requested.
+func FrozenParameters(snap *volumesnapshotv1.VolumeSnapshotContent) (map[string]string, bool, error) {
+	raw, found := snap.Annotations[AnnotationExportConfig]
+	if !found || ReresolveRequested(snap) {
+		return nil, false, nil
+	}
+	var params map[string]string
+	if err := json.Unmarshal([]byte(raw), &params); err != nil {
+		return nil, false, fmt.Errorf("invalid frozen configuration: %w", err)
+	}
+	return params, true, nil
+}
+
+// ReresolveRequested checks if the export parameters need to be resolved again from the class.
+func ReresolveRequested(snap *volumesnapshotv1.VolumeSnapshotContent) bool {
+	_, found := snap.Annotations[AnnotationExportReresolve]
+	return found
+}
+
+// FreezeConfig stores the export parameters on the content, to drive all subsequent reconciliations.
+func (s *Scope) FreezeConfig() {
+	if _, frozen, _ := FrozenParameters(s.snap); frozen {
+		return
 	}
+	params := map[string]string{}
+	for k, v := range s.params {
+		if strings.HasPrefix(k, "export") {
+			params[k] = v
+		}
+	}
+	data, _ := json.Marshal(params)
+	if s.snap.Annotations == nil {
+		s.snap.Annotations = map[string]string{}
+	}
+	s.snap.Annotations[AnnotationExportConfig] = string(data)
+}
+
+// Reresolve drops the frozen configuration and the resolved prefix, parameters being resolved again from the class.
+func (s *Scope) Reresolve() {
+	delete(s.snap.Annotations, AnnotationExportConfig)
+	delete(s.snap.Annotations, AnnotationExportResolvedPrefix)
+	delete(s.snap.Annotations, AnnotationExportReresolve)
+}
+
+// ExportRequest returns the bucket of the accepted export request of the VolumeSnapshot, if any.
+func (s *Scope) ExportRequest() string {
+	return s.snap.Annotations[AnnotationExportRequested]
+}
+
+// SetExportRequest records an accepted export request, reported on the VolumeSnapshot until the export is completed.
+func (s *Scope) SetExportRequest(bucket string) {
+	if s.snap.Annotations == nil {
+		s.snap.Annotations = map[string]string{}
+	}
+	s.snap.Annotations[AnnotationExportRequested] = bucket
+}
+
+// ExportGeneration returns the number of re-exports of the content.
+func (s *Scope) ExportGeneration() int {
+	generation, _ := strconv.Atoi(s.snap.Annotations[AnnotationExportGeneration])
+	return generation
+}
+
+func (s *Scope) Name() string {
+	return s.snap.Name
+}
+
+func (s *Scope) UID() string {
+	return string(s.snap.UID)
 }
 
 func (s *Scope) NeedsExport() bool {
-	return s.snapClass.Parameters[ParamExportEnabled] == "true" && s.snap.Annotations[AnnotationExportState] != string(osc.SnapshotExportTaskStateCompleted)
+	if s.params[ParamExportEnabled] != "true" {
+		return false
+	}
+	switch s.snap.Annotations[AnnotationExportState] {
+	case string(osc.SnapshotExportTaskStateCompleted), string(osc.SnapshotExportTaskStateCancelled), ExportStateSkipped:
+		return false
+	default:
+		return true
+	}
+}
+
+// ExportStarted checks if the export has started, once the checks of its configuration and of the snapshot have passed.
+func (s *Scope) ExportStarted() bool {
+	return s.ExportState() != ""
+}
+
+// ExportProtection checks if contents are protected from deletion until their export is completed.
+func (s *Scope) ExportProtection() bool {
+	protect, _ := strconv.ParseBool(s.params[ParamExportBeforeDelete])
+	return protect
+}
+
+func (s *Scope) IsDeleted() bool {
+	return !s.snap.DeletionTimestamp.IsZero()
+}
+
+func (s *Scope) HasFinalizer() bool {
+	return controllerutil.ContainsFinalizer(s.snap, FinalizerExportProtection)
+}
+
+// ReleaseRequested checks if the release of the protection is requested by an admin.
+func (s *Scope) ReleaseRequested() bool {
+	release, _ := strconv.ParseBool(s.snap.Annotations[AnnotationExportRelease])
+	return release
+}
+
+// AddFinalizer protects the content, and retains its snapshot if requested.
+func (s *Scope) AddFinalizer(retain bool) {
+	controllerutil.AddFinalizer(s.snap, FinalizerExportProtection)
+	if !retain || s.snap.Spec.DeletionPolicy != volumesnapshotv1.VolumeSnapshotContentDelete {
+		return
+	}
+	if s.snap.Annotations == nil {
+		s.snap.Annotations = map[string]string{}
+	}
+	s.snap.Annotations[AnnotationExportDeletionPolicy] = string(s.snap.Spec.DeletionPolicy)
+	s.snap.Spec.DeletionPolicy = volumesnapshotv1.VolumeSnapshotContentRetain
+}
+
+// RetainedDeletionPolicy returns the deletion policy of the content before its protection.
+func (s *Scope) RetainedDeletionPolicy() volumesnapshotv1.DeletionPolicy {
+	return volumesnapshotv1.DeletionPolicy(s.snap.Annotations[AnnotationExportDeletionPolicy])
+}
+
+// RemoveFinalizer releases the protection of the content, restoring its deletion policy.
+func (s *Scope) RemoveFinalizer() {
+	if policy := s.RetainedDeletionPolicy(); policy != "" && !s.IsDeleted() {
+		s.snap.Spec.DeletionPolicy = policy
+	}
+	delete(s.snap.Annotations, AnnotationExportDeletionPolicy)
+	controllerutil.RemoveFinalizer(s.snap, FinalizerExportProtection)
+}
+
+// VolumeID returns the ID of the source volume.
+func (s *Scope) VolumeID() (string, bool) {
+	if s.snap.Spec.Source.VolumeHandle == nil {
+		return "", false
+	}
+	return *s.snap.Spec.Source.VolumeHandle, true
+}
+
+// CreationTime returns the creation time of the snapshot.
+func (s *Scope) CreationTime() time.Time {
+	return creationTime(s.snap)
 }
 
 func (s *Scope) GetSnapshotID() (string, bool) {
-	if s.snap.Status == nil || s.snap.Status.SnapshotHandle == nil {
+	switch {
+	case s.snap.Status != nil && s.snap.Status.SnapshotHandle != nil:
+		return *s.snap.Status.SnapshotHandle, true
+	case s.snap.Spec.Source.SnapshotHandle != nil:
+		// pre-provisioned snapshot
+		return *s.snap.Spec.Source.SnapshotHandle, true
+	default:
 		return "", false
 	}
-	return *s.snap.Status.SnapshotHandle, true
 }
 
+// RestoreSize returns the size of the snapshot in bytes, or 0 if unknown.
+func (s *Scope) RestoreSize() int64 {
+	if s.snap.Status == nil {
+		return 0
+	}
+	return ptr.From(s.snap.Status.RestoreSize)
+}
+
+// Driver returns the CSI driver of the snapshot.
+func (s *Scope) Driver() string {
+	return s.snap.Spec.Driver
+}
+
+func (s *Scope) ExportStorageClass() string {
+	return s.params[ParamExportStorageClass]
+}
+
+// ExportTaskID returns the ID of the export task, or of the data mover export.
 func (s *Scope) ExportTaskID() string {
 	return s.snap.Annotations[AnnotationExportTask]
 }
 
+func (s *Scope) SetExportTaskID(id string) {
+	if s.snap.Annotations == nil {
+		s.snap.Annotations = map[string]string{}
+	}
+	s.snap.Annotations[AnnotationExportTask] = id
+}
+
+// TaskTagPending checks if the export task is not tagged yet.
+func (s *Scope) TaskTagPending() bool {
+	_, found := s.snap.Annotations[AnnotationExportTaskUntagged]
+	return found
+}
+
+func (s *Scope) SetTaskTagPending(pending bool) {
+	if !pending {
+		delete(s.snap.Annotations, AnnotationExportTaskUntagged)
+		return
+	}
+	if s.snap.Annotations == nil {
+		s.snap.Annotations = map[string]string{}
+	}
+	s.snap.Annotations[AnnotationExportTaskUntagged] = "true"
+}
+
 func (s *Scope) ExportBucket() string {
-	return s.snapClass.Parameters[ParamExportBucket]
+	return s.params[ParamExportBucket]
 }
 
-func (s *Scope) ExportPrefix() string {
-	prefix := s.snapClass.Parameters[ParamExportPrefix]
+// ExportPrefix returns the prefix of the export.
+// The prefix is resolved at the first attempt, and frozen for retries.
+func (s *Scope) ExportPrefix(data *TemplateData) (string, error) {
+	if prefix, found := s.snap.Annotations[AnnotationExportResolvedPrefix]; found {
+		return prefix, nil
+	}
+	prefix := s.params[ParamExportPrefix]
 	if strings.Contains(prefix, "{") {
-		prefix = strings.NewReplacer(
-			"{date}", time.Now().Format(time.DateOnly),
-			"{vs}", s.snap.Spec.VolumeSnapshotRef.Name,
-			"{ns}", s.snap.Spec.VolumeSnapshotRef.Namespace,
-		).Replace(prefix)
+		var err error
+		prefix, err = ExecuteTemplate(prefix, data)
+		if err != nil {
+			return "", fmt.Errorf("invalid %s: %w", ParamExportPrefix, err)
+		}
+	}
+	if s.snap.Annotations == nil {
+		s.snap.Annotations = map[string]string{}
+	}
+	s.snap.Annotations[AnnotationExportResolvedPrefix] = prefix
+	return prefix, nil
+}
+
+// Validate checks the export parameters.
+func (s *Scope) Validate() error {
+	if _, err := s.ExportFormat(); err != nil {
+		return err
+	}
+	if _, err := s.ExportCompression(); err != nil {
+		return err
+	}
+	if s.ExportBucket() == "" {
+		return fmt.Errorf("%s is required", ParamExportBucket)
+	}
+	if _, _, err := s.ExportEncryption(); err != nil {
+		return err
+	}
+	if _, err := s.ExportLock(); err != nil {
+		return err
+	}
+	if _, err := s.ExportIncremental(); err != nil {
+		return err
+	}
+	if _, _, err := s.ExportTags(&TemplateData{}); err != nil {
+		return err
+	}
+	if _, _, err := s.ExportArchive(); err != nil {
+		return err
+	}
+	if v := s.params[ParamExportBeforeDelete]; v != "" {
+		if _, err := strconv.ParseBool(v); err != nil {
+			return fmt.Errorf("invalid %s %q", ParamExportBeforeDelete, v)
+		}
+	}
+	if _, err := s.ExportCopyTargets(); err != nil {
+		return err
+	}
+	if _, _, err := s.ExportSelectors(); err != nil {
+		return err
+	}
+	if _, _, err := s.ExportSampling(); err != nil {
+		return err
+	}
+	if err := ValidateTemplate(s.params[ParamExportPrefix]); err != nil {
+		return fmt.Errorf("invalid %s: %w", ParamExportPrefix, err)
+	}
+	return nil
+}
+
+func (s *Scope) VolumeSnapshotRef() types.NamespacedName {
+	return types.NamespacedName{
+		Namespace: s.snap.Spec.VolumeSnapshotRef.Namespace,
+		Name:      s.snap.Spec.VolumeSnapshotRef.Name,
+	}
+}
+
+func (s *Scope) ExportSelectors() (labels.Selector, labels.Selector, error) {
+	selector, err := labels.Parse(s.params[ParamExportSelector])
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid %s: %w", ParamExportSelector, err)
+	}
+	nsSelector, err := labels.Parse(s.params[ParamExportNamespaceSelector])
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid %s: %w", ParamExportNamespaceSelector, err)
+	}
+	return selector, nsSelector, nil
+}
+
+// ExportSampling returns the sampling parameters: export every Nth snapshot, or at most once per interval.
+func (s *Scope) ExportSampling() (every int, minInterval time.Duration, err error) {
+	if v := s.params[ParamExportEvery]; v != "" {
+		every, err = strconv.Atoi(v)
+		if err != nil || every < 1 {
+			return 0, 0, fmt.Errorf("invalid %s %q", ParamExportEvery, v)
+		}
+	}
+	if v := s.params[ParamExportMinInterval]; v != "" {
+		minInterval, err = time.ParseDuration(v)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid %s: %w", ParamExportMinInterval, err)
+		}
 	}
-	return prefix
+	return every, minInterval, nil
 }
 
 func (s *Scope) ExportFormat() (string, error) {
-	f := s.snapClass.Parameters[ParamExportFormat]
-	switch f {
-	case "":
-		return "qcow2", nil
-	case "qcow2", "raw":
+	f := s.params[ParamExportFormat]
+	switch {
+	case f == "":
+		return DefaultExportFormat, nil
+	case slices.Contains(exportFormats, f):
 		return f, nil
 	default:
-		return "", fmt.Errorf("invalid format %q - allowed values qcow2,raw", f)
+		return "", fmt.Errorf("invalid format %q - allowed values %s", f, strings.Join(exportFormats, ","))
+	}
+}
+
+// ExportCompression returns the compression of exported files. BSU snapshots are always compressed with gzip by OAPI,
+// and incremental exports read gzipped files.
+func (s *Scope) ExportCompression() (string, error) {
+	c := cmp.Or(s.params[ParamExportCompression], DefaultExportCompression)
+	compression, found := exportCompressions[c]
+	switch {
+	case !found:
+		return "", fmt.Errorf("invalid compression %q - allowed values %s", c, allowedValues(exportCompressions))
+	case c == DefaultExportCompression:
+		return c, nil
+	case !compression.OAPI && s.Driver() == DriverBSU:
+		return "", fmt.Errorf("compression %q is not supported by BSU snapshot exports", c)
+	}
+	if fullEvery, _ := s.ExportIncremental(); fullEvery > 0 {
+		return "", fmt.Errorf("compression %q is not supported by incremental exports", c)
+	}
+	return c, nil
+}
+
+// ExportEncryption returns the encryption algorithm and the name of the Secret storing the key.
+// An empty algorithm disables encryption.
+func (s *Scope) ExportEncryption() (algorithm, secret string, err error) {
+	algorithm, secret = s.params[ParamExportEncryption], s.params[ParamExportEncryptionKeySecret]
+	switch {
+	case algorithm == "":
+		return "", "", nil
+	case algorithm != encryption.Algorithm:
+		return "", "", fmt.Errorf("invalid encryption %q - allowed values %s", algorithm, encryption.Algorithm)
+	case secret == "":
+		return "", "", fmt.Errorf("%s is required", ParamExportEncryptionKeySecret)
+	default:
+		return algorithm, secret, nil
+	}
+}
+
+// ExportIncremental returns the length of incremental chains, 0 if incremental exports are disabled.
+func (s *Scope) ExportIncremental() (int, error) {
+	v := s.params[ParamExportIncremental]
+	if v == "" {
+		return 0, nil
+	}
+	incremental, err := strconv.ParseBool(v)
+	switch {
+	case err != nil:
+		return 0, fmt.Errorf("invalid %s %q", ParamExportIncremental, v)
+	case !incremental:
+		return 0, nil
+	}
+	if f, _ := s.ExportFormat(); f != "raw" {
+		return 0, fmt.Errorf("%s requires the raw format", ParamExportIncremental)
+	}
+	if algorithm, _, _ := s.ExportEncryption(); algorithm != "" {
+		return 0, fmt.Errorf("%s cannot be used with %s", ParamExportIncremental, ParamExportEncryption)
+	}
+	fullEvery := DefaultExportFullEvery
+	if v := s.params[ParamExportFullEvery]; v != "" {
+		fullEvery, err = strconv.Atoi(v)
+		if err != nil || fullEvery < 1 {
+			return 0, fmt.Errorf("invalid %s %q", ParamExportFullEvery, v)
+		}
+	}
+	return fullEvery, nil
+}
+
+// LockConfig is the object lock applied to exported files.
+type LockConfig struct {
+	// Mode is the retention mode (GOVERNANCE or COMPLIANCE), and Retention the retention duration.
+	Mode      string
+	Retention time.Duration
+	LegalHold bool
+}
+
+func (c LockConfig) Enabled() bool {
+	return c.Mode != "" || c.LegalHold
+}
+
+func (s *Scope) ExportLock() (LockConfig, error) {
+	var cfg LockConfig
+	switch mode := strings.ToUpper(s.params[ParamExportLockMode]); mode {
+	case "":
+	case objectstore.LockModeGovernance, objectstore.LockModeCompliance:
+		cfg.Mode = mode
+		v := s.params[ParamExportLockRetention]
+		retention, err := time.ParseDuration(v)
+		if err != nil || retention <= 0 {
+			return cfg, fmt.Errorf("invalid %s %q", ParamExportLockRetention, v)
+		}
+		cfg.Retention = retention
+	default:
+		return cfg, fmt.Errorf("invalid %s %q - allowed values governance,compliance", ParamExportLockMode, s.params[ParamExportLockMode])
+	}
+	if v := s.params[ParamExportLegalHold]; v != "" {
+		hold, err := strconv.ParseBool(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid %s %q", ParamExportLegalHold, v)
+		}
+		cfg.LegalHold = hold
+	}
+	return cfg, nil
+}
+
+// LockedUntil returns the end of the retention of the exported file, if set.
+func (s *Scope) LockedUntil() (string, bool) {
+	until, found := s.snap.Annotations[AnnotationExportLockedUntil]
+	return until, found
+}
+
+func (s *Scope) SetLockedUntil(until time.Time) {
+	s.snap.Annotations[AnnotationExportLockedUntil] = until.UTC().Format(time.RFC3339)
+}
+
+func (s *Scope) HasLegalHold() bool {
+	return s.snap.Annotations[AnnotationExportLegalHold] == "true"
+}
+
+func (s *Scope) SetLegalHold() {
+	s.snap.Annotations[AnnotationExportLegalHold] = "true"
+}
+
+// SetLockError records the last error while applying object lock.
+func (s *Scope) SetLockError(err error) {
+	if err == nil {
+		delete(s.snap.Annotations, AnnotationExportLockError)
+		return
+	}
+	s.snap.Annotations[AnnotationExportLockError] = err.Error()
+}
+
+// S3 limits on object tags.
+const (
+	maxTags           = 10
+	maxTagKeyLength   = 128
+	maxTagValueLength = 256
+)
+
+var metadataKeyRegexp = regexp.MustCompile(`^[A-Za-z0-9-]+$`)
+
+// ExportTags renders the tags and the user metadata of the exported file.
+func (s *Scope) ExportTags(data *TemplateData) (tags, metadata map[string]string, err error) {
+	tags, err = renderTemplatePairs(s.params[ParamExportTags], data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid %s: %w", ParamExportTags, err)
+	}
+	if len(tags) > maxTags {
+		return nil, nil, fmt.Errorf("invalid %s: at most %d tags are allowed", ParamExportTags, maxTags)
+	}
+	for k, v := range tags {
+		if len(k) > maxTagKeyLength || len(v) > maxTagValueLength {
+			return nil, nil, fmt.Errorf("invalid %s: tag %q is too long", ParamExportTags, k)
+		}
+	}
+	metadata, err = renderTemplatePairs(s.params[ParamExportMetadata], data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid %s: %w", ParamExportMetadata, err)
+	}
+	for k := range metadata {
+		if !metadataKeyRegexp.MatchString(k) {
+			return nil, nil, fmt.Errorf("invalid %s: invalid key %q", ParamExportMetadata, k)
+		}
+	}
+	return tags, metadata, nil
+}
+
+func (s *Scope) IsTagged() bool {
+	return s.snap.Annotations[AnnotationExportTagged] == "true"
+}
+
+func (s *Scope) SetTagged() {
+	s.snap.Annotations[AnnotationExportTagged] = "true"
+}
+
+// ExportArchive returns the delay after which BSU snapshots are deleted, once exported.
+func (s *Scope) ExportArchive() (after time.Duration, enabled bool, err error) {
+	v := s.params[ParamExportThenDeleteSnapshot]
+	if v == "" {
+		return 0, false, nil
+	}
+	days, found := strings.CutPrefix(v, "afterDays=")
+	n, err := strconv.Atoi(days)
+	if !found || err != nil || n < 0 {
+		return 0, false, fmt.Errorf("invalid %s %q - expected afterDays=N", ParamExportThenDeleteSnapshot, v)
+	}
+	return time.Duration(n) * 24 * time.Hour, true, nil
+}
+
+// NeedsArchive checks if the BSU snapshot of a completed export needs to be deleted.
+func (s *Scope) NeedsArchive() bool {
+	if s.params[ParamExportEnabled] != "true" || s.ExportState() != string(osc.SnapshotExportTaskStateCompleted) {
+		return false
+	}
+	if s.SnapshotDeleted() {
+		return false
 	}
+	_, enabled, _ := s.ExportArchive()
+	return enabled
+}
+
+func (s *Scope) CompletedAt() (time.Time, bool) {
+	t, err := time.Parse(time.RFC3339, s.snap.Annotations[AnnotationExportCompletedAt])
+	return t, err == nil
+}
+
+func (s *Scope) SetCompletedAt(t time.Time) {
+	s.snap.Annotations[AnnotationExportCompletedAt] = t.UTC().Format(time.RFC3339)
+}
+
+// SetSnapshotDeleted records the deletion of the BSU snapshot.
+func (s *Scope) SetSnapshotDeleted(t time.Time) {
+	s.snap.Annotations[AnnotationExportSnapshotDeleted] = t.UTC().Format(time.RFC3339)
+	delete(s.snap.Annotations, AnnotationExportArchiveError)
+}
+
+// SnapshotDeleted checks if the BSU snapshot was deleted by the archive mode.
+func (s *Scope) SnapshotDeleted() bool {
+	_, found := s.snap.Annotations[AnnotationExportSnapshotDeleted]
+	return found
+}
+
+func (s *Scope) SetArchiveError(err error) {
+	s.snap.Annotations[AnnotationExportArchiveError] = err.Error()
+}
+
+func (s *Scope) ExportCopyTargets() ([]CopyTarget, error) {
+	targets, err := parseCopyTargets(s.params[ParamExportCopyTargets])
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", ParamExportCopyTargets, err)
+	}
+	return targets, nil
+}
+
+// CopyStatuses returns the status of the copy to each target.
+func (s *Scope) CopyStatuses() map[string]CopyStatus {
+	statuses := map[string]CopyStatus{}
+	if v, found := s.snap.Annotations[AnnotationExportCopies]; found {
+		_ = json.Unmarshal([]byte(v), &statuses)
+	}
+	return statuses
+}
+
+func (s *Scope) SetCopyStatuses(statuses map[string]CopyStatus) {
+	if len(statuses) == 0 {
+		return
+	}
+	buf, _ := json.Marshal(statuses)
+	s.snap.Annotations[AnnotationExportCopies] = string(buf)
+}
+
+func (s *Scope) ExportState() string {
+	return s.snap.Annotations[AnnotationExportState]
+}
+
+func (s *Scope) SetExportState(state string) {
+	if s.snap.Annotations == nil {
+		s.snap.Annotations = map[string]string{}
+	}
+	s.snap.Annotations[AnnotationExportState] = state
 }
 
 func (s *Scope) UpdateExportState(id string, state osc.SnapshotExportTaskState) {
@@ -99,10 +762,97 @@ func (s *Scope) UpdateExportState(id string, state osc.SnapshotExportTaskState)
 	s.snap.Annotations[AnnotationExportState] = string(state)
 }
 
+// SetExportError marks the export as permanently failed with the current configuration.
+func (s *Scope) SetExportError(err error) {
+	if s.snap.Annotations == nil {
+		s.snap.Annotations = map[string]string{}
+	}
+	s.snap.Annotations[AnnotationExportState] = string(osc.SnapshotExportTaskStateFailed)
+	s.snap.Annotations[AnnotationExportError] = err.Error()
+	s.snap.Annotations[AnnotationExportErrorConfig] = s.configHash()
+	// the prefix is resolved again with the fixed configuration
+	delete(s.snap.Annotations, AnnotationExportResolvedPrefix)
+}
+
+// SetSnapshotProgress reports the progress of a BSU snapshot that is not completed yet.
+func (s *Scope) SetSnapshotProgress(progress int) {
+	if s.snap.Annotations == nil {
+		s.snap.Annotations = map[string]string{}
+	}
+	s.snap.Annotations[AnnotationExportState] = ExportStateWaitingSnapshot
+	s.snap.Annotations[AnnotationExportSnapshotProgress] = strconv.Itoa(progress) + "%"
+}
+
+func (s *Scope) ClearSnapshotProgress() {
+	delete(s.snap.Annotations, AnnotationExportSnapshotProgress)
+}
+
+func (s *Scope) ClearExportError() {
+	delete(s.snap.Annotations, AnnotationExportError)
+	delete(s.snap.Annotations, AnnotationExportErrorConfig)
+	delete(s.snap.Annotations, AnnotationExportJobFailures)
+}
+
+// AddJobFailure records a failure of the current worker Job, and returns the number of failures.
+func (s *Scope) AddJobFailure() int {
+	failures, _ := strconv.Atoi(s.snap.Annotations[AnnotationExportJobFailures])
+	failures++
+	if s.snap.Annotations == nil {
+		s.snap.Annotations = map[string]string{}
+	}
+	s.snap.Annotations[AnnotationExportJobFailures] = strconv.Itoa(failures)
+	return failures
+}
+
+func (s *Scope) ClearJobFailures() {
+	delete(s.snap.Annotations, AnnotationExportJobFailures)
+}
+
+// HasPermanentError checks if the export has permanently failed, and the configuration has not changed since.
+func (s *Scope) HasPermanentError() bool {
+	h, found := s.snap.Annotations[AnnotationExportErrorConfig]
+	return found && h == s.configHash()
+}
+
+// configHash computes a hash of the export configuration.
+func (s *Scope) configHash() string {
+	h := sha256.New()
+	for _, k := range slices.Sorted(maps.Keys(s.params)) {
+		_, _ = fmt.Fprintf(h, "%s=%s\n", k, s.params[k])
+	}
+	id, _ := s.GetSnapshotID()
+	_, _ = fmt.Fprintf(h, "snapshot=%s\n", id)
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// SetSkipped marks the export as skipped.
+func (s *Scope) SetSkipped(reason string) {
+	if s.snap.Annotations == nil {
+		s.snap.Annotations = map[string]string{}
+	}
+	s.snap.Annotations[AnnotationExportState] = ExportStateSkipped
+	s.snap.Annotations[AnnotationExportSkipReason] = reason
+}
+
 func (s *Scope) SetExportPath(path string) {
 	s.snap.Annotations[AnnotationExportPath] = path
 }
 
+// ExportManifest returns the manifest of the exported file.
+func (s *Scope) ExportManifest() (ExportManifest, error) {
+	var m ExportManifest
+	if err := json.Unmarshal([]byte(s.snap.Annotations[AnnotationExportManifest]), &m); err != nil {
+		return m, fmt.Errorf("invalid manifest: %w", err)
+	}
+	return m, nil
+}
+
+// SetExportManifest stores the manifest and the path of the exported file.
+func (s *Scope) SetExportManifest(m ExportManifest) {
+	s.SetExportPath(m.Path)
+	s.snap.Annotations[AnnotationExportManifest] = m.String()
+}
+
 // Close closes the scope of the cluster configuration and status
 func (s *Scope) Close(ctx context.Context) error {
 	before, err := runtime.DefaultUnstructuredConverter.ToUnstructured(s.snapBefore)
@@ -118,6 +868,10 @@ func (s *Scope) Close(ctx context.Context) error {
 	}
 
 	patch := client.MergeFrom(s.snapBefore.(client.Object))
+	if !slices.Equal(s.snapBefore.(client.Object).GetFinalizers(), s.snap.Finalizers) {
+		// finalizers are replaced as a whole by merge patches
+		patch = client.MergeFromWithOptions(s.snapBefore.(client.Object), client.MergeFromWithOptimisticLock{})
+	}
 	if err := s.client.Patch(ctx, s.snap, patch); err != nil {
 		return fmt.Errorf("patch: %w", err)
 	}
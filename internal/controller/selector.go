@@ -0,0 +1,56 @@
+/*
+SPDX-FileCopyrightText: 2025 Outscale SAS <opensource@outscale.com>
+
+SPDX-License-Identifier: BSD-3-Clause
+*/
+package controller
+
+import (
+	"context"
+	"fmt"
+	"maps"
+
+	volumesnapshotv1 "github.com/kubernetes-csi/external-snapshotter/client/v8/apis/volumesnapshot/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// +kubebuilder:rbac:groups=snapshot.storage.k8s.io,resources=volumesnapshots,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=persistentvolumeclaims;namespaces,verbs=get;list;watch
+
+// isSelected checks if the source VolumeSnapshot and PVC of a content match the selectors of the class.
+// Labels of the VolumeSnapshot take precedence over labels of the PVC. An error is returned if the VolumeSnapshot is not found (yet).
+func (r *VolumeSnaphotContentReconciler) isSelected(ctx context.Context, scope *Scope, selector, nsSelector labels.Selector) (bool, error) {
+	if selector.Empty() && nsSelector.Empty() {
+		return true, nil
+	}
+	ref := scope.VolumeSnapshotRef()
+	if !nsSelector.Empty() {
+		var ns corev1.Namespace
+		if err := r.k8s.Get(ctx, types.NamespacedName{Name: ref.Namespace}, &ns); client.IgnoreNotFound(err) != nil {
+			return false, fmt.Errorf("unable to fetch namespace: %w", err)
+		}
+		if !nsSelector.Matches(labels.Set(ns.Labels)) {
+			return false, nil
+		}
+	}
+	if selector.Empty() {
+		return true, nil
+	}
+	var vs volumesnapshotv1.VolumeSnapshot
+	if err := r.k8s.Get(ctx, ref, &vs); err != nil {
+		return false, fmt.Errorf("unable to fetch snapshot: %w", err)
+	}
+	set := labels.Set{}
+	if pvc := vs.Spec.Source.PersistentVolumeClaimName; pvc != nil {
+		var claim corev1.PersistentVolumeClaim
+		if err := r.k8s.Get(ctx, types.NamespacedName{Namespace: ref.Namespace, Name: *pvc}, &claim); client.IgnoreNotFound(err) != nil {
+			return false, fmt.Errorf("unable to fetch claim: %w", err)
+		}
+		maps.Copy(set, claim.Labels)
+	}
+	maps.Copy(set, vs.Labels)
+	return selector.Matches(set), nil
+}
@@ -0,0 +1,309 @@
+/*
+SPDX-FileCopyrightText: 2025 Outscale SAS <opensource@outscale.com>
+
+SPDX-License-Identifier: BSD-3-Clause
+*/
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"time"
+
+	volumesnapshotv1 "github.com/kubernetes-csi/external-snapshotter/client/v8/apis/volumesnapshot/v1"
+	"github.com/outscale/goutils/sdk/ptr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// TemplateData is the data available in templates.
+type TemplateData struct {
+	// Namespace and VolumeSnapshot are the namespace and name of the source VolumeSnapshot.
+	Namespace      string
+	VolumeSnapshot string
+	// Labels and Annotations are the labels and annotations of the source VolumeSnapshot.
+	Labels      map[string]string
+	Annotations map[string]string
+	// PVC, PV and StorageClass are the source PVC, its volume and storage class.
+	PVC          string
+	PV           string
+	StorageClass string
+	// SnapshotID is the ID of the BSU snapshot, Content the name of the VolumeSnapshotContent.
+	SnapshotID string
+	Content    string
+	// Cluster is the ID of the cluster.
+	Cluster string
+	// Time is the creation time of the snapshot, Now the time of the first export attempt.
+	Time time.Time
+	Now  time.Time
+}
+
+var errForbiddenFunc = errors.New("function is not allowed")
+
+// templateFuncs is the sandboxed function set available in templates.
+func templateFuncs(data *TemplateData) template.FuncMap {
+	return template.FuncMap{
+		"date": func(layout string) string {
+			return data.Time.Format(layout)
+		},
+		"now": func(layout string) string {
+			return data.Now.Format(layout)
+		},
+		"lower":      strings.ToLower,
+		"upper":      strings.ToUpper,
+		"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+		"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+		"replace":    func(from, to, s string) string { return strings.ReplaceAll(s, from, to) },
+		"default": func(def, s string) string {
+			if s == "" {
+				return def
+			}
+			return s
+		},
+		// data has no function fields, but calling functions is not allowed
+		"call": func(...any) (any, error) { return nil, errForbiddenFunc },
+	}
+}
+
+// legacyPlaceholders converts the placeholders of previous versions to templates.
+var legacyPlaceholders = strings.NewReplacer(
+	"{date}", `{{ date "2006-01-02" }}`,
+	"{now}", `{{ now "2006-01-02" }}`,
+	"{vs}", "{{ .VolumeSnapshot }}",
+	"{ns}", "{{ .Namespace }}",
+)
+
+// ExecuteTemplate renders a template. All the variables used by the template are checked, even in branches that are not executed.
+func ExecuteTemplate(tpl string, data *TemplateData) (string, error) {
+	t, err := template.New("").Option("missingkey=zero").Funcs(templateFuncs(data)).Parse(legacyPlaceholders.Replace(tpl))
+	if err != nil {
+		return "", fmt.Errorf("invalid template: %w", err)
+	}
+	if err := checkNode(t.Root, templateDataType); err != nil {
+		return "", fmt.Errorf("invalid template: %w", err)
+	}
+	var sb strings.Builder
+	if err := t.Execute(&sb, data); err != nil {
+		return "", fmt.Errorf("invalid template: %w", err)
+	}
+	return sb.String(), nil
+}
+
+// ValidateTemplate checks that a template is valid and only uses known variables.
+func ValidateTemplate(tpl string) error {
+	_, err := ExecuteTemplate(tpl, &TemplateData{})
+	return err
+}
+
+var templateDataType = reflect.TypeFor[TemplateData]()
+
+// checkNode checks the fields used by a node of a template, dot being the type of dot, or nil if unknown.
+func checkNode(node parse.Node, dot reflect.Type) error {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return nil
+		}
+		for _, child := range n.Nodes {
+			if err := checkNode(child, dot); err != nil {
+				return err
+			}
+		}
+	case *parse.ActionNode:
+		return checkPipe(n.Pipe, dot)
+	case *parse.TemplateNode:
+		return checkPipe(n.Pipe, dot)
+	case *parse.IfNode:
+		return checkBranch(&n.BranchNode, dot, dot)
+	case *parse.WithNode:
+		return checkBranch(&n.BranchNode, dot, pipeType(n.Pipe, dot))
+	case *parse.RangeNode:
+		var elem reflect.Type
+		switch t := pipeType(n.Pipe, dot); {
+		case t == nil:
+		case t.Kind() == reflect.Map, t.Kind() == reflect.Slice:
+			elem = t.Elem()
+		}
+		return checkBranch(&n.BranchNode, dot, elem)
+	}
+	return nil
+}
+
+// checkBranch checks a branch, inner being the type of dot within the branch.
+func checkBranch(n *parse.BranchNode, dot, inner reflect.Type) error {
+	if err := checkPipe(n.Pipe, dot); err != nil {
+		return err
+	}
+	if err := checkNode(n.List, inner); err != nil {
+		return err
+	}
+	return checkNode(n.ElseList, dot)
+}
+
+func checkPipe(pipe *parse.PipeNode, dot reflect.Type) error {
+	if pipe == nil {
+		return nil
+	}
+	for _, cmd := range pipe.Cmds {
+		for _, arg := range cmd.Args {
+			if err := checkArg(arg, dot); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func checkArg(arg parse.Node, dot reflect.Type) error {
+	switch n := arg.(type) {
+	case *parse.FieldNode:
+		_, err := fieldType(dot, n.Ident)
+		return err
+	case *parse.VariableNode:
+		if n.Ident[0] == "$" {
+			_, err := fieldType(templateDataType, n.Ident[1:])
+			return err
+		}
+	case *parse.IdentifierNode:
+		if n.Ident == "call" {
+			return errForbiddenFunc
+		}
+	case *parse.PipeNode:
+		return checkPipe(n, dot)
+	case *parse.ChainNode:
+		return checkArg(n.Node, dot)
+	}
+	return nil
+}
+
+// pipeType returns the type of a pipeline made of a single field, or nil if unknown.
+func pipeType(pipe *parse.PipeNode, dot reflect.Type) reflect.Type {
+	if pipe == nil || len(pipe.Cmds) != 1 || len(pipe.Cmds[0].Args) != 1 {
+		return nil
+	}
+	switch n := pipe.Cmds[0].Args[0].(type) {
+	case *parse.DotNode:
+		return dot
+	case *parse.FieldNode:
+		t, _ := fieldType(dot, n.Ident)
+		return t
+	}
+	return nil
+}
+
+// fieldType resolves a chain of fields or methods, from a type. Unknown types are not checked.
+func fieldType(t reflect.Type, idents []string) (reflect.Type, error) {
+	for _, ident := range idents {
+		if t == nil {
+			return nil, nil
+		}
+		if m, found := t.MethodByName(ident); found {
+			if m.Type.NumOut() == 0 {
+				return nil, nil
+			}
+			t = m.Type.Out(0)
+			continue
+		}
+		switch t.Kind() {
+		case reflect.Struct:
+			f, found := t.FieldByName(ident)
+			if !found || !f.IsExported() {
+				return nil, fmt.Errorf("unknown variable %q", ident)
+			}
+			t = f.Type
+		case reflect.Map:
+			t = t.Elem()
+		case reflect.Interface:
+			return nil, nil
+		default:
+			return nil, fmt.Errorf("%s has no field %q", t, ident)
+		}
+	}
+	return t, nil
+}
+
+// renderTemplatePairs renders a comma separated list of key=template pairs.
+// Commas within {{ }} actions are not separators.
+func renderTemplatePairs(v string, data *TemplateData) (map[string]string, error) {
+	pairs := map[string]string{}
+	for _, pair := range splitTemplateList(v) {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		k, tpl, found := strings.Cut(pair, "=")
+		k = strings.TrimSpace(k)
+		switch _, dup := pairs[k]; {
+		case !found || k == "":
+			return nil, fmt.Errorf("%q is not a key=value pair", pair)
+		case dup:
+			return nil, fmt.Errorf("duplicate key %q", k)
+		}
+		value, err := ExecuteTemplate(strings.TrimSpace(tpl), data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", k, err)
+		}
+		pairs[k] = value
+	}
+	return pairs, nil
+}
+
+func splitTemplateList(v string) []string {
+	var (
+		items []string
+		depth int
+		start int
+	)
+	for i := 0; i < len(v); i++ {
+		switch {
+		case strings.HasPrefix(v[i:], "{{"):
+			depth++
+			i++
+		case strings.HasPrefix(v[i:], "}}") && depth > 0:
+			depth--
+			i++
+		case v[i] == ',' && depth == 0:
+			items = append(items, v[start:i])
+			start = i + 1
+		}
+	}
+	return append(items, v[start:])
+}
+
+// templateData fetches the data of the source VolumeSnapshot and PVC of a content.
+// Missing sources are ignored.
+func (r *VolumeSnaphotContentReconciler) templateData(ctx context.Context, scope *Scope) (*TemplateData, error) {
+	ref := scope.VolumeSnapshotRef()
+	id, _ := scope.GetSnapshotID()
+	data := &TemplateData{
+		Namespace:      ref.Namespace,
+		VolumeSnapshot: ref.Name,
+		SnapshotID:     id,
+		Content:        scope.Name(),
+		Cluster:        r.clusterID,
+		Time:           scope.CreationTime(),
+		Now:            time.Now(),
+	}
+	var vs volumesnapshotv1.VolumeSnapshot
+	if err := r.k8s.Get(ctx, ref, &vs); client.IgnoreNotFound(err) != nil {
+		return nil, fmt.Errorf("unable to fetch snapshot: %w", err)
+	}
+	data.Labels = vs.Labels
+	data.Annotations = vs.Annotations
+	if vs.Spec.Source.PersistentVolumeClaimName == nil {
+		return data, nil
+	}
+	data.PVC = *vs.Spec.Source.PersistentVolumeClaimName
+	var claim corev1.PersistentVolumeClaim
+	if err := r.k8s.Get(ctx, types.NamespacedName{Namespace: ref.Namespace, Name: data.PVC}, &claim); client.IgnoreNotFound(err) != nil {
+		return nil, fmt.Errorf("unable to fetch claim: %w", err)
+	}
+	data.PV = claim.Spec.VolumeName
+	data.StorageClass = ptr.From(claim.Spec.StorageClassName)
+	return data, nil
+}
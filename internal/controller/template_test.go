@@ -0,0 +1,194 @@
+/*
+SPDX-FileCopyrightText: 2025 Outscale SAS <opensource@outscale.com>
+
+SPDX-License-Identifier: BSD-3-Clause
+*/
+package controller_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	volumesnapshotv1 "github.com/kubernetes-csi/external-snapshotter/client/v8/apis/volumesnapshot/v1"
+	"github.com/outscale/csi-snapshot-exporter/internal/controller"
+	"github.com/outscale/csi-snapshot-exporter/internal/objectstore/objectstoretest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestExecuteTemplate(t *testing.T) {
+	data := &controller.TemplateData{
+		Namespace:      "bar",
+		VolumeSnapshot: "foo",
+		Labels:         map[string]string{"app": "db"},
+		PVC:            "data",
+		StorageClass:   "gp2",
+		SnapshotID:     "snap-foo",
+		Cluster:        "prod",
+		Time:           time.Date(2025, 11, 3, 12, 4, 5, 0, time.UTC),
+		Now:            time.Date(2025, 11, 4, 0, 1, 0, 0, time.UTC),
+	}
+	tcs := []struct {
+		name     string
+		tpl      string
+		expected string
+	}{
+		{name: "legacy placeholders", tpl: "{ns}/{vs}/{date}/", expected: "bar/foo/2025-11-03/"},
+		{name: "variables", tpl: "{{ .Cluster }}/{{ .StorageClass }}/{{ .PVC }}/{{ .SnapshotID }}/", expected: "prod/gp2/data/snap-foo/"},
+		{name: "labels", tpl: "{{ .Labels.app }}/{{ .Labels.team }}/", expected: "db//"},
+		{name: "date layout", tpl: `{{ date "2006/01/02/15" }}/`, expected: "2025/11/03/12/"},
+		{name: "export time", tpl: `{now}/{{ now "15:04" }}/`, expected: "2025-11-04/00:01/"},
+		{name: "functions", tpl: `{{ upper .Namespace }}/{{ trimPrefix "snap-" .SnapshotID }}/{{ default "none" .PV }}/`, expected: "BAR/foo/none/"},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			res, err := controller.ExecuteTemplate(tc.tpl, data)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, res)
+		})
+	}
+}
+
+func TestValidateTemplate(t *testing.T) {
+	require.NoError(t, controller.ValidateTemplate(`{{ .Namespace }}/{{ date "2006" }}/{vs}/`))
+	require.Error(t, controller.ValidateTemplate("{{ .Unknown }}/"), "unknown field")
+	require.Error(t, controller.ValidateTemplate("{{ .Namespace "), "syntax error")
+	require.Error(t, controller.ValidateTemplate("{{ env }}/"), "unknown function")
+	require.Error(t, controller.ValidateTemplate(`{{ call .Namespace }}/`), "forbidden function")
+	require.Error(t, controller.ValidateTemplate(`{{ if .Namespace }}{{ .Foo }}{{ end }}`), "unknown field in a branch not executed")
+	require.Error(t, controller.ValidateTemplate(`{{ range .Labels }}{{ .Foo }}{{ end }}`), "unknown field of a label")
+	require.Error(t, controller.ValidateTemplate(`{{ with .PVC }}{{ $.Foo }}{{ end }}`), "unknown root field")
+	require.NoError(t, controller.ValidateTemplate(`{{ if .Labels.tier }}{{ .Labels.tier }}{{ else }}{{ .Time.Year }}{{ end }}`))
+	require.NoError(t, controller.ValidateTemplate(`{{ with .Annotations }}{{ index . "team" }}{{ end }}`))
+}
+
+func TestVolumeSnapshotClassValidator(t *testing.T) {
+	class := func(params map[string]string) *volumesnapshotv1.VolumeSnapshotClass {
+		return &volumesnapshotv1.VolumeSnapshotClass{ObjectMeta: metav1.ObjectMeta{Name: "foo"}, Driver: controller.DriverBSU, Parameters: params}
+	}
+	tcs := []struct {
+		name   string
+		params map[string]string
+		valid  bool
+	}{
+		{name: "no export", params: map[string]string{controller.ParamExportPrefix: "{{ .Unknown }}"}, valid: true},
+		{name: "valid export", params: map[string]string{
+			controller.ParamExportEnabled: "true",
+			controller.ParamExportBucket:  "foo",
+			controller.ParamExportPrefix:  "{{ .Namespace }}/{date}/",
+		}, valid: true},
+		{name: "missing bucket", params: map[string]string{controller.ParamExportEnabled: "true"}},
+		{name: "invalid format", params: map[string]string{
+			controller.ParamExportEnabled: "true",
+			controller.ParamExportBucket:  "foo",
+			controller.ParamExportFormat:  "vmdk",
+		}},
+		{name: "invalid compression", params: map[string]string{
+			controller.ParamExportEnabled:     "true",
+			controller.ParamExportBucket:      "foo",
+			controller.ParamExportCompression: "zstd",
+		}},
+		{name: "compression not supported by BSU", params: map[string]string{
+			controller.ParamExportEnabled:     "true",
+			controller.ParamExportBucket:      "foo",
+			controller.ParamExportCompression: "none",
+		}},
+		{name: "invalid prefix", params: map[string]string{
+			controller.ParamExportEnabled: "true",
+			controller.ParamExportBucket:  "foo",
+			controller.ParamExportPrefix:  "{{ .Unknown }}/",
+		}},
+		{name: "invalid selector", params: map[string]string{
+			controller.ParamExportEnabled:  "true",
+			controller.ParamExportBucket:   "foo",
+			controller.ParamExportSelector: "app in (",
+		}},
+	}
+	store := objectstoretest.NewMemory()
+	store.EnableObjectLock("locked")
+	lock := func(bucket string) map[string]string {
+		return map[string]string{
+			controller.ParamExportEnabled:       "true",
+			controller.ParamExportBucket:        bucket,
+			controller.ParamExportLockMode:      "governance",
+			controller.ParamExportLockRetention: "24h",
+		}
+	}
+	tcs = append(tcs, []struct {
+		name   string
+		params map[string]string
+		valid  bool
+	}{
+		{name: "tags", params: map[string]string{
+			controller.ParamExportEnabled:  "true",
+			controller.ParamExportBucket:   "foo",
+			controller.ParamExportTags:     `team={{ .Labels.team }},path={{ replace "," "-" .PVC }}`,
+			controller.ParamExportMetadata: "cluster={{ .Cluster }}",
+		}, valid: true},
+		{name: "invalid tags", params: map[string]string{
+			controller.ParamExportEnabled: "true",
+			controller.ParamExportBucket:  "foo",
+			controller.ParamExportTags:    "team",
+		}},
+		{name: "invalid metadata key", params: map[string]string{
+			controller.ParamExportEnabled:  "true",
+			controller.ParamExportBucket:   "foo",
+			controller.ParamExportMetadata: "my key={{ .Cluster }}",
+		}},
+		{name: "copy targets", params: map[string]string{
+			controller.ParamExportEnabled:     "true",
+			controller.ParamExportBucket:      "foo",
+			controller.ParamExportCopyTargets: `[{"name":"dr","endpoint":"https://s3.example.com","bucket":"foo","secret":"dr"}]`,
+		}, valid: true},
+		{name: "invalid copy target", params: map[string]string{
+			controller.ParamExportEnabled:     "true",
+			controller.ParamExportBucket:      "foo",
+			controller.ParamExportCopyTargets: `[{"name":"DR","endpoint":"s3.example.com","bucket":"foo","secret":"dr"}]`,
+		}},
+		{name: "incremental", params: map[string]string{
+			controller.ParamExportEnabled:     "true",
+			controller.ParamExportBucket:      "foo",
+			controller.ParamExportFormat:      "raw",
+			controller.ParamExportIncremental: "true",
+			controller.ParamExportFullEvery:   "10",
+		}, valid: true},
+		{name: "incremental requires raw", params: map[string]string{
+			controller.ParamExportEnabled:     "true",
+			controller.ParamExportBucket:      "foo",
+			controller.ParamExportIncremental: "true",
+		}},
+		{name: "archive", params: map[string]string{
+			controller.ParamExportEnabled:            "true",
+			controller.ParamExportBucket:             "foo",
+			controller.ParamExportThenDeleteSnapshot: "afterDays=30",
+		}, valid: true},
+		{name: "invalid archive delay", params: map[string]string{
+			controller.ParamExportEnabled:            "true",
+			controller.ParamExportBucket:             "foo",
+			controller.ParamExportThenDeleteSnapshot: "30d",
+		}},
+		{name: "object lock", params: lock("locked"), valid: true},
+		{name: "object lock is not enabled on bucket", params: lock("foo")},
+		{name: "missing lock retention", params: map[string]string{
+			controller.ParamExportEnabled:  "true",
+			controller.ParamExportBucket:   "locked",
+			controller.ParamExportLockMode: "governance",
+		}},
+	}...)
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			v := &controller.VolumeSnapshotClassValidator{Store: store}
+			_, err := v.ValidateCreate(context.TODO(), class(tc.params))
+			_, uerr := v.ValidateUpdate(context.TODO(), class(nil), class(tc.params))
+			if tc.valid {
+				require.NoError(t, err)
+				require.NoError(t, uerr)
+			} else {
+				require.Error(t, err)
+				require.Error(t, uerr)
+			}
+		})
+	}
+}
@@ -7,30 +7,73 @@ package controller
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
 	volumesnapshotv1 "github.com/kubernetes-csi/external-snapshotter/client/v8/apis/volumesnapshot/v1"
+	"github.com/outscale/csi-snapshot-exporter/internal/objectstore"
 	"github.com/outscale/osc-sdk-go/v3/pkg/osc"
+	batchv1 "k8s.io/api/batch/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/klog/v2"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 )
 
 // VolumeSnaphotContentReconciler reconciles a VolumeSnaphotContent object
 type VolumeSnaphotContentReconciler struct {
-	k8s    client.Client
-	oapi   osc.ClientInterface
-	Scheme *runtime.Scheme
+	k8s      client.Client
+	oapi     osc.ClientInterface
+	store    objectstore.Store
+	tasks    *TaskPoller
+	backends *Backends
+	recorder record.EventRecorder
+	Scheme   *runtime.Scheme
+
+	clusterID string
+	// requestBuckets are the buckets on-demand exports may be requested to.
+	requestBuckets []string
+
+	workerImage, workerNamespace, workerSecret string
 }
 
-func NewVolumeSnaphotContentReconciler(k8s client.Client, scheme *runtime.Scheme, oapi osc.ClientInterface) *VolumeSnaphotContentReconciler {
-	return &VolumeSnaphotContentReconciler{
-		k8s:    k8s,
-		oapi:   oapi,
-		Scheme: scheme,
+func NewVolumeSnaphotContentReconciler(
+	k8s client.Client, scheme *runtime.Scheme, oapi osc.ClientInterface, store objectstore.Store, opts Options,
+) *VolumeSnaphotContentReconciler {
+	r := &VolumeSnaphotContentReconciler{
+		k8s:      k8s,
+		oapi:     oapi,
+		store:    store,
+		tasks:    NewTaskPoller(oapi, opts.PollIntervals()),
+		backends: NewBackends(opts.Drivers),
+		recorder: &record.FakeRecorder{},
+		Scheme:   scheme,
+
+		clusterID:      opts.ClusterID,
+		requestBuckets: opts.ExportRequestBuckets,
+
+		workerImage:     opts.WorkerImage,
+		workerNamespace: opts.WorkerNamespace,
+		workerSecret:    opts.WorkerCredentialsSecret,
 	}
+	r.backends.Register(DriverBSU, oapiExporter{r: r})
+	r.backends.SetDefault(dataMover{r: r})
+	return r
+}
+
+// SetEventRecorder sets the recorder of the events of contents. Events are dropped until it is set.
+func (r *VolumeSnaphotContentReconciler) SetEventRecorder(recorder record.EventRecorder) {
+	r.recorder = recorder
+}
+
+// Backends returns the registry of exporters, to register the exporters of other drivers.
+func (r *VolumeSnaphotContentReconciler) Backends() *Backends {
+	return r.backends
 }
 
 // +kubebuilder:rbac:groups=snapshot.storage.k8s.io,resources=volumesnapshotcontents,verbs=get;list;watch;patch
@@ -45,38 +88,110 @@ func (r *VolumeSnaphotContentReconciler) Reconcile(ctx context.Context, req ctrl
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 
-	if !snap.DeletionTimestamp.IsZero() {
+	if !snap.DeletionTimestamp.IsZero() && !controllerutil.ContainsFinalizer(&snap, FinalizerExportProtection) {
 		log.V(3).Info("Snaphot is being deleted")
 		return ctrl.Result{}, nil
 	}
-	if snap.Spec.VolumeSnapshotClassName == nil {
-		log.V(3).Info("Snaphot has no class")
+
+	exporter, reason := r.backends.Get(snap.Spec.Driver)
+	if exporter == nil {
+		log.V(4).Info("Ignoring snapshot", "driver", snap.Spec.Driver, "reason", reason)
 		return ctrl.Result{}, nil
 	}
 
-	var snapClass volumesnapshotv1.VolumeSnapshotClass
-	if err := r.k8s.Get(ctx, types.NamespacedName{Name: *snap.Spec.VolumeSnapshotClassName}, &snapClass); err != nil {
-		err = fmt.Errorf("unable to fetch snapshot class: %w", err)
-		return ctrl.Result{}, client.IgnoreNotFound(err)
+	params, frozen, err := FrozenParameters(&snap)
+	if err != nil {
+		log.V(2).Error(err, "Unable to use frozen configuration, resolving it again")
 	}
-
-	scope := NewScope(r.k8s, &snap, &snapClass)
-	if !scope.NeedsExport() {
-		log.V(3).Info("No need to export snapshot")
-		return ctrl.Result{}, nil
+	switch {
+	case frozen:
+		log.V(5).Info("Using frozen configuration")
+	case snap.Spec.VolumeSnapshotClassName == nil:
+		log.V(4).Info("Snaphot has no class, using annotations")
+		params = AnnotationParameters(&snap)
+	default:
+		var snapClass volumesnapshotv1.VolumeSnapshotClass
+		if err := r.k8s.Get(ctx, types.NamespacedName{Name: *snap.Spec.VolumeSnapshotClassName}, &snapClass); err != nil {
+			err = fmt.Errorf("unable to fetch snapshot class: %w", err)
+			return ctrl.Result{}, client.IgnoreNotFound(err)
+		}
+		params = snapClass.Parameters
+	}
+	request, err := r.exportRequest(ctx, &snap, params, frozen)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if !frozen {
+		params = withExportRequest(params, request)
 	}
+
+	scope := NewScope(r.k8s, &snap, params)
 	defer func() {
 		if err := scope.Close(ctx); reterr == nil {
 			reterr = err
 		}
 	}()
-	return r.export(ctx, scope)
+	if ReresolveRequested(&snap) {
+		log.V(2).Info("Resolving export configuration again")
+		scope.Reresolve()
+	}
+	if request != "" {
+		scope.SetExportRequest(request)
+	}
+	if err := r.runAction(ctx, scope); err != nil {
+		return ctrl.Result{}, err
+	}
+	if !scope.NeedsExport() && !scope.NeedsArchive() && !scope.HasFinalizer() {
+		log.V(3).Info("No need to export snapshot")
+		if scope.ExportRequest() != "" {
+			// requests of completed exports are reported
+			return ctrl.Result{}, r.reportRequest(ctx, scope)
+		}
+		return ctrl.Result{}, nil
+	}
+	res, err := r.reconcile(ctx, scope, exporter)
+	if scope.ExportRequest() != "" && err == nil {
+		err = r.reportRequest(ctx, scope)
+	}
+	if terr, ok := errors.AsType[*ThrottledError](err); ok {
+		log.V(3).Info("OAPI call was throttled", "retry_after", terr.RetryAfter, "error", terr.Err.Error())
+		return ctrl.Result{RequeueAfter: terr.RetryAfter}, nil
+	}
+	return res, err
+}
+
+func (r *VolumeSnaphotContentReconciler) reconcile(ctx context.Context, scope *Scope, exporter Exporter) (ctrl.Result, error) {
+	if scope.IsDeleted() {
+		return r.release(ctx, scope, exporter)
+	}
+	var res ctrl.Result
+	var err error
+	switch {
+	case scope.NeedsExport():
+		res, err = r.export(ctx, scope, exporter)
+	case scope.NeedsArchive():
+		res, err = r.archive(ctx, scope, exporter)
+	}
+	// contents are protected once the export has started, the checks of the export may turn it down
+	r.protect(ctx, scope, exporter)
+	return res, err
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *VolumeSnaphotContentReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	err := mgr.GetFieldIndexer().IndexField(context.Background(), &volumesnapshotv1.VolumeSnapshotContent{}, IndexVolumeHandle, IndexByVolumeHandle)
+	if err != nil {
+		return fmt.Errorf("unable to add index: %w", err)
+	}
+	if err := mgr.Add(r.tasks); err != nil {
+		return fmt.Errorf("unable to add task poller: %w", err)
+	}
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&volumesnapshotv1.VolumeSnapshotContent{}).
+		For(&volumesnapshotv1.VolumeSnapshotContent{}, builder.WithPredicates(ContentPredicate())).
+		Watches(&volumesnapshotv1.VolumeSnapshot{}, handler.EnqueueRequestsFromMapFunc(ContentOfSnapshot),
+			builder.WithPredicates(ExportRequestPredicate())).
+		WatchesRawSource(r.tasks.Source()).
+		Owns(&batchv1.Job{}).
 		Named("snapshot_exporter").
 		Complete(r)
 }
@@ -6,33 +6,87 @@ SPDX-License-Identifier: BSD-3-Clause
 package controller_test
 
 import (
+	"bytes"
+	"encoding/json"
+	"strings"
 	"testing"
 	"time"
 
 	snapshotv1 "github.com/kubernetes-csi/external-snapshotter/client/v8/apis/volumesnapshot/v1"
+	"github.com/outscale/csi-snapshot-exporter/internal/blockdiff"
 	"github.com/outscale/csi-snapshot-exporter/internal/controller"
+	"github.com/outscale/csi-snapshot-exporter/internal/encryption"
+	"github.com/outscale/csi-snapshot-exporter/internal/objectstore"
+	"github.com/outscale/csi-snapshot-exporter/internal/objectstore/objectstoretest"
+	"github.com/outscale/csi-snapshot-exporter/internal/oci"
 	"github.com/outscale/goutils/sdk/mocks_osc"
 	"github.com/outscale/osc-sdk-go/v3/pkg/osc"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/mock/gomock"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
 	controllerruntime "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 )
 
-func initTest(mockCtl *gomock.Controller, vsc *snapshotv1.VolumeSnapshotContent, class *snapshotv1.VolumeSnapshotClass) (*controller.VolumeSnaphotContentReconciler, *mocks_osc.MockClient) {
+func initTest(mockCtl *gomock.Controller, vsc *snapshotv1.VolumeSnapshotContent, class *snapshotv1.VolumeSnapshotClass, objs ...client.Object) (
+	*controller.VolumeSnaphotContentReconciler, *mocks_osc.MockClient, client.Client,
+) {
+	return initTestWithStore(mockCtl, objectstoretest.NewMemory(), vsc, class, objs...)
+}
+
+func initTestWithStore(
+	mockCtl *gomock.Controller, store objectstore.Store, vsc *snapshotv1.VolumeSnapshotContent, class *snapshotv1.VolumeSnapshotClass, objs ...client.Object,
+) (
+	*controller.VolumeSnaphotContentReconciler, *mocks_osc.MockClient, client.Client,
+) {
 	fakeScheme := runtime.NewScheme()
 	_ = clientgoscheme.AddToScheme(fakeScheme)
 	_ = snapshotv1.AddToScheme(fakeScheme)
-	client := fake.NewClientBuilder().WithScheme(fakeScheme).
-		WithStatusSubresource(vsc).WithObjects(vsc, class).Build()
+	k8s := fake.NewClientBuilder().WithScheme(fakeScheme).
+		WithStatusSubresource(vsc).WithObjects(vsc, class).WithObjects(objs...).
+		WithIndex(&snapshotv1.VolumeSnapshotContent{}, controller.IndexVolumeHandle, controller.IndexByVolumeHandle).Build()
 	oapi := mocks_osc.NewMockClient(mockCtl)
-	return controller.NewVolumeSnaphotContentReconciler(client, fakeScheme, oapi), oapi
+	opts := controller.Options{
+		Drivers: []string{controller.DriverBSU, "hostpath.csi.k8s.io"}, ExportRequestBuckets: []string{"requests"}, WorkerImage: "exporter:test", WorkerNamespace: "kube-system", WorkerCredentialsSecret: "osc-csi-bsu"}
+	return controller.NewVolumeSnaphotContentReconciler(k8s, fakeScheme, oapi, store, opts), oapi, k8s
+}
+
+func expectTaskSearch(mockOAPI *mocks_osc.MockClient, tasks ...osc.SnapshotExportTask) {
+	mockOAPI.EXPECT().ReadSnapshotExportTasks(gomock.Any(), gomock.Eq(osc.ReadSnapshotExportTasksRequest{
+		Filters: &osc.FiltersSnapshotExportTask{
+			SnapshotIds: &[]string{"snap-foo"},
+		},
+	})).
+		Return(&osc.ReadSnapshotExportTasksResponse{SnapshotExportTasks: &tasks}, nil)
+}
+
+func expectSnapshotCompleted(mockOAPI *mocks_osc.MockClient) {
+	expectSnapshot(mockOAPI, osc.SnapshotStateCompleted, 100)
+}
+
+func expectSnapshot(mockOAPI *mocks_osc.MockClient, state osc.SnapshotState, progress int) {
+	mockOAPI.EXPECT().ReadSnapshots(gomock.Any(), gomock.Eq(osc.ReadSnapshotsRequest{
+		Filters: &osc.FiltersSnapshot{SnapshotIds: &[]string{"snap-foo"}},
+	})).
+		Return(&osc.ReadSnapshotsResponse{Snapshots: &[]osc.Snapshot{{SnapshotId: "snap-foo", State: state, Progress: &progress}}}, nil)
+}
+
+func expectTaskTagging(mockOAPI *mocks_osc.MockClient) {
+	mockOAPI.EXPECT().CreateTags(gomock.Any(), gomock.Eq(osc.CreateTagsRequest{
+		ResourceIds: []string{"snap-export-foo"},
+		Tags:        []osc.ResourceTag{{Key: controller.TagContentUID, Value: "vsc-uid"}, {Key: controller.TagClusterID}},
+	})).Return(&osc.CreateTagsResponse{}, nil)
 }
 
 func TestReconcile(t *testing.T) {
@@ -57,8 +111,10 @@ func TestReconcile(t *testing.T) {
 		},
 		ObjectMeta: metav1.ObjectMeta{
 			Name: "vsc",
+			UID:  "vsc-uid",
 		},
 		Spec: snapshotv1.VolumeSnapshotContentSpec{
+			Driver: controller.DriverBSU,
 			VolumeSnapshotRef: corev1.ObjectReference{
 				Name:      "vs",
 				Namespace: "ns",
@@ -67,6 +123,7 @@ func TestReconcile(t *testing.T) {
 		},
 		Status: &snapshotv1.VolumeSnapshotContentStatus{
 			SnapshotHandle: new("snap-foo"),
+			CreationTime:   new(time.Date(2025, 11, 3, 12, 0, 0, 0, time.Local).UnixNano()),
 		},
 	}
 	req := controllerruntime.Request{
@@ -79,17 +136,30 @@ func TestReconcile(t *testing.T) {
 		delete(class.Parameters, controller.ParamExportEnabled)
 		mockCtl := gomock.NewController(t)
 		defer mockCtl.Finish()
-		r, _ := initTest(mockCtl, vsc, class)
+		r, _, _ := initTest(mockCtl, vsc, class)
 		res, err := r.Reconcile(t.Context(), req)
 		require.NoError(t, err)
 		assert.Zero(t, res.RequeueAfter)
 	})
+	t.Run("Snapshots of other drivers are ignored", func(t *testing.T) {
+		vsc := vsc.DeepCopy()
+		vsc.Spec.Driver = "ebs.csi.aws.com"
+		mockCtl := gomock.NewController(t)
+		defer mockCtl.Finish()
+		r, _, k8s := initTest(mockCtl, vsc, class)
+		res, err := r.Reconcile(t.Context(), req)
+		require.NoError(t, err)
+		assert.Zero(t, res)
+		var updated snapshotv1.VolumeSnapshotContent
+		require.NoError(t, k8s.Get(t.Context(), req.NamespacedName, &updated))
+		assert.Empty(t, updated.Annotations)
+	})
 	t.Run("Request is requeued if snapshot is not available", func(t *testing.T) {
 		vsc := vsc.DeepCopy()
 		vsc.Status.SnapshotHandle = nil
 		mockCtl := gomock.NewController(t)
 		defer mockCtl.Finish()
-		r, _ := initTest(mockCtl, vsc, class)
+		r, _, _ := initTest(mockCtl, vsc, class)
 		res, err := r.Reconcile(t.Context(), req)
 		require.NoError(t, err)
 		assert.NotZero(t, res.RequeueAfter)
@@ -97,21 +167,565 @@ func TestReconcile(t *testing.T) {
 	t.Run("An export is started", func(t *testing.T) {
 		mockCtl := gomock.NewController(t)
 		defer mockCtl.Finish()
-		r, mockOAPI := initTest(mockCtl, vsc, class)
+		r, mockOAPI, k8s := initTest(mockCtl, vsc, class)
+		expectTaskSearch(mockOAPI)
+		expectSnapshotCompleted(mockOAPI)
+		mockOAPI.EXPECT().CreateSnapshotExportTask(gomock.Any(), gomock.Eq(osc.CreateSnapshotExportTaskRequest{
+			SnapshotId: "snap-foo",
+			OsuExport: osc.OsuExportToCreate{
+				DiskImageFormat: "qcow2",
+				OsuBucket:       "bucket",
+				OsuPrefix:       new("/vs/ns/2025-11-03"),
+			},
+		})).
+			Return(&osc.CreateSnapshotExportTaskResponse{SnapshotExportTask: &osc.SnapshotExportTask{
+				TaskId: "snap-export-foo",
+				State:  osc.SnapshotExportTaskStatePending,
+			}}, nil)
+		expectTaskTagging(mockOAPI)
+		res, err := r.Reconcile(t.Context(), req)
+		require.NoError(t, err)
+		assert.NotZero(t, res.RequeueAfter)
+		var updated snapshotv1.VolumeSnapshotContent
+		require.NoError(t, k8s.Get(t.Context(), req.NamespacedName, &updated))
+		assert.Equal(t, "/vs/ns/2025-11-03", updated.Annotations[controller.AnnotationExportResolvedPrefix])
+		assert.JSONEq(t, `{"exportToOOS":"true","exportBucket":"bucket","exportPrefix":"/{vs}/{ns}/{date}"}`,
+			updated.Annotations[controller.AnnotationExportConfig])
+	})
+	requestingSnapshot := func(bucket string) *snapshotv1.VolumeSnapshot {
+		return &snapshotv1.VolumeSnapshot{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "vs", Annotations: map[string]string{controller.AnnotationExportRequest: bucket}},
+			Status:     &snapshotv1.VolumeSnapshotStatus{BoundVolumeSnapshotContentName: new("vsc")},
+		}
+	}
+	t.Run("On-demand exports are started on request, even if the class does not export snapshots", func(t *testing.T) {
+		class := class.DeepCopy()
+		delete(class.Parameters, controller.ParamExportEnabled)
+		class.Parameters[controller.ParamExportBucket] = "bucket"
+		class.Parameters[controller.ParamExportEvery] = "100"
+		mockCtl := gomock.NewController(t)
+		defer mockCtl.Finish()
+		r, mockOAPI, k8s := initTest(mockCtl, vsc, class, requestingSnapshot("requests"))
+		expectTaskSearch(mockOAPI)
+		expectSnapshotCompleted(mockOAPI)
+		mockOAPI.EXPECT().CreateSnapshotExportTask(gomock.Any(), gomock.Eq(osc.CreateSnapshotExportTaskRequest{
+			SnapshotId: "snap-foo",
+			OsuExport: osc.OsuExportToCreate{
+				DiskImageFormat: "qcow2",
+				OsuBucket:       "requests",
+				OsuPrefix:       new("/vs/ns/2025-11-03"),
+			},
+		})).
+			Return(&osc.CreateSnapshotExportTaskResponse{SnapshotExportTask: &osc.SnapshotExportTask{
+				TaskId: "snap-export-foo",
+				State:  osc.SnapshotExportTaskStatePending,
+			}}, nil)
+		expectTaskTagging(mockOAPI)
+		_, err := r.Reconcile(t.Context(), req)
+		require.NoError(t, err)
+		var updated snapshotv1.VolumeSnapshotContent
+		require.NoError(t, k8s.Get(t.Context(), req.NamespacedName, &updated))
+		assert.Equal(t, "requests", updated.Annotations[controller.AnnotationExportRequested])
+		var vs snapshotv1.VolumeSnapshot
+		require.NoError(t, k8s.Get(t.Context(), types.NamespacedName{Namespace: "ns", Name: "vs"}, &vs))
+		assert.JSONEq(t, `{"state":"pending","bucket":"requests","task":"snap-export-foo"}`, vs.Annotations[controller.AnnotationExportRequestStatus])
+	})
+	t.Run("Results of on-demand exports are reported on the VolumeSnapshot", func(t *testing.T) {
+		class := class.DeepCopy()
+		delete(class.Parameters, controller.ParamExportEnabled)
+		vsc := vsc.DeepCopy()
+		vsc.Annotations = map[string]string{
+			controller.AnnotationExportRequested: "requests",
+			controller.AnnotationExportTask:      "snap-export-foo",
+			controller.AnnotationExportState:     controller.ExportStateFinalizing,
+			controller.AnnotationExportPath:      "snap-foo-foo.qcow2.gz",
+			controller.AnnotationExportManifest:  `{"bucket":"requests","path":"snap-foo-foo.qcow2.gz"}`,
+		}
+		mockCtl := gomock.NewController(t)
+		defer mockCtl.Finish()
+		r, _, k8s := initTest(mockCtl, vsc, class, requestingSnapshot("requests"))
+		_, err := r.Reconcile(t.Context(), req)
+		require.NoError(t, err)
+		var vs snapshotv1.VolumeSnapshot
+		require.NoError(t, k8s.Get(t.Context(), types.NamespacedName{Namespace: "ns", Name: "vs"}, &vs))
+		assert.JSONEq(t, `{"state":"completed","bucket":"requests","task":"snap-export-foo","path":"snap-foo-foo.qcow2.gz"}`,
+			vs.Annotations[controller.AnnotationExportRequestStatus])
+	})
+	t.Run("On-demand exports to buckets not allowed are rejected", func(t *testing.T) {
+		class := class.DeepCopy()
+		delete(class.Parameters, controller.ParamExportEnabled)
+		mockCtl := gomock.NewController(t)
+		defer mockCtl.Finish()
+		r, _, k8s := initTest(mockCtl, vsc, class, requestingSnapshot("other"))
+		res, err := r.Reconcile(t.Context(), req)
+		require.NoError(t, err)
+		assert.Zero(t, res)
+		var updated snapshotv1.VolumeSnapshotContent
+		require.NoError(t, k8s.Get(t.Context(), req.NamespacedName, &updated))
+		assert.Empty(t, updated.Annotations)
+		var vs snapshotv1.VolumeSnapshot
+		require.NoError(t, k8s.Get(t.Context(), types.NamespacedName{Namespace: "ns", Name: "vs"}, &vs))
+		assert.JSONEq(t, `{"state":"rejected","bucket":"other","error":"bucket \"other\" is not allowed"}`,
+			vs.Annotations[controller.AnnotationExportRequestStatus])
+	})
+	t.Run("Requests of snapshots already exported report the frozen export", func(t *testing.T) {
+		vsc := vsc.DeepCopy()
+		vsc.Annotations = map[string]string{
+			controller.AnnotationExportConfig: `{"exportToOOS":"true","exportBucket":"requests","exportImageFormat":"raw"}`,
+			controller.AnnotationExportTask:   "snap-export-foo",
+			controller.AnnotationExportState:  string(osc.SnapshotExportTaskStateCompleted),
+			controller.AnnotationExportPath:   "snap-foo-foo.raw.gz",
+		}
+		mockCtl := gomock.NewController(t)
+		defer mockCtl.Finish()
+		r, _, k8s := initTest(mockCtl, vsc, class, requestingSnapshot("requests"))
+		_, err := r.Reconcile(t.Context(), req)
+		require.NoError(t, err)
+		var updated snapshotv1.VolumeSnapshotContent
+		require.NoError(t, k8s.Get(t.Context(), req.NamespacedName, &updated))
+		assert.Equal(t, "requests", updated.Annotations[controller.AnnotationExportRequested])
+		var vs snapshotv1.VolumeSnapshot
+		require.NoError(t, k8s.Get(t.Context(), types.NamespacedName{Namespace: "ns", Name: "vs"}, &vs))
+		assert.JSONEq(t, `{"state":"completed","bucket":"requests","task":"snap-export-foo","path":"snap-foo-foo.raw.gz"}`,
+			vs.Annotations[controller.AnnotationExportRequestStatus])
+	})
+	t.Run("Requests of other buckets than the frozen one are rejected", func(t *testing.T) {
+		vsc := vsc.DeepCopy()
+		vsc.Annotations = map[string]string{
+			controller.AnnotationExportConfig: `{"exportToOOS":"true","exportBucket":"frozen","exportImageFormat":"raw"}`,
+			controller.AnnotationExportTask:   "snap-export-foo",
+			controller.AnnotationExportState:  string(osc.SnapshotExportTaskStateCompleted),
+		}
+		mockCtl := gomock.NewController(t)
+		defer mockCtl.Finish()
+		r, _, k8s := initTest(mockCtl, vsc, class, requestingSnapshot("requests"))
+		_, err := r.Reconcile(t.Context(), req)
+		require.NoError(t, err)
+		var updated snapshotv1.VolumeSnapshotContent
+		require.NoError(t, k8s.Get(t.Context(), req.NamespacedName, &updated))
+		assert.NotContains(t, updated.Annotations, controller.AnnotationExportRequested)
+		var vs snapshotv1.VolumeSnapshot
+		require.NoError(t, k8s.Get(t.Context(), types.NamespacedName{Namespace: "ns", Name: "vs"}, &vs))
+		assert.JSONEq(t, `{"state":"rejected","bucket":"requests","error":"snapshot is already exported to bucket \"frozen\""}`,
+			vs.Annotations[controller.AnnotationExportRequestStatus])
+	})
+	t.Run("Retries use the frozen configuration, even if the class is deleted", func(t *testing.T) {
+		vsc := vsc.DeepCopy()
+		vsc.Annotations = map[string]string{
+			controller.AnnotationExportConfig:         `{"exportToOOS":"true","exportBucket":"frozen","exportImageFormat":"raw"}`,
+			controller.AnnotationExportResolvedPrefix: "frozen/",
+			controller.AnnotationExportTask:           "snap-export-foo",
+			controller.AnnotationExportState:          string(osc.SnapshotExportTaskStateFailed),
+		}
+		deleted := class.DeepCopy()
+		deleted.Name = "other"
+		mockCtl := gomock.NewController(t)
+		defer mockCtl.Finish()
+		r, mockOAPI, _ := initTest(mockCtl, vsc, deleted)
+		mockOAPI.EXPECT().ReadSnapshotExportTasks(gomock.Any(), gomock.Eq(osc.ReadSnapshotExportTasksRequest{
+			Filters: &osc.FiltersSnapshotExportTask{TaskIds: &[]string{"snap-export-foo"}},
+		})).
+			Return(&osc.ReadSnapshotExportTasksResponse{SnapshotExportTasks: &[]osc.SnapshotExportTask{{
+				TaskId: "snap-export-foo",
+				State:  osc.SnapshotExportTaskStateFailed,
+			}}}, nil)
+		expectTaskSearch(mockOAPI)
+		expectSnapshotCompleted(mockOAPI)
+		mockOAPI.EXPECT().CreateSnapshotExportTask(gomock.Any(), gomock.Eq(osc.CreateSnapshotExportTaskRequest{
+			SnapshotId: "snap-foo",
+			OsuExport: osc.OsuExportToCreate{
+				DiskImageFormat: "raw",
+				OsuBucket:       "frozen",
+				OsuPrefix:       new("frozen/"),
+			},
+		})).
+			Return(&osc.CreateSnapshotExportTaskResponse{SnapshotExportTask: &osc.SnapshotExportTask{
+				TaskId: "snap-export-bar",
+				State:  osc.SnapshotExportTaskStatePending,
+			}}, nil)
+		mockOAPI.EXPECT().CreateTags(gomock.Any(), gomock.Any()).Return(&osc.CreateTagsResponse{}, nil)
+		_, err := r.Reconcile(t.Context(), req)
+		require.NoError(t, err)
+	})
+	t.Run("The configuration is resolved again on request", func(t *testing.T) {
+		vsc := vsc.DeepCopy()
+		vsc.Annotations = map[string]string{
+			controller.AnnotationExportConfig:         `{"exportToOOS":"true","exportBucket":"frozen","exportImageFormat":"raw"}`,
+			controller.AnnotationExportResolvedPrefix: "frozen/",
+			controller.AnnotationExportReresolve:      "true",
+		}
+		mockCtl := gomock.NewController(t)
+		defer mockCtl.Finish()
+		r, mockOAPI, k8s := initTest(mockCtl, vsc, class)
+		expectTaskSearch(mockOAPI)
+		expectSnapshotCompleted(mockOAPI)
 		mockOAPI.EXPECT().CreateSnapshotExportTask(gomock.Any(), gomock.Eq(osc.CreateSnapshotExportTaskRequest{
 			SnapshotId: "snap-foo",
 			OsuExport: osc.OsuExportToCreate{
 				DiskImageFormat: "qcow2",
 				OsuBucket:       "bucket",
-				OsuPrefix:       new("/vs/ns/" + time.Now().Format(time.DateOnly)),
+				OsuPrefix:       new("/vs/ns/2025-11-03"),
+			},
+		})).
+			Return(&osc.CreateSnapshotExportTaskResponse{SnapshotExportTask: &osc.SnapshotExportTask{
+				TaskId: "snap-export-foo",
+				State:  osc.SnapshotExportTaskStatePending,
+			}}, nil)
+		expectTaskTagging(mockOAPI)
+		_, err := r.Reconcile(t.Context(), req)
+		require.NoError(t, err)
+		var updated snapshotv1.VolumeSnapshotContent
+		require.NoError(t, k8s.Get(t.Context(), req.NamespacedName, &updated))
+		assert.NotContains(t, updated.Annotations, controller.AnnotationExportReresolve)
+		assert.Contains(t, updated.Annotations[controller.AnnotationExportConfig], `"exportBucket":"bucket"`)
+	})
+	t.Run("Exports wait for snapshots to be completed", func(t *testing.T) {
+		mockCtl := gomock.NewController(t)
+		defer mockCtl.Finish()
+		r, mockOAPI, k8s := initTest(mockCtl, vsc, class)
+		expectTaskSearch(mockOAPI)
+		expectSnapshot(mockOAPI, osc.SnapshotStatePending, 42)
+		res, err := r.Reconcile(t.Context(), req)
+		require.NoError(t, err)
+		assert.NotZero(t, res.RequeueAfter)
+		var updated snapshotv1.VolumeSnapshotContent
+		require.NoError(t, k8s.Get(t.Context(), req.NamespacedName, &updated))
+		assert.Equal(t, controller.ExportStateWaitingSnapshot, updated.Annotations[controller.AnnotationExportState])
+		assert.Equal(t, "42%", updated.Annotations[controller.AnnotationExportSnapshotProgress])
+		assert.NotContains(t, updated.Annotations, controller.AnnotationExportTask)
+	})
+	t.Run("Snapshots in error are not exported", func(t *testing.T) {
+		mockCtl := gomock.NewController(t)
+		defer mockCtl.Finish()
+		r, mockOAPI, k8s := initTest(mockCtl, vsc, class)
+		expectTaskSearch(mockOAPI)
+		expectSnapshot(mockOAPI, osc.SnapshotStateError, 0)
+		res, err := r.Reconcile(t.Context(), req)
+		require.NoError(t, err)
+		assert.Zero(t, res.RequeueAfter)
+		var updated snapshotv1.VolumeSnapshotContent
+		require.NoError(t, k8s.Get(t.Context(), req.NamespacedName, &updated))
+		assert.Contains(t, updated.Annotations[controller.AnnotationExportError], "error state")
+		assert.NotContains(t, updated.Annotations, controller.AnnotationExportTask)
+	})
+	t.Run("Pre-provisioned contents are exported using annotations", func(t *testing.T) {
+		vsc := vsc.DeepCopy()
+		vsc.Spec.VolumeSnapshotClassName = nil
+		vsc.Spec.Source.SnapshotHandle = new("snap-foo")
+		vsc.Status = nil
+		vsc.Annotations = map[string]string{
+			controller.AnnotationExportBucket: "bucket",
+			controller.AnnotationExportFormat: "raw",
+			controller.AnnotationExportPrefix: "{ns}/",
+		}
+		mockCtl := gomock.NewController(t)
+		defer mockCtl.Finish()
+		r, mockOAPI, _ := initTest(mockCtl, vsc, class)
+		expectTaskSearch(mockOAPI)
+		expectSnapshotCompleted(mockOAPI)
+		mockOAPI.EXPECT().CreateSnapshotExportTask(gomock.Any(), gomock.Eq(osc.CreateSnapshotExportTaskRequest{
+			SnapshotId: "snap-foo",
+			OsuExport: osc.OsuExportToCreate{
+				DiskImageFormat: "raw",
+				OsuBucket:       "bucket",
+				OsuPrefix:       new("ns/"),
 			},
 		})).
 			Return(&osc.CreateSnapshotExportTaskResponse{SnapshotExportTask: &osc.SnapshotExportTask{
-				State: osc.SnapshotExportTaskStatePending,
+				TaskId: "snap-export-foo",
+				State:  osc.SnapshotExportTaskStatePending,
+			}}, nil)
+		expectTaskTagging(mockOAPI)
+		res, err := r.Reconcile(t.Context(), req)
+		require.NoError(t, err)
+		assert.NotZero(t, res.RequeueAfter)
+	})
+	t.Run("No export is done if the compression is not supported by BSU snapshot exports", func(t *testing.T) {
+		class := class.DeepCopy()
+		class.Parameters[controller.ParamExportCompression] = "none"
+		mockCtl := gomock.NewController(t)
+		defer mockCtl.Finish()
+		r, _, _ := initTest(mockCtl, vsc, class)
+		res, err := r.Reconcile(t.Context(), req)
+		require.NoError(t, err)
+		assert.Zero(t, res.RequeueAfter)
+	})
+	t.Run("Pre-provisioned contents without annotations are not exported", func(t *testing.T) {
+		vsc := vsc.DeepCopy()
+		vsc.Spec.VolumeSnapshotClassName = nil
+		vsc.Spec.Source.SnapshotHandle = new("snap-foo")
+		mockCtl := gomock.NewController(t)
+		defer mockCtl.Finish()
+		r, _, _ := initTest(mockCtl, vsc, class)
+		res, err := r.Reconcile(t.Context(), req)
+		require.NoError(t, err)
+		assert.Zero(t, res.RequeueAfter)
+	})
+	t.Run("Snapshots not matching the selectors are not exported", func(t *testing.T) {
+		class := class.DeepCopy()
+		class.Parameters[controller.ParamExportSelector] = "backup-tier=gold"
+		vs := &snapshotv1.VolumeSnapshot{
+			ObjectMeta: metav1.ObjectMeta{Name: "vs", Namespace: "ns", Labels: map[string]string{"backup-tier": "silver"}},
+			Spec:       snapshotv1.VolumeSnapshotSpec{Source: snapshotv1.VolumeSnapshotSource{PersistentVolumeClaimName: new("pvc")}},
+		}
+		pvc := &corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "pvc", Namespace: "ns", Labels: map[string]string{"backup-tier": "gold"}},
+		}
+		mockCtl := gomock.NewController(t)
+		defer mockCtl.Finish()
+		r, _, k8s := initTest(mockCtl, vsc, class, vs, pvc)
+		res, err := r.Reconcile(t.Context(), req)
+		require.NoError(t, err)
+		assert.Zero(t, res.RequeueAfter)
+		var updated snapshotv1.VolumeSnapshotContent
+		require.NoError(t, k8s.Get(t.Context(), req.NamespacedName, &updated))
+		assert.Equal(t, controller.ExportStateSkipped, updated.Annotations[controller.AnnotationExportState])
+		assert.Equal(t, "snapshot does not match the export selectors", updated.Annotations[controller.AnnotationExportSkipReason])
+
+		// skipped snapshots are not selected again
+		res, err = r.Reconcile(t.Context(), req)
+		require.NoError(t, err)
+		assert.Zero(t, res.RequeueAfter)
+	})
+	t.Run("Selectors are not evaluated until the source snapshot is found", func(t *testing.T) {
+		class := class.DeepCopy()
+		class.Parameters[controller.ParamExportSelector] = "backup-tier=gold"
+		mockCtl := gomock.NewController(t)
+		defer mockCtl.Finish()
+		r, _, k8s := initTest(mockCtl, vsc, class)
+		_, err := r.Reconcile(t.Context(), req)
+		require.True(t, apierrors.IsNotFound(err))
+		var updated snapshotv1.VolumeSnapshotContent
+		require.NoError(t, k8s.Get(t.Context(), req.NamespacedName, &updated))
+		assert.NotContains(t, updated.Annotations, controller.AnnotationExportState)
+	})
+	t.Run("Snapshots matching the selectors are exported", func(t *testing.T) {
+		class := class.DeepCopy()
+		class.Parameters[controller.ParamExportSelector] = "backup-tier=gold"
+		class.Parameters[controller.ParamExportNamespaceSelector] = "env in (prod,preprod)"
+		vs := &snapshotv1.VolumeSnapshot{
+			ObjectMeta: metav1.ObjectMeta{Name: "vs", Namespace: "ns"},
+			Spec:       snapshotv1.VolumeSnapshotSpec{Source: snapshotv1.VolumeSnapshotSource{PersistentVolumeClaimName: new("pvc")}},
+		}
+		pvc := &corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "pvc", Namespace: "ns", Labels: map[string]string{"backup-tier": "gold"}},
+		}
+		ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns", Labels: map[string]string{"env": "prod"}}}
+		mockCtl := gomock.NewController(t)
+		defer mockCtl.Finish()
+		r, mockOAPI, _ := initTest(mockCtl, vsc, class, vs, pvc, ns)
+		expectTaskSearch(mockOAPI)
+		expectSnapshotCompleted(mockOAPI)
+		mockOAPI.EXPECT().CreateSnapshotExportTask(gomock.Any(), gomock.Any()).
+			Return(&osc.CreateSnapshotExportTaskResponse{SnapshotExportTask: &osc.SnapshotExportTask{
+				TaskId: "snap-export-foo",
+				State:  osc.SnapshotExportTaskStatePending,
+			}}, nil)
+		expectTaskTagging(mockOAPI)
+		res, err := r.Reconcile(t.Context(), req)
+		require.NoError(t, err)
+		assert.NotZero(t, res.RequeueAfter)
+	})
+	sampled := func(name string, created time.Time, annotations map[string]string) *snapshotv1.VolumeSnapshotContent {
+		vsc := vsc.DeepCopy()
+		vsc.Name = name
+		vsc.UID = types.UID(name + "-uid")
+		vsc.Annotations = annotations
+		vsc.Spec.Source.VolumeHandle = new("vol-foo")
+		vsc.Status.CreationTime = new(created.UnixNano())
+		return vsc
+	}
+	t.Run("Snapshots are skipped until every Nth snapshot", func(t *testing.T) {
+		class := class.DeepCopy()
+		class.Parameters[controller.ParamExportEvery] = "3"
+		now := time.Now()
+		exported := sampled("vsc-exported", now.Add(-3*time.Hour), map[string]string{
+			controller.AnnotationExportTask:  "snap-export-bar",
+			controller.AnnotationExportState: string(osc.SnapshotExportTaskStateCompleted),
+		})
+		skipped := sampled("vsc-skipped", now.Add(-2*time.Hour), map[string]string{
+			controller.AnnotationExportState: controller.ExportStateSkipped,
+		})
+		vsc := sampled("vsc", now, nil)
+		mockCtl := gomock.NewController(t)
+		defer mockCtl.Finish()
+		r, _, k8s := initTest(mockCtl, vsc, class, exported, skipped)
+		res, err := r.Reconcile(t.Context(), req)
+		require.NoError(t, err)
+		assert.Zero(t, res.RequeueAfter)
+		var updated snapshotv1.VolumeSnapshotContent
+		require.NoError(t, k8s.Get(t.Context(), req.NamespacedName, &updated))
+		assert.Equal(t, controller.ExportStateSkipped, updated.Annotations[controller.AnnotationExportState])
+		assert.NotEmpty(t, updated.Annotations[controller.AnnotationExportSkipReason])
+	})
+	t.Run("Snapshots are skipped within the minimum interval", func(t *testing.T) {
+		class := class.DeepCopy()
+		class.Parameters[controller.ParamExportMinInterval] = "24h"
+		now := time.Now()
+		exported := sampled("vsc-exported", now.Add(-30*time.Hour), map[string]string{
+			controller.AnnotationExportState: string(osc.SnapshotExportTaskStateCompleted),
+		})
+		// running exports are counted, the interval being measured from their snapshot
+		running := sampled("vsc-running", now.Add(-10*time.Minute), map[string]string{
+			controller.AnnotationExportTask:  "snap-export-bar",
+			controller.AnnotationExportState: string(osc.SnapshotExportTaskStateUploading),
+		})
+		vsc := sampled("vsc", now, nil)
+		mockCtl := gomock.NewController(t)
+		defer mockCtl.Finish()
+		r, _, k8s := initTest(mockCtl, vsc, class, exported, running)
+		_, err := r.Reconcile(t.Context(), req)
+		require.NoError(t, err)
+		var updated snapshotv1.VolumeSnapshotContent
+		require.NoError(t, k8s.Get(t.Context(), req.NamespacedName, &updated))
+		assert.Equal(t, controller.ExportStateSkipped, updated.Annotations[controller.AnnotationExportState])
+	})
+	t.Run("Snapshots are exported after the minimum interval", func(t *testing.T) {
+		class := class.DeepCopy()
+		class.Parameters[controller.ParamExportMinInterval] = "24h"
+		now := time.Now()
+		// the export of the last snapshot completed an hour ago, the interval being measured from the snapshot
+		exported := sampled("vsc-exported", now.Add(-26*time.Hour), map[string]string{
+			controller.AnnotationExportTask:        "snap-export-bar",
+			controller.AnnotationExportState:       string(osc.SnapshotExportTaskStateCompleted),
+			controller.AnnotationExportCompletedAt: now.Add(-time.Hour).UTC().Format(time.RFC3339),
+		})
+		// failed exports are not counted
+		failed := sampled("vsc-failed", now.Add(-time.Hour), map[string]string{
+			controller.AnnotationExportTask:  "snap-export-baz",
+			controller.AnnotationExportState: string(osc.SnapshotExportTaskStateFailed),
+		})
+		vsc := sampled("vsc", now, nil)
+		mockCtl := gomock.NewController(t)
+		defer mockCtl.Finish()
+		r, mockOAPI, _ := initTest(mockCtl, vsc, class, exported, failed)
+		expectTaskSearch(mockOAPI)
+		expectSnapshotCompleted(mockOAPI)
+		mockOAPI.EXPECT().CreateSnapshotExportTask(gomock.Any(), gomock.Any()).
+			Return(&osc.CreateSnapshotExportTaskResponse{SnapshotExportTask: &osc.SnapshotExportTask{
+				TaskId: "snap-export-foo",
+				State:  osc.SnapshotExportTaskStatePending,
 			}}, nil)
+		expectTaskTagging(mockOAPI)
+		res, err := r.Reconcile(t.Context(), req)
+		require.NoError(t, err)
+		assert.NotZero(t, res.RequeueAfter)
+	})
+	t.Run("An existing task is adopted instead of creating a new one", func(t *testing.T) {
+		mockCtl := gomock.NewController(t)
+		defer mockCtl.Finish()
+		r, mockOAPI, k8s := initTest(mockCtl, vsc, class)
+		expectTaskSearch(mockOAPI,
+			osc.SnapshotExportTask{
+				TaskId: "snap-export-failed",
+				State:  osc.SnapshotExportTaskStateFailed,
+				Tags:   []osc.ResourceTag{{Key: controller.TagContentUID, Value: "vsc-uid"}, {Key: controller.TagClusterID}},
+			},
+			osc.SnapshotExportTask{
+				TaskId: "snap-export-other",
+				State:  osc.SnapshotExportTaskStateUploading,
+				Tags:   []osc.ResourceTag{{Key: controller.TagContentUID, Value: "other-uid"}, {Key: controller.TagClusterID}},
+			},
+			osc.SnapshotExportTask{
+				TaskId: "snap-export-foo",
+				State:  osc.SnapshotExportTaskStateUploading,
+				Tags:   []osc.ResourceTag{{Key: controller.TagContentUID, Value: "vsc-uid"}, {Key: controller.TagClusterID}},
+			})
 		res, err := r.Reconcile(t.Context(), req)
 		require.NoError(t, err)
 		assert.NotZero(t, res.RequeueAfter)
+		var updated snapshotv1.VolumeSnapshotContent
+		require.NoError(t, k8s.Get(t.Context(), req.NamespacedName, &updated))
+		assert.Equal(t, "snap-export-foo", updated.Annotations[controller.AnnotationExportTask])
+	})
+	t.Run("Tagging of new tasks is retried until it succeeds", func(t *testing.T) {
+		mockCtl := gomock.NewController(t)
+		defer mockCtl.Finish()
+		r, mockOAPI, k8s := initTest(mockCtl, vsc, class)
+		expectTaskSearch(mockOAPI)
+		expectSnapshotCompleted(mockOAPI)
+		mockOAPI.EXPECT().CreateSnapshotExportTask(gomock.Any(), gomock.Any()).
+			Return(&osc.CreateSnapshotExportTaskResponse{SnapshotExportTask: &osc.SnapshotExportTask{
+				TaskId: "snap-export-foo",
+				State:  osc.SnapshotExportTaskStatePending,
+			}}, nil)
+		mockOAPI.EXPECT().CreateTags(gomock.Any(), gomock.Any()).Return(nil, oapiError(409, "6031", "InvalidState"))
+		_, err := r.Reconcile(t.Context(), req)
+		require.Error(t, err)
+		var updated snapshotv1.VolumeSnapshotContent
+		require.NoError(t, k8s.Get(t.Context(), req.NamespacedName, &updated))
+		assert.Equal(t, "snap-export-foo", updated.Annotations[controller.AnnotationExportTask])
+		assert.Contains(t, updated.Annotations, controller.AnnotationExportTaskUntagged)
+
+		expectTaskTagging(mockOAPI)
+		mockOAPI.EXPECT().ReadSnapshotExportTasks(gomock.Any(), gomock.Any()).
+			Return(&osc.ReadSnapshotExportTasksResponse{SnapshotExportTasks: &[]osc.SnapshotExportTask{{
+				TaskId: "snap-export-foo",
+				State:  osc.SnapshotExportTaskStateUploading,
+			}}}, nil)
+		_, err = r.Reconcile(t.Context(), req)
+		require.NoError(t, err)
+		require.NoError(t, k8s.Get(t.Context(), req.NamespacedName, &updated))
+		assert.NotContains(t, updated.Annotations, controller.AnnotationExportTaskUntagged)
+	})
+	t.Run("Permanent errors are reported and not retried", func(t *testing.T) {
+		mockCtl := gomock.NewController(t)
+		defer mockCtl.Finish()
+		r, mockOAPI, k8s := initTest(mockCtl, vsc, class)
+		expectTaskSearch(mockOAPI)
+		expectSnapshotCompleted(mockOAPI)
+		mockOAPI.EXPECT().CreateSnapshotExportTask(gomock.Any(), gomock.Any()).
+			Return(nil, oapiError(400, "5054", "InvalidResource"))
+		res, err := r.Reconcile(t.Context(), req)
+		require.NoError(t, err)
+		assert.Zero(t, res.RequeueAfter)
+		var updated snapshotv1.VolumeSnapshotContent
+		require.NoError(t, k8s.Get(t.Context(), req.NamespacedName, &updated))
+		assert.Equal(t, string(osc.SnapshotExportTaskStateFailed), updated.Annotations[controller.AnnotationExportState])
+		assert.Contains(t, updated.Annotations[controller.AnnotationExportError], "InvalidResource")
+
+		res, err = r.Reconcile(t.Context(), req)
+		require.NoError(t, err)
+		assert.Zero(t, res.RequeueAfter)
+	})
+	t.Run("Permanent errors are retried when the class changes", func(t *testing.T) {
+		mockCtl := gomock.NewController(t)
+		defer mockCtl.Finish()
+		r, mockOAPI, k8s := initTest(mockCtl, vsc, class)
+		expectTaskSearch(mockOAPI)
+		expectSnapshotCompleted(mockOAPI)
+		mockOAPI.EXPECT().CreateSnapshotExportTask(gomock.Any(), gomock.Any()).
+			Return(nil, oapiError(400, "5054", "InvalidResource"))
+		_, err := r.Reconcile(t.Context(), req)
+		require.NoError(t, err)
+
+		class := class.DeepCopy()
+		class.Parameters[controller.ParamExportBucket] = "other-bucket"
+		require.NoError(t, k8s.Update(t.Context(), class))
+		expectTaskSearch(mockOAPI)
+		expectSnapshotCompleted(mockOAPI)
+		mockOAPI.EXPECT().CreateSnapshotExportTask(gomock.Any(), gomock.Any()).
+			Return(&osc.CreateSnapshotExportTaskResponse{SnapshotExportTask: &osc.SnapshotExportTask{
+				TaskId: "snap-export-foo",
+				State:  osc.SnapshotExportTaskStatePending,
+			}}, nil)
+		expectTaskTagging(mockOAPI)
+		_, err = r.Reconcile(t.Context(), req)
+		require.NoError(t, err)
+		var updated snapshotv1.VolumeSnapshotContent
+		require.NoError(t, k8s.Get(t.Context(), req.NamespacedName, &updated))
+		assert.NotContains(t, updated.Annotations, controller.AnnotationExportError)
+	})
+	t.Run("Transient errors are retried", func(t *testing.T) {
+		mockCtl := gomock.NewController(t)
+		defer mockCtl.Finish()
+		r, mockOAPI, _ := initTest(mockCtl, vsc, class)
+		expectTaskSearch(mockOAPI)
+		expectSnapshotCompleted(mockOAPI)
+		mockOAPI.EXPECT().CreateSnapshotExportTask(gomock.Any(), gomock.Any()).
+			Return(nil, oapiError(409, "6031", "InvalidState"))
+		_, err := r.Reconcile(t.Context(), req)
+		require.Error(t, err)
 	})
 	t.Run("Reconciliation continues when the export is not completed", func(t *testing.T) {
 		vsc := vsc.DeepCopy()
@@ -121,7 +735,7 @@ func TestReconcile(t *testing.T) {
 		}
 		mockCtl := gomock.NewController(t)
 		defer mockCtl.Finish()
-		r, mockOAPI := initTest(mockCtl, vsc, class)
+		r, mockOAPI, _ := initTest(mockCtl, vsc, class)
 		mockOAPI.EXPECT().ReadSnapshotExportTasks(gomock.Any(), gomock.Eq(osc.ReadSnapshotExportTasksRequest{
 			Filters: &osc.FiltersSnapshotExportTask{
 				TaskIds: &[]string{"snap-export-foo"},
@@ -142,29 +756,1036 @@ func TestReconcile(t *testing.T) {
 		}
 		mockCtl := gomock.NewController(t)
 		defer mockCtl.Finish()
-		r, mockOAPI := initTest(mockCtl, vsc, class)
+		r, mockOAPI, k8s := initTest(mockCtl, vsc, class)
 		mockOAPI.EXPECT().ReadSnapshotExportTasks(gomock.Any(), gomock.Eq(osc.ReadSnapshotExportTasksRequest{
 			Filters: &osc.FiltersSnapshotExportTask{
 				TaskIds: &[]string{"snap-export-foo"},
 			},
 		})).
 			Return(&osc.ReadSnapshotExportTasksResponse{SnapshotExportTasks: &[]osc.SnapshotExportTask{{
-				OsuExport: osc.OsuExportSnapshotExportTask{},
-				State:     osc.SnapshotExportTaskStateCompleted,
+				TaskId:     "snap-export-foo",
+				SnapshotId: "snap-foo",
+				OsuExport: osc.OsuExportSnapshotExportTask{
+					DiskImageFormat: "raw",
+					OsuBucket:       "bucket",
+					OsuPrefix:       new("vs/"),
+				},
+				State: osc.SnapshotExportTaskStateCompleted,
 			}}}, nil)
 		res, err := r.Reconcile(t.Context(), req)
 		require.NoError(t, err)
 		assert.Zero(t, res.RequeueAfter)
+		var updated snapshotv1.VolumeSnapshotContent
+		require.NoError(t, k8s.Get(t.Context(), req.NamespacedName, &updated))
+		assert.Equal(t, "vs/snap-foo-foo.raw.gz", updated.Annotations[controller.AnnotationExportPath])
+		assert.JSONEq(t, `{"bucket":"bucket","path":"vs/snap-foo-foo.raw.gz","format":"raw","compression":"gzip","snapshotId":"snap-foo","taskId":"snap-export-foo"}`,
+			updated.Annotations[controller.AnnotationExportManifest])
 	})
-	t.Run("Request is requeued when the export has failed", func(t *testing.T) {
+	t.Run("Completed exports are encrypted by a worker job", func(t *testing.T) {
+		class := class.DeepCopy()
+		class.Parameters[controller.ParamExportEncryption] = encryption.Algorithm
+		class.Parameters[controller.ParamExportEncryptionKeySecret] = "export-key"
+		key := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "export-key", Namespace: "kube-system"},
+			Data:       map[string][]byte{controller.EncryptionKeySecretKey: bytes.Repeat([]byte{1}, encryption.KeySize)},
+		}
+		vsc := vsc.DeepCopy()
+		vsc.Annotations = map[string]string{
+			controller.AnnotationExportTask:  "snap-export-foo",
+			controller.AnnotationExportState: string(osc.SnapshotExportTaskStatePending),
+		}
+		mockCtl := gomock.NewController(t)
+		defer mockCtl.Finish()
+		r, mockOAPI, k8s := initTest(mockCtl, vsc, class, key)
+		mockOAPI.EXPECT().ReadSnapshotExportTasks(gomock.Any(), gomock.Any()).
+			Return(&osc.ReadSnapshotExportTasksResponse{SnapshotExportTasks: &[]osc.SnapshotExportTask{{
+				TaskId:     "snap-export-foo",
+				SnapshotId: "snap-foo",
+				OsuExport:  osc.OsuExportSnapshotExportTask{DiskImageFormat: "qcow2", OsuBucket: "bucket"},
+				State:      osc.SnapshotExportTaskStateCompleted,
+			}}}, nil)
+		_, err := r.Reconcile(t.Context(), req)
+		require.NoError(t, err)
+
+		var updated snapshotv1.VolumeSnapshotContent
+		require.NoError(t, k8s.Get(t.Context(), req.NamespacedName, &updated))
+		assert.Equal(t, controller.ExportStateEncrypting, updated.Annotations[controller.AnnotationExportState])
+		var job batchv1.Job
+		require.NoError(t, k8s.Get(t.Context(), types.NamespacedName{Namespace: "kube-system", Name: "encrypt-vsc-uid"}, &job))
+		assert.Equal(t, "exporter:test", job.Spec.Template.Spec.Containers[0].Image)
+		keyID := encryption.KeyID(key.Data[controller.EncryptionKeySecretKey])
+		assert.Equal(t, []string{
+			"worker", "encrypt", "--bucket=bucket", "--key=snap-foo-foo.qcow2.gz", "--key-file=/keys/key", "--key-id=" + keyID,
+		}, job.Spec.Template.Spec.Containers[0].Args)
+		assert.Equal(t, "export-key", job.Spec.Template.Spec.Volumes[0].Secret.SecretName)
+		assert.False(t, *job.Spec.Template.Spec.AutomountServiceAccountToken)
+
+		// the job is running
+		_, err = r.Reconcile(t.Context(), req)
+		require.NoError(t, err)
+		require.NoError(t, k8s.Get(t.Context(), req.NamespacedName, &updated))
+		assert.Equal(t, controller.ExportStateEncrypting, updated.Annotations[controller.AnnotationExportState])
+
+		job.Status.Succeeded = 1
+		require.NoError(t, k8s.Status().Update(t.Context(), &job))
+		res, err := r.Reconcile(t.Context(), req)
+		require.NoError(t, err)
+		assert.Zero(t, res.RequeueAfter)
+		require.NoError(t, k8s.Get(t.Context(), req.NamespacedName, &updated))
+		assert.Equal(t, string(osc.SnapshotExportTaskStateCompleted), updated.Annotations[controller.AnnotationExportState])
+		assert.Equal(t, "snap-foo-foo.qcow2.gz.enc", updated.Annotations[controller.AnnotationExportPath])
+		assert.Contains(t, updated.Annotations[controller.AnnotationExportManifest], `"encryption":"aes-256-gcm","keyId":"`+keyID+`"`)
+	})
+	t.Run("Object lock is applied to completed exports", func(t *testing.T) {
+		class := class.DeepCopy()
+		class.Parameters[controller.ParamExportLockMode] = "compliance"
+		class.Parameters[controller.ParamExportLockRetention] = "720h"
+		class.Parameters[controller.ParamExportLegalHold] = "true"
 		vsc := vsc.DeepCopy()
 		vsc.Annotations = map[string]string{
 			controller.AnnotationExportTask:  "snap-export-foo",
-			controller.AnnotationExportState: string(osc.SnapshotExportTaskStateInitializing),
+			controller.AnnotationExportState: string(osc.SnapshotExportTaskStatePending),
+		}
+		store := objectstoretest.NewMemory()
+		store.EnableObjectLock("bucket")
+		require.NoError(t, store.Put(t.Context(), "bucket", "snap-foo-foo.qcow2.gz", strings.NewReader("foo")))
+		mockCtl := gomock.NewController(t)
+		defer mockCtl.Finish()
+		r, mockOAPI, k8s := initTestWithStore(mockCtl, store, vsc, class)
+		mockOAPI.EXPECT().ReadSnapshotExportTasks(gomock.Any(), gomock.Any()).
+			Return(&osc.ReadSnapshotExportTasksResponse{SnapshotExportTasks: &[]osc.SnapshotExportTask{{
+				TaskId:     "snap-export-foo",
+				SnapshotId: "snap-foo",
+				OsuExport:  osc.OsuExportSnapshotExportTask{DiskImageFormat: "qcow2", OsuBucket: "bucket"},
+				State:      osc.SnapshotExportTaskStateCompleted,
+			}}}, nil)
+		res, err := r.Reconcile(t.Context(), req)
+		require.NoError(t, err)
+		assert.Zero(t, res.RequeueAfter)
+
+		retention, found := store.Retention("bucket", "snap-foo-foo.qcow2.gz")
+		require.True(t, found)
+		assert.Equal(t, objectstore.LockModeCompliance, retention.Mode)
+		assert.WithinDuration(t, time.Now().Add(720*time.Hour), retention.Until, time.Minute)
+		assert.True(t, store.LegalHold("bucket", "snap-foo-foo.qcow2.gz"))
+		var updated snapshotv1.VolumeSnapshotContent
+		require.NoError(t, k8s.Get(t.Context(), req.NamespacedName, &updated))
+		assert.Equal(t, string(osc.SnapshotExportTaskStateCompleted), updated.Annotations[controller.AnnotationExportState])
+		assert.Equal(t, retention.Until.UTC().Format(time.RFC3339), updated.Annotations[controller.AnnotationExportLockedUntil])
+		assert.Equal(t, "true", updated.Annotations[controller.AnnotationExportLegalHold])
+	})
+	t.Run("Object lock failures are reported", func(t *testing.T) {
+		class := class.DeepCopy()
+		class.Parameters[controller.ParamExportLockMode] = "governance"
+		class.Parameters[controller.ParamExportLockRetention] = "24h"
+		vsc := vsc.DeepCopy()
+		vsc.Annotations = map[string]string{
+			controller.AnnotationExportTask:     "snap-export-foo",
+			controller.AnnotationExportState:    controller.ExportStateFinalizing,
+			controller.AnnotationExportManifest: `{"bucket":"bucket","path":"snap-foo-foo.qcow2.gz"}`,
+		}
+		store := objectstoretest.NewMemory()
+		require.NoError(t, store.Put(t.Context(), "bucket", "snap-foo-foo.qcow2.gz", strings.NewReader("foo")))
+		mockCtl := gomock.NewController(t)
+		defer mockCtl.Finish()
+		r, _, k8s := initTestWithStore(mockCtl, store, vsc, class)
+		res, err := r.Reconcile(t.Context(), req)
+		require.NoError(t, err)
+		assert.NotZero(t, res.RequeueAfter)
+		var updated snapshotv1.VolumeSnapshotContent
+		require.NoError(t, k8s.Get(t.Context(), req.NamespacedName, &updated))
+		assert.Equal(t, controller.ExportStateFinalizing, updated.Annotations[controller.AnnotationExportState])
+		assert.Contains(t, updated.Annotations[controller.AnnotationExportLockError], "object lock is not enabled")
+	})
+	t.Run("Tags and metadata are applied to completed exports", func(t *testing.T) {
+		class := class.DeepCopy()
+		class.Parameters[controller.ParamExportTags] = "namespace={{ .Namespace }},pvc={{ .PVC }},team={{ .Labels.team }}"
+		class.Parameters[controller.ParamExportMetadata] = "snapshot={{ .SnapshotID }}"
+		vsc := vsc.DeepCopy()
+		vsc.Annotations = map[string]string{
+			controller.AnnotationExportTask:     "snap-export-foo",
+			controller.AnnotationExportState:    controller.ExportStateFinalizing,
+			controller.AnnotationExportManifest: `{"bucket":"bucket","path":"snap-foo-foo.qcow2.gz"}`,
+		}
+		vs := &snapshotv1.VolumeSnapshot{
+			ObjectMeta: metav1.ObjectMeta{Name: "vs", Namespace: "ns", Labels: map[string]string{"team": "db"}},
+			Spec:       snapshotv1.VolumeSnapshotSpec{Source: snapshotv1.VolumeSnapshotSource{PersistentVolumeClaimName: new("pvc")}},
+		}
+		store := objectstoretest.NewMemory()
+		require.NoError(t, store.Put(t.Context(), "bucket", "snap-foo-foo.qcow2.gz", strings.NewReader("foo")))
+		mockCtl := gomock.NewController(t)
+		defer mockCtl.Finish()
+		r, _, k8s := initTestWithStore(mockCtl, store, vsc, class, vs)
+		res, err := r.Reconcile(t.Context(), req)
+		require.NoError(t, err)
+		assert.Zero(t, res.RequeueAfter)
+		assert.Equal(t, map[string]string{"namespace": "ns", "pvc": "pvc", "team": "db"}, store.Tags("bucket", "snap-foo-foo.qcow2.gz"))
+		assert.Equal(t, map[string]string{"snapshot": "snap-foo"}, store.Metadata("bucket", "snap-foo-foo.qcow2.gz"))
+		var updated snapshotv1.VolumeSnapshotContent
+		require.NoError(t, k8s.Get(t.Context(), req.NamespacedName, &updated))
+		assert.Equal(t, string(osc.SnapshotExportTaskStateCompleted), updated.Annotations[controller.AnnotationExportState])
+		assert.Equal(t, "true", updated.Annotations[controller.AnnotationExportTagged])
+	})
+	t.Run("Exports are copied to each target by worker jobs", func(t *testing.T) {
+		class := class.DeepCopy()
+		class.Parameters[controller.ParamExportCopyTargets] = `[
+			{"name":"dr","endpoint":"https://s3.dr.example.com","bucket":"backups","prefix":"prod/","secret":"dr-creds"},
+			{"name":"cold","endpoint":"https://s3.cold.example.com","region":"eu-west-1","bucket":"archive","secret":"cold-creds"}
+		]`
+		vsc := vsc.DeepCopy()
+		vsc.Annotations = map[string]string{
+			controller.AnnotationExportTask:     "snap-export-foo",
+			controller.AnnotationExportState:    controller.ExportStateFinalizing,
+			controller.AnnotationExportManifest: `{"bucket":"bucket","path":"snap-foo-foo.qcow2.gz"}`,
+		}
+		mockCtl := gomock.NewController(t)
+		defer mockCtl.Finish()
+		r, _, k8s := initTest(mockCtl, vsc, class)
+		res, err := r.Reconcile(t.Context(), req)
+		require.NoError(t, err)
+		assert.NotZero(t, res.RequeueAfter)
+
+		var dr, cold batchv1.Job
+		require.NoError(t, k8s.Get(t.Context(), types.NamespacedName{Namespace: "kube-system", Name: "copy-dr-vsc-uid"}, &dr))
+		require.NoError(t, k8s.Get(t.Context(), types.NamespacedName{Namespace: "kube-system", Name: "copy-cold-vsc-uid"}, &cold))
+		assert.Equal(t, []string{
+			"worker", "copy", "--bucket=bucket", "--key=snap-foo-foo.qcow2.gz", "--target-endpoint=https://s3.dr.example.com",
+			"--target-region=", "--target-bucket=backups", "--target-key=prod/snap-foo-foo.qcow2.gz",
+		}, dr.Spec.Template.Spec.Containers[0].Args)
+		assert.Contains(t, dr.Spec.Template.Spec.Containers[0].Env, corev1.EnvVar{Name: "TARGET_ACCESS_KEY", ValueFrom: &corev1.EnvVarSource{
+			SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "dr-creds"}, Key: "access_key"},
+		}})
+		var updated snapshotv1.VolumeSnapshotContent
+		require.NoError(t, k8s.Get(t.Context(), req.NamespacedName, &updated))
+		assert.Equal(t, controller.ExportStateFinalizing, updated.Annotations[controller.AnnotationExportState])
+		assert.JSONEq(t, `{"dr":{"state":"copying"},"cold":{"state":"copying"}}`, updated.Annotations[controller.AnnotationExportCopies])
+
+		// targets are retried independently
+		dr.Status.Succeeded = 1
+		require.NoError(t, k8s.Status().Update(t.Context(), &dr))
+		cold.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobFailed, Status: corev1.ConditionTrue, Message: "BackoffLimitExceeded"}}
+		require.NoError(t, k8s.Status().Update(t.Context(), &cold))
+		res, err = r.Reconcile(t.Context(), req)
+		require.NoError(t, err)
+		assert.Equal(t, 2*time.Minute, res.RequeueAfter)
+		require.NoError(t, k8s.Get(t.Context(), req.NamespacedName, &updated))
+		assert.JSONEq(t, `{"dr":{"state":"completed"},"cold":{"state":"failed","attempts":1,"error":"BackoffLimitExceeded"}}`,
+			updated.Annotations[controller.AnnotationExportCopies])
+		err = k8s.Get(t.Context(), client.ObjectKeyFromObject(&cold), &batchv1.Job{})
+		assert.True(t, apierrors.IsNotFound(err))
+
+		_, err = r.Reconcile(t.Context(), req)
+		require.NoError(t, err)
+		cold = batchv1.Job{}
+		require.NoError(t, k8s.Get(t.Context(), types.NamespacedName{Namespace: "kube-system", Name: "copy-cold-vsc-uid"}, &cold))
+		cold.Status.Succeeded = 1
+		require.NoError(t, k8s.Status().Update(t.Context(), &cold))
+		res, err = r.Reconcile(t.Context(), req)
+		require.NoError(t, err)
+		assert.Zero(t, res.RequeueAfter)
+		require.NoError(t, k8s.Get(t.Context(), req.NamespacedName, &updated))
+		assert.Equal(t, string(osc.SnapshotExportTaskStateCompleted), updated.Annotations[controller.AnnotationExportState])
+		assert.JSONEq(t, `{"dr":{"state":"completed"},"cold":{"state":"completed","attempts":1}}`, updated.Annotations[controller.AnnotationExportCopies])
+	})
+	t.Run("Copies are given up after repeated failures", func(t *testing.T) {
+		class := class.DeepCopy()
+		class.Parameters[controller.ParamExportCopyTargets] = `[
+			{"name":"cold","endpoint":"https://s3.cold.example.com","bucket":"archive","secret":"cold-creds"}
+		]`
+		vsc := vsc.DeepCopy()
+		vsc.Annotations = map[string]string{
+			controller.AnnotationExportTask:     "snap-export-foo",
+			controller.AnnotationExportState:    controller.ExportStateFinalizing,
+			controller.AnnotationExportManifest: `{"bucket":"bucket","path":"snap-foo-foo.qcow2.gz"}`,
+			controller.AnnotationExportCopies:   `{"cold":{"state":"copying","attempts":2,"error":"BackoffLimitExceeded"}}`,
+		}
+		job := &batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{Name: "copy-cold-vsc-uid", Namespace: "kube-system"},
+			Status: batchv1.JobStatus{Conditions: []batchv1.JobCondition{
+				{Type: batchv1.JobFailed, Status: corev1.ConditionTrue, Message: "BackoffLimitExceeded"},
+			}},
+		}
+		mockCtl := gomock.NewController(t)
+		defer mockCtl.Finish()
+		r, _, k8s := initTest(mockCtl, vsc, class, job)
+		recorder := record.NewFakeRecorder(10)
+		r.SetEventRecorder(recorder)
+		res, err := r.Reconcile(t.Context(), req)
+		require.NoError(t, err)
+		assert.Zero(t, res.RequeueAfter)
+		var updated snapshotv1.VolumeSnapshotContent
+		require.NoError(t, k8s.Get(t.Context(), req.NamespacedName, &updated))
+		assert.Equal(t, string(osc.SnapshotExportTaskStateCompleted), updated.Annotations[controller.AnnotationExportState])
+		assert.JSONEq(t, `{"cold":{"state":"failed","attempts":3,"error":"BackoffLimitExceeded"}}`, updated.Annotations[controller.AnnotationExportCopies])
+		assert.Equal(t, "Warning ExportCopyFailed Copy to target cold has failed after 3 attempts: BackoffLimitExceeded", <-recorder.Events)
+	})
+	t.Run("Exports are pushed to OCI targets as artifacts", func(t *testing.T) {
+		class := class.DeepCopy()
+		class.Parameters[controller.ParamExportCopyTargets] = `[
+			{"name":"registry","type":"oci","endpoint":"https://registry.example.com","repository":"backups/snapshots","secret":"registry-creds"}
+		]`
+		vsc := vsc.DeepCopy()
+		vsc.Annotations = map[string]string{
+			controller.AnnotationExportTask:     "snap-export-foo",
+			controller.AnnotationExportState:    controller.ExportStateFinalizing,
+			controller.AnnotationExportManifest: `{"bucket":"bucket","path":"snap-foo-foo.qcow2.gz","format":"qcow2","compression":"gzip","snapshotId":"snap-foo"}`,
+		}
+		mockCtl := gomock.NewController(t)
+		defer mockCtl.Finish()
+		r, _, k8s := initTest(mockCtl, vsc, class, &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "osc-csi-bsu"}})
+		_, err := r.Reconcile(t.Context(), req)
+		require.NoError(t, err)
+
+		var job batchv1.Job
+		require.NoError(t, k8s.Get(t.Context(), types.NamespacedName{Namespace: "kube-system", Name: "copy-registry-vsc-uid"}, &job))
+		args := job.Spec.Template.Spec.Containers[0].Args
+		require.Len(t, args, 8)
+		assert.Equal(t, []string{
+			"worker", "push", "--bucket=bucket", "--key=snap-foo-foo.qcow2.gz", "--registry=https://registry.example.com",
+			"--repository=backups/snapshots", "--tag=ns-vs-20251103-120000",
+		}, args[:7])
+		config, found := strings.CutPrefix(args[7], "--config=")
+		require.True(t, found)
+		var c oci.Config
+		require.NoError(t, json.Unmarshal([]byte(config), &c))
+		assert.Equal(t, "ns", c.Namespace)
+		assert.Equal(t, "vs", c.VolumeSnapshot)
+		assert.Equal(t, controller.DriverBSU, c.Driver)
+		assert.Equal(t, "snap-foo", c.SnapshotID)
+		assert.Equal(t, "qcow2", c.Format)
+		assert.Contains(t, job.Spec.Template.Spec.Containers[0].Env, corev1.EnvVar{Name: "TARGET_USERNAME", ValueFrom: &corev1.EnvVarSource{
+			SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "registry-creds"}, Key: "username"},
+		}})
+		var updated snapshotv1.VolumeSnapshotContent
+		require.NoError(t, k8s.Get(t.Context(), req.NamespacedName, &updated))
+		assert.JSONEq(t, `{"registry":{"state":"copying","reference":"registry.example.com/backups/snapshots:ns-vs-20251103-120000"}}`,
+			updated.Annotations[controller.AnnotationExportCopies])
+
+		// the reference is kept when the push is retried
+		job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobFailed, Status: corev1.ConditionTrue, Message: "BackoffLimitExceeded"}}
+		require.NoError(t, k8s.Status().Update(t.Context(), &job))
+		_, err = r.Reconcile(t.Context(), req)
+		require.NoError(t, err)
+		require.NoError(t, k8s.Get(t.Context(), req.NamespacedName, &updated))
+		assert.JSONEq(t, `{"registry":{"state":"failed","attempts":1,"error":"BackoffLimitExceeded","reference":"registry.example.com/backups/snapshots:ns-vs-20251103-120000"}}`,
+			updated.Annotations[controller.AnnotationExportCopies])
+	})
+	t.Run("Pushes to OCI targets fail if workers are unable to read the export bucket", func(t *testing.T) {
+		class := class.DeepCopy()
+		class.Parameters[controller.ParamExportCopyTargets] = `[
+			{"name":"registry","type":"oci","endpoint":"https://registry.example.com","repository":"backups/snapshots"}
+		]`
+		vsc := vsc.DeepCopy()
+		vsc.Annotations = map[string]string{
+			controller.AnnotationExportTask:     "snap-export-foo",
+			controller.AnnotationExportState:    controller.ExportStateFinalizing,
+			controller.AnnotationExportManifest: `{"bucket":"bucket","path":"snap-foo-foo.qcow2.gz","format":"qcow2","compression":"gzip","snapshotId":"snap-foo"}`,
+		}
+		mockCtl := gomock.NewController(t)
+		defer mockCtl.Finish()
+		r, _, k8s := initTest(mockCtl, vsc, class)
+		res, err := r.Reconcile(t.Context(), req)
+		require.NoError(t, err)
+		assert.Equal(t, 2*time.Minute, res.RequeueAfter)
+
+		err = k8s.Get(t.Context(), types.NamespacedName{Namespace: "kube-system", Name: "copy-registry-vsc-uid"}, &batchv1.Job{})
+		assert.True(t, apierrors.IsNotFound(err))
+		var updated snapshotv1.VolumeSnapshotContent
+		require.NoError(t, k8s.Get(t.Context(), req.NamespacedName, &updated))
+		assert.JSONEq(t, `{"registry":{"state":"failed","attempts":1,
+			"error":"artifacts are pushed from the export bucket, and the OOS credentials secret kube-system/osc-csi-bsu is not found"}}`,
+			updated.Annotations[controller.AnnotationExportCopies])
+	})
+	t.Run("Running exports are cancelled on request", func(t *testing.T) {
+		vsc := vsc.DeepCopy()
+		vsc.Annotations = map[string]string{
+			controller.AnnotationExportTask:  "snap-export-foo",
+			controller.AnnotationExportState: controller.ExportStateEncrypting,
+		}
+		vsc.Annotations[controller.AnnotationExportAction] = controller.ActionCancel
+		job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{
+			Namespace: "kube-system",
+			Name:      "encrypt-vsc-uid",
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "snapshot.storage.k8s.io/v1", Kind: "VolumeSnapshotContent", Name: "vsc", UID: "vsc-uid", Controller: new(true),
+			}},
+		}}
+		mockCtl := gomock.NewController(t)
+		defer mockCtl.Finish()
+		r, _, k8s := initTest(mockCtl, vsc, class, job)
+		recorder := record.NewFakeRecorder(10)
+		r.SetEventRecorder(recorder)
+		res, err := r.Reconcile(t.Context(), req)
+		require.NoError(t, err)
+		assert.Zero(t, res)
+		var updated snapshotv1.VolumeSnapshotContent
+		require.NoError(t, k8s.Get(t.Context(), req.NamespacedName, &updated))
+		assert.Equal(t, string(osc.SnapshotExportTaskStateCancelled), updated.Annotations[controller.AnnotationExportState])
+		assert.NotContains(t, updated.Annotations, controller.AnnotationExportAction)
+		err = k8s.Get(t.Context(), client.ObjectKeyFromObject(job), &batchv1.Job{})
+		assert.True(t, apierrors.IsNotFound(err))
+		assert.Regexp(t, `^Normal ExportActionDone Export action "cancel" requested by field manager \S+ at \S+ was done$`, <-recorder.Events)
+
+		// cancelled exports are terminal
+		res, err = r.Reconcile(t.Context(), req)
+		require.NoError(t, err)
+		assert.Zero(t, res)
+	})
+	t.Run("Failed exports are retried on request, even after a permanent error", func(t *testing.T) {
+		vsc := vsc.DeepCopy()
+		vsc.Annotations = map[string]string{
+			controller.AnnotationExportState:       string(osc.SnapshotExportTaskStateFailed),
+			controller.AnnotationExportError:       "unable to create task: bucket not found",
+			controller.AnnotationExportErrorConfig: "0123456789abcdef",
+		}
+		vsc.Annotations[controller.AnnotationExportAction] = controller.ActionRetry
+		mockCtl := gomock.NewController(t)
+		defer mockCtl.Finish()
+		r, mockOAPI, k8s := initTest(mockCtl, vsc, class)
+		recorder := record.NewFakeRecorder(10)
+		r.SetEventRecorder(recorder)
+		expectTaskSearch(mockOAPI)
+		expectSnapshotCompleted(mockOAPI)
+		mockOAPI.EXPECT().CreateSnapshotExportTask(gomock.Any(), gomock.Any()).
+			Return(&osc.CreateSnapshotExportTaskResponse{SnapshotExportTask: &osc.SnapshotExportTask{
+				TaskId: "snap-export-foo",
+				State:  osc.SnapshotExportTaskStatePending,
+			}}, nil)
+		expectTaskTagging(mockOAPI)
+		_, err := r.Reconcile(t.Context(), req)
+		require.NoError(t, err)
+		var updated snapshotv1.VolumeSnapshotContent
+		require.NoError(t, k8s.Get(t.Context(), req.NamespacedName, &updated))
+		assert.Equal(t, string(osc.SnapshotExportTaskStatePending), updated.Annotations[controller.AnnotationExportState])
+		assert.NotContains(t, updated.Annotations, controller.AnnotationExportError)
+		assert.NotContains(t, updated.Annotations, controller.AnnotationExportAction)
+		assert.Regexp(t, `^Normal ExportActionDone Export action "retry" requested by field manager \S+ at \S+ was done$`, <-recorder.Events)
+	})
+	t.Run("Completed exports are exported again on request", func(t *testing.T) {
+		vsc := vsc.DeepCopy()
+		vsc.Annotations = map[string]string{
+			controller.AnnotationExportTask:        "snap-export-foo",
+			controller.AnnotationExportState:       string(osc.SnapshotExportTaskStateCompleted),
+			controller.AnnotationExportPath:        "vs/snap-foo-foo.qcow2.gz",
+			controller.AnnotationExportManifest:    `{"bucket":"bucket","path":"vs/snap-foo-foo.qcow2.gz"}`,
+			controller.AnnotationExportCompletedAt: "2025-11-03T12:00:00Z",
+		}
+		vsc.Annotations[controller.AnnotationExportAction] = controller.ActionReexport
+		mockCtl := gomock.NewController(t)
+		defer mockCtl.Finish()
+		r, mockOAPI, k8s := initTest(mockCtl, vsc, class)
+		// the task of the previous export is not adopted
+		expectTaskSearch(mockOAPI, osc.SnapshotExportTask{
+			TaskId: "snap-export-foo",
+			State:  osc.SnapshotExportTaskStateCompleted,
+			Tags:   []osc.ResourceTag{{Key: controller.TagContentUID, Value: "vsc-uid"}, {Key: controller.TagClusterID}},
+		})
+		expectSnapshotCompleted(mockOAPI)
+		mockOAPI.EXPECT().CreateSnapshotExportTask(gomock.Any(), gomock.Any()).
+			Return(&osc.CreateSnapshotExportTaskResponse{SnapshotExportTask: &osc.SnapshotExportTask{
+				TaskId: "snap-export-bar",
+				State:  osc.SnapshotExportTaskStatePending,
+			}}, nil)
+		mockOAPI.EXPECT().CreateTags(gomock.Any(), gomock.Eq(osc.CreateTagsRequest{
+			ResourceIds: []string{"snap-export-bar"},
+			Tags: []osc.ResourceTag{
+				{Key: controller.TagContentUID, Value: "vsc-uid"}, {Key: controller.TagClusterID}, {Key: controller.TagExportGeneration, Value: "1"},
+			},
+		})).Return(&osc.CreateTagsResponse{}, nil)
+		_, err := r.Reconcile(t.Context(), req)
+		require.NoError(t, err)
+		var updated snapshotv1.VolumeSnapshotContent
+		require.NoError(t, k8s.Get(t.Context(), req.NamespacedName, &updated))
+		assert.Equal(t, "snap-export-bar", updated.Annotations[controller.AnnotationExportTask])
+		assert.Equal(t, string(osc.SnapshotExportTaskStatePending), updated.Annotations[controller.AnnotationExportState])
+		assert.Equal(t, "1", updated.Annotations[controller.AnnotationExportGeneration])
+		assert.NotContains(t, updated.Annotations, controller.AnnotationExportPath)
+		assert.NotContains(t, updated.Annotations, controller.AnnotationExportCompletedAt)
+	})
+	t.Run("Actions that do not apply to the export are rejected", func(t *testing.T) {
+		vsc := vsc.DeepCopy()
+		vsc.Annotations = map[string]string{controller.AnnotationExportState: string(osc.SnapshotExportTaskStateCompleted)}
+		vsc.Annotations[controller.AnnotationExportAction] = controller.ActionCancel
+		mockCtl := gomock.NewController(t)
+		defer mockCtl.Finish()
+		r, _, k8s := initTest(mockCtl, vsc, class)
+		recorder := record.NewFakeRecorder(10)
+		r.SetEventRecorder(recorder)
+		_, err := r.Reconcile(t.Context(), req)
+		require.NoError(t, err)
+		var updated snapshotv1.VolumeSnapshotContent
+		require.NoError(t, k8s.Get(t.Context(), req.NamespacedName, &updated))
+		assert.Equal(t, string(osc.SnapshotExportTaskStateCompleted), updated.Annotations[controller.AnnotationExportState])
+		assert.NotContains(t, updated.Annotations, controller.AnnotationExportAction)
+		assert.Regexp(t, `^Warning ExportActionRejected Export action "cancel" requested by field manager \S+ at \S+ was rejected: `+
+			`export is completed, only running or failing exports can be cancelled$`, <-recorder.Events)
+	})
+	t.Run("Incremental exports are replaced by diffs against the previous export", func(t *testing.T) {
+		class := class.DeepCopy()
+		class.Parameters[controller.ParamExportFormat] = "raw"
+		class.Parameters[controller.ParamExportIncremental] = "true"
+		now := time.Now()
+		previous := sampled("vsc-previous", now.Add(-time.Hour), map[string]string{
+			controller.AnnotationExportTask:     "snap-export-bar",
+			controller.AnnotationExportState:    string(osc.SnapshotExportTaskStateCompleted),
+			controller.AnnotationExportManifest: `{"bucket":"bucket","path":"snap-bar-bar.diff.gz","kind":"incremental","chain":["snap-baz-baz.raw.gz"]}`,
+		})
+		vsc := sampled("vsc", now, map[string]string{
+			controller.AnnotationExportTask:  "snap-export-foo",
+			controller.AnnotationExportState: string(osc.SnapshotExportTaskStatePending),
+		})
+		store := objectstoretest.NewMemory()
+		mockCtl := gomock.NewController(t)
+		defer mockCtl.Finish()
+		r, mockOAPI, k8s := initTestWithStore(mockCtl, store, vsc, class, previous)
+		mockOAPI.EXPECT().ReadSnapshotExportTasks(gomock.Any(), gomock.Any()).
+			Return(&osc.ReadSnapshotExportTasksResponse{SnapshotExportTasks: &[]osc.SnapshotExportTask{{
+				TaskId:     "snap-export-foo",
+				SnapshotId: "snap-foo",
+				OsuExport:  osc.OsuExportSnapshotExportTask{DiskImageFormat: "raw", OsuBucket: "bucket"},
+				State:      osc.SnapshotExportTaskStateCompleted,
+			}}}, nil)
+		_, err := r.Reconcile(t.Context(), req)
+		require.NoError(t, err)
+
+		var updated snapshotv1.VolumeSnapshotContent
+		require.NoError(t, k8s.Get(t.Context(), req.NamespacedName, &updated))
+		assert.Equal(t, controller.ExportStateDiffing, updated.Annotations[controller.AnnotationExportState])
+		var job batchv1.Job
+		require.NoError(t, k8s.Get(t.Context(), types.NamespacedName{Namespace: "kube-system", Name: "diff-vsc-uid"}, &job))
+		assert.Equal(t, []string{
+			"worker", "diff", "--bucket=bucket", "--key=snap-foo-foo.raw.gz",
+			"--parent=snap-baz-baz.raw.gz", "--parent=snap-bar-bar.diff.gz", "--output=snap-foo-foo.diff.gz",
+		}, job.Spec.Template.Spec.Containers[0].Args)
+
+		job.Status.Succeeded = 1
+		require.NoError(t, k8s.Status().Update(t.Context(), &job))
+		res, err := r.Reconcile(t.Context(), req)
+		require.NoError(t, err)
+		assert.Zero(t, res.RequeueAfter)
+		require.NoError(t, k8s.Get(t.Context(), req.NamespacedName, &updated))
+		assert.Equal(t, string(osc.SnapshotExportTaskStateCompleted), updated.Annotations[controller.AnnotationExportState])
+		assert.Equal(t, "snap-foo-foo.diff.gz", updated.Annotations[controller.AnnotationExportPath])
+		assert.Contains(t, updated.Annotations[controller.AnnotationExportManifest],
+			`"kind":"incremental","parent":"snap-bar-bar.diff.gz","chain":["snap-baz-baz.raw.gz","snap-bar-bar.diff.gz"]`)
+		found, err := store.Exists(t.Context(), "bucket", "snap-foo-foo.diff.gz"+blockdiff.ManifestSuffix)
+		require.NoError(t, err)
+		assert.True(t, found)
+	})
+	t.Run("Full images are kept when diffs keep failing", func(t *testing.T) {
+		class := class.DeepCopy()
+		class.Parameters[controller.ParamExportFormat] = "raw"
+		class.Parameters[controller.ParamExportIncremental] = "true"
+		vsc := vsc.DeepCopy()
+		vsc.Annotations = map[string]string{
+			controller.AnnotationExportTask:        "snap-export-foo",
+			controller.AnnotationExportState:       controller.ExportStateDiffing,
+			controller.AnnotationExportJobFailures: "1",
+			controller.AnnotationExportManifest: `{"bucket":"bucket","path":"snap-foo-foo.raw.gz","kind":"incremental",` +
+				`"parent":"snap-bar-bar.diff.gz","chain":["snap-baz-baz.raw.gz","snap-bar-bar.diff.gz"]}`,
+		}
+		failed := func() *batchv1.Job {
+			return &batchv1.Job{
+				ObjectMeta: metav1.ObjectMeta{Name: "diff-vsc-uid", Namespace: "kube-system"},
+				Status: batchv1.JobStatus{Conditions: []batchv1.JobCondition{
+					{Type: batchv1.JobFailed, Status: corev1.ConditionTrue, Message: "BackoffLimitExceeded"},
+				}},
+			}
+		}
+		store := objectstoretest.NewMemory()
+		require.NoError(t, store.Put(t.Context(), "bucket", "snap-foo-foo.raw.gz", strings.NewReader("foo")))
+		mockCtl := gomock.NewController(t)
+		defer mockCtl.Finish()
+		r, _, k8s := initTestWithStore(mockCtl, store, vsc, class, failed())
+		res, err := r.Reconcile(t.Context(), req)
+		require.NoError(t, err)
+		assert.NotZero(t, res.RequeueAfter)
+		var updated snapshotv1.VolumeSnapshotContent
+		require.NoError(t, k8s.Get(t.Context(), req.NamespacedName, &updated))
+		assert.Equal(t, "2", updated.Annotations[controller.AnnotationExportJobFailures])
+
+		require.NoError(t, k8s.Create(t.Context(), failed()))
+		_, err = r.Reconcile(t.Context(), req)
+		require.NoError(t, err)
+		require.NoError(t, k8s.Get(t.Context(), req.NamespacedName, &updated))
+		assert.Equal(t, string(osc.SnapshotExportTaskStateCompleted), updated.Annotations[controller.AnnotationExportState])
+		assert.Equal(t, "snap-foo-foo.raw.gz", updated.Annotations[controller.AnnotationExportPath])
+		assert.Contains(t, updated.Annotations[controller.AnnotationExportManifest], `"kind":"full"`)
+		assert.NotContains(t, updated.Annotations[controller.AnnotationExportManifest], `"parent"`)
+		assert.NotContains(t, updated.Annotations, controller.AnnotationExportJobFailures)
+	})
+	t.Run("A full export starts a new chain", func(t *testing.T) {
+		class := class.DeepCopy()
+		class.Parameters[controller.ParamExportFormat] = "raw"
+		class.Parameters[controller.ParamExportIncremental] = "true"
+		class.Parameters[controller.ParamExportFullEvery] = "2"
+		now := time.Now()
+		previous := sampled("vsc-previous", now.Add(-time.Hour), map[string]string{
+			controller.AnnotationExportTask:     "snap-export-bar",
+			controller.AnnotationExportState:    string(osc.SnapshotExportTaskStateCompleted),
+			controller.AnnotationExportManifest: `{"bucket":"bucket","path":"snap-bar-bar.diff.gz","kind":"incremental","chain":["snap-baz-baz.raw.gz"]}`,
+		})
+		vsc := sampled("vsc", now, map[string]string{
+			controller.AnnotationExportTask:  "snap-export-foo",
+			controller.AnnotationExportState: string(osc.SnapshotExportTaskStatePending),
+		})
+		store := objectstoretest.NewMemory()
+		mockCtl := gomock.NewController(t)
+		defer mockCtl.Finish()
+		r, mockOAPI, k8s := initTestWithStore(mockCtl, store, vsc, class, previous)
+		mockOAPI.EXPECT().ReadSnapshotExportTasks(gomock.Any(), gomock.Any()).
+			Return(&osc.ReadSnapshotExportTasksResponse{SnapshotExportTasks: &[]osc.SnapshotExportTask{{
+				TaskId:     "snap-export-foo",
+				SnapshotId: "snap-foo",
+				OsuExport:  osc.OsuExportSnapshotExportTask{DiskImageFormat: "raw", OsuBucket: "bucket"},
+				State:      osc.SnapshotExportTaskStateCompleted,
+			}}}, nil)
+		_, err := r.Reconcile(t.Context(), req)
+		require.NoError(t, err)
+		var updated snapshotv1.VolumeSnapshotContent
+		require.NoError(t, k8s.Get(t.Context(), req.NamespacedName, &updated))
+		assert.Equal(t, string(osc.SnapshotExportTaskStateCompleted), updated.Annotations[controller.AnnotationExportState])
+		assert.Contains(t, updated.Annotations[controller.AnnotationExportManifest], `"kind":"full"`)
+		found, err := store.Exists(t.Context(), "bucket", "snap-foo-foo.raw.gz"+blockdiff.ManifestSuffix)
+		require.NoError(t, err)
+		assert.True(t, found)
+	})
+	t.Run("Archived snapshots are deleted after the delay", func(t *testing.T) {
+		class := class.DeepCopy()
+		class.Parameters[controller.ParamExportThenDeleteSnapshot] = "afterDays=30"
+		vsc := vsc.DeepCopy()
+		vsc.Annotations = map[string]string{
+			controller.AnnotationExportTask:     "snap-export-foo",
+			controller.AnnotationExportState:    controller.ExportStateFinalizing,
+			controller.AnnotationExportManifest: `{"bucket":"bucket","path":"snap-foo-foo.qcow2.gz","snapshotId":"snap-foo"}`,
+		}
+		store := objectstoretest.NewMemory()
+		require.NoError(t, store.Put(t.Context(), "bucket", "snap-foo-foo.qcow2.gz", strings.NewReader("foo")))
+		mockCtl := gomock.NewController(t)
+		defer mockCtl.Finish()
+		r, mockOAPI, k8s := initTestWithStore(mockCtl, store, vsc, class)
+		res, err := r.Reconcile(t.Context(), req)
+		require.NoError(t, err)
+		assert.Equal(t, 30*24*time.Hour, res.RequeueAfter)
+
+		// the delay has not elapsed
+		res, err = r.Reconcile(t.Context(), req)
+		require.NoError(t, err)
+		assert.Greater(t, res.RequeueAfter, 29*24*time.Hour)
+
+		var updated snapshotv1.VolumeSnapshotContent
+		require.NoError(t, k8s.Get(t.Context(), req.NamespacedName, &updated))
+		assert.Equal(t, string(osc.SnapshotExportTaskStateCompleted), updated.Annotations[controller.AnnotationExportState])
+		updated.Annotations[controller.AnnotationExportCompletedAt] = time.Now().Add(-31 * 24 * time.Hour).UTC().Format(time.RFC3339)
+		require.NoError(t, k8s.Update(t.Context(), &updated))
+		mockOAPI.EXPECT().DeleteSnapshot(gomock.Any(), gomock.Eq(osc.DeleteSnapshotRequest{SnapshotId: "snap-foo"})).
+			Return(&osc.DeleteSnapshotResponse{}, nil)
+		res, err = r.Reconcile(t.Context(), req)
+		require.NoError(t, err)
+		assert.Zero(t, res.RequeueAfter)
+		require.NoError(t, k8s.Get(t.Context(), req.NamespacedName, &updated))
+		assert.NotEmpty(t, updated.Annotations[controller.AnnotationExportSnapshotDeleted])
+		found, err := store.Exists(t.Context(), "bucket", "snap-foo-foo.qcow2.gz"+blockdiff.ManifestSuffix)
+		require.NoError(t, err)
+		assert.True(t, found)
+
+		// archived snapshots are left alone
+		_, err = r.Reconcile(t.Context(), req)
+		require.NoError(t, err)
+	})
+	t.Run("Snapshots are not archived if the exported file is missing", func(t *testing.T) {
+		class := class.DeepCopy()
+		class.Parameters[controller.ParamExportThenDeleteSnapshot] = "afterDays=0"
+		vsc := vsc.DeepCopy()
+		vsc.Annotations = map[string]string{
+			controller.AnnotationExportTask:        "snap-export-foo",
+			controller.AnnotationExportState:       string(osc.SnapshotExportTaskStateCompleted),
+			controller.AnnotationExportManifest:    `{"bucket":"bucket","path":"snap-foo-foo.qcow2.gz","snapshotId":"snap-foo"}`,
+			controller.AnnotationExportCompletedAt: "2025-11-03T12:00:00Z",
+		}
+		mockCtl := gomock.NewController(t)
+		defer mockCtl.Finish()
+		r, _, k8s := initTestWithStore(mockCtl, objectstoretest.NewMemory(), vsc, class)
+		res, err := r.Reconcile(t.Context(), req)
+		require.NoError(t, err)
+		assert.NotZero(t, res.RequeueAfter)
+		var updated snapshotv1.VolumeSnapshotContent
+		require.NoError(t, k8s.Get(t.Context(), req.NamespacedName, &updated))
+		assert.Contains(t, updated.Annotations[controller.AnnotationExportArchiveError], "exported file not found")
+		assert.NotContains(t, updated.Annotations, controller.AnnotationExportSnapshotDeleted)
+	})
+	t.Run("Snapshots are protected until their export is completed", func(t *testing.T) {
+		class := class.DeepCopy()
+		class.Parameters[controller.ParamExportBeforeDelete] = "true"
+		vsc := vsc.DeepCopy()
+		vsc.Spec.DeletionPolicy = snapshotv1.VolumeSnapshotContentDelete
+		vsc.Annotations = map[string]string{
+			controller.AnnotationExportTask:  "snap-export-foo",
+			controller.AnnotationExportState: string(osc.SnapshotExportTaskStatePending),
+		}
+		mockCtl := gomock.NewController(t)
+		defer mockCtl.Finish()
+		r, mockOAPI, k8s := initTest(mockCtl, vsc, class)
+		mockOAPI.EXPECT().ReadSnapshotExportTasks(gomock.Any(), gomock.Any()).
+			Return(&osc.ReadSnapshotExportTasksResponse{SnapshotExportTasks: &[]osc.SnapshotExportTask{{
+				TaskId:     "snap-export-foo",
+				SnapshotId: "snap-foo",
+				State:      osc.SnapshotExportTaskStateUploading,
+			}}}, nil)
+		_, err := r.Reconcile(t.Context(), req)
+		require.NoError(t, err)
+		var updated snapshotv1.VolumeSnapshotContent
+		require.NoError(t, k8s.Get(t.Context(), req.NamespacedName, &updated))
+		assert.Contains(t, updated.Finalizers, controller.FinalizerExportProtection)
+		assert.Equal(t, snapshotv1.VolumeSnapshotContentRetain, updated.Spec.DeletionPolicy)
+		assert.Equal(t, "Delete", updated.Annotations[controller.AnnotationExportDeletionPolicy])
+
+		// the protection is released once the export is completed
+		updated.Annotations[controller.AnnotationExportState] = string(osc.SnapshotExportTaskStateCompleted)
+		require.NoError(t, k8s.Update(t.Context(), &updated))
+		_, err = r.Reconcile(t.Context(), req)
+		require.NoError(t, err)
+		require.NoError(t, k8s.Get(t.Context(), req.NamespacedName, &updated))
+		assert.NotContains(t, updated.Finalizers, controller.FinalizerExportProtection)
+		assert.Equal(t, snapshotv1.VolumeSnapshotContentDelete, updated.Spec.DeletionPolicy)
+		assert.NotContains(t, updated.Annotations, controller.AnnotationExportDeletionPolicy)
+	})
+	t.Run("Snapshots are not protected if their export does not start", func(t *testing.T) {
+		class := class.DeepCopy()
+		class.Parameters[controller.ParamExportBeforeDelete] = "true"
+		class.Parameters[controller.ParamExportFormat] = "vmdk"
+		vsc := vsc.DeepCopy()
+		vsc.Spec.DeletionPolicy = snapshotv1.VolumeSnapshotContentDelete
+		mockCtl := gomock.NewController(t)
+		defer mockCtl.Finish()
+		r, _, k8s := initTest(mockCtl, vsc, class)
+		_, err := r.Reconcile(t.Context(), req)
+		require.NoError(t, err)
+		var updated snapshotv1.VolumeSnapshotContent
+		require.NoError(t, k8s.Get(t.Context(), req.NamespacedName, &updated))
+		assert.NotContains(t, updated.Finalizers, controller.FinalizerExportProtection)
+		assert.Equal(t, snapshotv1.VolumeSnapshotContentDelete, updated.Spec.DeletionPolicy)
+	})
+	deleted := func(annotations map[string]string) *snapshotv1.VolumeSnapshotContent {
+		vsc := vsc.DeepCopy()
+		vsc.Spec.DeletionPolicy = snapshotv1.VolumeSnapshotContentRetain
+		vsc.Finalizers = []string{controller.FinalizerExportProtection}
+		vsc.DeletionTimestamp = new(metav1.Now())
+		vsc.Annotations = annotations
+		return vsc
+	}
+	t.Run("Exports of protected snapshots go on while they are deleted", func(t *testing.T) {
+		class := class.DeepCopy()
+		class.Parameters[controller.ParamExportBeforeDelete] = "true"
+		vsc := deleted(map[string]string{
+			controller.AnnotationExportTask:           "snap-export-foo",
+			controller.AnnotationExportState:          string(osc.SnapshotExportTaskStatePending),
+			controller.AnnotationExportDeletionPolicy: "Delete",
+		})
+		mockCtl := gomock.NewController(t)
+		defer mockCtl.Finish()
+		r, mockOAPI, k8s := initTest(mockCtl, vsc, class)
+		mockOAPI.EXPECT().ReadSnapshotExportTasks(gomock.Any(), gomock.Any()).
+			Return(&osc.ReadSnapshotExportTasksResponse{SnapshotExportTasks: &[]osc.SnapshotExportTask{{
+				TaskId:     "snap-export-foo",
+				SnapshotId: "snap-foo",
+				State:      osc.SnapshotExportTaskStateUploading,
+			}}}, nil)
+		_, err := r.Reconcile(t.Context(), req)
+		require.NoError(t, err)
+		var updated snapshotv1.VolumeSnapshotContent
+		require.NoError(t, k8s.Get(t.Context(), req.NamespacedName, &updated))
+		assert.Contains(t, updated.Finalizers, controller.FinalizerExportProtection)
+		assert.Equal(t, string(osc.SnapshotExportTaskStateUploading), updated.Annotations[controller.AnnotationExportState])
+	})
+	t.Run("Deleted snapshots are released once exported", func(t *testing.T) {
+		class := class.DeepCopy()
+		class.Parameters[controller.ParamExportBeforeDelete] = "true"
+		vsc := deleted(map[string]string{
+			controller.AnnotationExportTask:           "snap-export-foo",
+			controller.AnnotationExportState:          string(osc.SnapshotExportTaskStateCompleted),
+			controller.AnnotationExportDeletionPolicy: "Delete",
+		})
+		mockCtl := gomock.NewController(t)
+		defer mockCtl.Finish()
+		r, mockOAPI, k8s := initTest(mockCtl, vsc, class)
+		mockOAPI.EXPECT().DeleteSnapshot(gomock.Any(), gomock.Eq(osc.DeleteSnapshotRequest{SnapshotId: "snap-foo"})).
+			Return(&osc.DeleteSnapshotResponse{}, nil)
+		_, err := r.Reconcile(t.Context(), req)
+		require.NoError(t, err)
+		err = k8s.Get(t.Context(), req.NamespacedName, &snapshotv1.VolumeSnapshotContent{})
+		assert.True(t, apierrors.IsNotFound(err))
+	})
+	t.Run("Deleted snapshots are released on request", func(t *testing.T) {
+		class := class.DeepCopy()
+		class.Parameters[controller.ParamExportBeforeDelete] = "true"
+		vsc := deleted(map[string]string{
+			controller.AnnotationExportTask:    "snap-export-foo",
+			controller.AnnotationExportState:   string(osc.SnapshotExportTaskStateFailed),
+			controller.AnnotationExportRelease: "true",
+		})
+		mockCtl := gomock.NewController(t)
+		defer mockCtl.Finish()
+		r, _, k8s := initTest(mockCtl, vsc, class)
+		_, err := r.Reconcile(t.Context(), req)
+		require.NoError(t, err)
+		err = k8s.Get(t.Context(), req.NamespacedName, &snapshotv1.VolumeSnapshotContent{})
+		assert.True(t, apierrors.IsNotFound(err))
+	})
+	t.Run("The deletion policy of snapshots exported by the data mover is kept", func(t *testing.T) {
+		vsc := vsc.DeepCopy()
+		vsc.Spec.Driver = "hostpath.csi.k8s.io"
+		vsc.Spec.DeletionPolicy = snapshotv1.VolumeSnapshotContentDelete
+		vsc.Status.RestoreSize = new(int64(10 << 30))
+		class := class.DeepCopy()
+		class.Parameters[controller.ParamExportBeforeDelete] = "true"
+		class.Parameters[controller.ParamExportFormat] = "raw"
+		class.Parameters[controller.ParamExportCompression] = "none"
+		class.Parameters[controller.ParamExportStorageClass] = "hostpath"
+		mockCtl := gomock.NewController(t)
+		defer mockCtl.Finish()
+		r, _, k8s := initTest(mockCtl, vsc, class)
+		_, err := r.Reconcile(t.Context(), req)
+		require.NoError(t, err)
+		var updated snapshotv1.VolumeSnapshotContent
+		require.NoError(t, k8s.Get(t.Context(), req.NamespacedName, &updated))
+		assert.Contains(t, updated.Finalizers, controller.FinalizerExportProtection)
+		assert.Equal(t, snapshotv1.VolumeSnapshotContentDelete, updated.Spec.DeletionPolicy)
+		assert.NotContains(t, updated.Annotations, controller.AnnotationExportDeletionPolicy)
+	})
+	t.Run("Snapshots of other drivers are exported by the data mover", func(t *testing.T) {
+		vsc := vsc.DeepCopy()
+		vsc.Spec.Driver = "hostpath.csi.k8s.io"
+		vsc.Status.RestoreSize = new(int64(10 << 30))
+		class := class.DeepCopy()
+		class.Parameters[controller.ParamExportFormat] = "raw"
+		class.Parameters[controller.ParamExportCompression] = "none"
+		class.Parameters[controller.ParamExportStorageClass] = "hostpath"
+		mockCtl := gomock.NewController(t)
+		defer mockCtl.Finish()
+		r, _, k8s := initTest(mockCtl, vsc, class)
+		_, err := r.Reconcile(t.Context(), req)
+		require.NoError(t, err)
+
+		var content snapshotv1.VolumeSnapshotContent
+		require.NoError(t, k8s.Get(t.Context(), types.NamespacedName{Name: "export-vsc-uid"}, &content))
+		assert.Equal(t, "snap-foo", *content.Spec.Source.SnapshotHandle)
+		assert.Equal(t, snapshotv1.VolumeSnapshotContentRetain, content.Spec.DeletionPolicy)
+		var snapshot snapshotv1.VolumeSnapshot
+		require.NoError(t, k8s.Get(t.Context(), types.NamespacedName{Namespace: "kube-system", Name: "export-vsc-uid"}, &snapshot))
+		var pvc corev1.PersistentVolumeClaim
+		require.NoError(t, k8s.Get(t.Context(), types.NamespacedName{Namespace: "kube-system", Name: "export-vsc-uid"}, &pvc))
+		assert.Equal(t, "hostpath", *pvc.Spec.StorageClassName)
+		assert.Equal(t, corev1.PersistentVolumeBlock, *pvc.Spec.VolumeMode)
+		var job batchv1.Job
+		require.NoError(t, k8s.Get(t.Context(), types.NamespacedName{Namespace: "kube-system", Name: "datamove-vsc-uid"}, &job))
+		assert.Equal(t, []string{
+			"worker", "datamove", "--bucket=bucket", "--key=/vs/ns/2025-11-03vsc.raw", "--format=raw", "--compression=none", "--device=/dev/snapshot",
+		}, job.Spec.Template.Spec.Containers[0].Args)
+
+		var updated snapshotv1.VolumeSnapshotContent
+		require.NoError(t, k8s.Get(t.Context(), req.NamespacedName, &updated))
+		assert.Equal(t, controller.ExportStateMoving, updated.Annotations[controller.AnnotationExportState])
+		assert.Equal(t, "datamove-vsc-uid-0", updated.Annotations[controller.AnnotationExportTask])
+	})
+	t.Run("The data mover uses the storage class of the driver of the snapshot", func(t *testing.T) {
+		vsc := vsc.DeepCopy()
+		vsc.Spec.Driver = "hostpath.csi.k8s.io"
+		vsc.Status.RestoreSize = new(int64(10 << 30))
+		storageClass := func(name, provisioner string, isDefault bool) *storagev1.StorageClass {
+			sc := &storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: name}, Provisioner: provisioner}
+			if isDefault {
+				sc.Annotations = map[string]string{"storageclass.kubernetes.io/is-default-class": "true"}
+			}
+			return sc
+		}
+		mockCtl := gomock.NewController(t)
+		defer mockCtl.Finish()
+		r, _, k8s := initTest(mockCtl, vsc, class,
+			storageClass("bsu", controller.DriverBSU, true), storageClass("hostpath", "hostpath.csi.k8s.io", false))
+		_, err := r.Reconcile(t.Context(), req)
+		require.NoError(t, err)
+		var pvc corev1.PersistentVolumeClaim
+		require.NoError(t, k8s.Get(t.Context(), types.NamespacedName{Namespace: "kube-system", Name: "export-vsc-uid"}, &pvc))
+		assert.Equal(t, "hostpath", *pvc.Spec.StorageClassName)
+	})
+	t.Run("The data mover fails if the storage class of the driver cannot be chosen", func(t *testing.T) {
+		vsc := vsc.DeepCopy()
+		vsc.Spec.Driver = "hostpath.csi.k8s.io"
+		vsc.Status.RestoreSize = new(int64(10 << 30))
+		mockCtl := gomock.NewController(t)
+		defer mockCtl.Finish()
+		r, _, k8s := initTest(mockCtl, vsc, class,
+			&storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "bsu"}, Provisioner: controller.DriverBSU})
+		_, err := r.Reconcile(t.Context(), req)
+		require.NoError(t, err)
+		err = k8s.Get(t.Context(), types.NamespacedName{Namespace: "kube-system", Name: "export-vsc-uid"}, &corev1.PersistentVolumeClaim{})
+		assert.True(t, apierrors.IsNotFound(err))
+		var updated snapshotv1.VolumeSnapshotContent
+		require.NoError(t, k8s.Get(t.Context(), req.NamespacedName, &updated))
+		assert.Equal(t, string(osc.SnapshotExportTaskStateFailed), updated.Annotations[controller.AnnotationExportState])
+		assert.Equal(t, "unable to choose a storage class: driver hostpath.csi.k8s.io has no storage class, exportStorageClass is required",
+			updated.Annotations[controller.AnnotationExportError])
+	})
+	t.Run("The data mover uses the capacity of the source claim of snapshots having no restore size", func(t *testing.T) {
+		vsc := vsc.DeepCopy()
+		vsc.Spec.Driver = "hostpath.csi.k8s.io"
+		class := class.DeepCopy()
+		class.Parameters[controller.ParamExportStorageClass] = "hostpath"
+		vs := &snapshotv1.VolumeSnapshot{
+			ObjectMeta: metav1.ObjectMeta{Name: "vs", Namespace: "ns"},
+			Spec:       snapshotv1.VolumeSnapshotSpec{Source: snapshotv1.VolumeSnapshotSource{PersistentVolumeClaimName: new("pvc")}},
+		}
+		source := &corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "pvc", Namespace: "ns"},
+			Status:     corev1.PersistentVolumeClaimStatus{Capacity: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("20Gi")}},
+		}
+		mockCtl := gomock.NewController(t)
+		defer mockCtl.Finish()
+		r, _, k8s := initTest(mockCtl, vsc, class, vs, source)
+		_, err := r.Reconcile(t.Context(), req)
+		require.NoError(t, err)
+		var pvc corev1.PersistentVolumeClaim
+		require.NoError(t, k8s.Get(t.Context(), types.NamespacedName{Namespace: "kube-system", Name: "export-vsc-uid"}, &pvc))
+		assert.Equal(t, "20Gi", pvc.Spec.Resources.Requests.Storage().String())
+	})
+	t.Run("Data mover exports fail if the size of the snapshot is unknown", func(t *testing.T) {
+		vsc := vsc.DeepCopy()
+		vsc.Spec.Driver = "hostpath.csi.k8s.io"
+		class := class.DeepCopy()
+		class.Parameters[controller.ParamExportStorageClass] = "hostpath"
+		mockCtl := gomock.NewController(t)
+		defer mockCtl.Finish()
+		r, _, k8s := initTest(mockCtl, vsc, class)
+		res, err := r.Reconcile(t.Context(), req)
+		require.NoError(t, err)
+		assert.Zero(t, res.RequeueAfter)
+		var updated snapshotv1.VolumeSnapshotContent
+		require.NoError(t, k8s.Get(t.Context(), req.NamespacedName, &updated))
+		assert.Equal(t, string(osc.SnapshotExportTaskStateFailed), updated.Annotations[controller.AnnotationExportState])
+		assert.Contains(t, updated.Annotations[controller.AnnotationExportError], "unable to find the size of the snapshot")
+	})
+	t.Run("Data mover exports fail after repeated job failures", func(t *testing.T) {
+		vsc := vsc.DeepCopy()
+		vsc.Spec.Driver = "hostpath.csi.k8s.io"
+		vsc.Annotations = map[string]string{
+			controller.AnnotationExportState:       controller.ExportStateMoving,
+			controller.AnnotationExportTask:        "datamove-vsc-uid-0",
+			controller.AnnotationExportJobFailures: "2",
+		}
+		job := &batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{Name: "datamove-vsc-uid", Namespace: "kube-system"},
+			Status: batchv1.JobStatus{Conditions: []batchv1.JobCondition{
+				{Type: batchv1.JobFailed, Status: corev1.ConditionTrue, Message: "BackoffLimitExceeded"},
+			}},
+		}
+		pvc := &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "export-vsc-uid", Namespace: "kube-system"}}
+		mockCtl := gomock.NewController(t)
+		defer mockCtl.Finish()
+		r, _, k8s := initTest(mockCtl, vsc, class, job, pvc)
+		_, err := r.Reconcile(t.Context(), req)
+		require.NoError(t, err)
+		err = k8s.Get(t.Context(), client.ObjectKeyFromObject(pvc), pvc)
+		assert.True(t, apierrors.IsNotFound(err))
+		var updated snapshotv1.VolumeSnapshotContent
+		require.NoError(t, k8s.Get(t.Context(), req.NamespacedName, &updated))
+		assert.Equal(t, string(osc.SnapshotExportTaskStateFailed), updated.Annotations[controller.AnnotationExportState])
+		assert.Equal(t, "data mover has failed: BackoffLimitExceeded", updated.Annotations[controller.AnnotationExportError])
+	})
+	t.Run("Data mover resources are deleted once the snapshot is exported", func(t *testing.T) {
+		vsc := vsc.DeepCopy()
+		vsc.Spec.Driver = "hostpath.csi.k8s.io"
+		vsc.Annotations = map[string]string{controller.AnnotationExportState: controller.ExportStateMoving}
+		job := &batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "datamove-vsc-uid", Namespace: "kube-system",
+				Annotations: map[string]string{
+					controller.AnnotationExportManifest: `{"bucket":"bucket","path":"vsc.qcow2.gz","format":"qcow2","compression":"gzip","snapshotId":"snap-foo"}`,
+				},
+			},
+			Status: batchv1.JobStatus{Succeeded: 1},
+		}
+		pvc := &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "export-vsc-uid", Namespace: "kube-system"}}
+		snapshot := &snapshotv1.VolumeSnapshot{ObjectMeta: metav1.ObjectMeta{Name: "export-vsc-uid", Namespace: "kube-system"}}
+		content := &snapshotv1.VolumeSnapshotContent{ObjectMeta: metav1.ObjectMeta{Name: "export-vsc-uid"}}
+		mockCtl := gomock.NewController(t)
+		defer mockCtl.Finish()
+		r, _, k8s := initTest(mockCtl, vsc, class, job, pvc, snapshot, content)
+		_, err := r.Reconcile(t.Context(), req)
+		require.NoError(t, err)
+		for _, obj := range []client.Object{pvc, snapshot, content} {
+			err = k8s.Get(t.Context(), client.ObjectKeyFromObject(obj), obj)
+			assert.True(t, apierrors.IsNotFound(err))
+		}
+		var updated snapshotv1.VolumeSnapshotContent
+		require.NoError(t, k8s.Get(t.Context(), req.NamespacedName, &updated))
+		assert.Equal(t, string(osc.SnapshotExportTaskStateCompleted), updated.Annotations[controller.AnnotationExportState])
+		assert.Equal(t, "vsc.qcow2.gz", updated.Annotations[controller.AnnotationExportPath])
+		assert.Equal(t, "datamove-vsc-uid-0", updated.Annotations[controller.AnnotationExportTask])
+	})
+	t.Run("Failed encryption jobs are retried", func(t *testing.T) {
+		class := class.DeepCopy()
+		class.Parameters[controller.ParamExportEncryption] = encryption.Algorithm
+		class.Parameters[controller.ParamExportEncryptionKeySecret] = "export-key"
+		vsc := vsc.DeepCopy()
+		vsc.Annotations = map[string]string{
+			controller.AnnotationExportTask:     "snap-export-foo",
+			controller.AnnotationExportState:    controller.ExportStateEncrypting,
+			controller.AnnotationExportManifest: `{"bucket":"bucket","path":"snap-foo-foo.qcow2.gz"}`,
+		}
+		job := &batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{Name: "encrypt-vsc-uid", Namespace: "kube-system"},
+			Status: batchv1.JobStatus{Conditions: []batchv1.JobCondition{
+				{Type: batchv1.JobFailed, Status: corev1.ConditionTrue},
+			}},
+		}
+		mockCtl := gomock.NewController(t)
+		defer mockCtl.Finish()
+		r, _, k8s := initTest(mockCtl, vsc, class, job)
+		res, err := r.Reconcile(t.Context(), req)
+		require.NoError(t, err)
+		assert.NotZero(t, res.RequeueAfter)
+		err = k8s.Get(t.Context(), client.ObjectKeyFromObject(job), &batchv1.Job{})
+		assert.True(t, apierrors.IsNotFound(err))
+	})
+	t.Run("Encryption fails after repeated job failures", func(t *testing.T) {
+		class := class.DeepCopy()
+		class.Parameters[controller.ParamExportEncryption] = encryption.Algorithm
+		class.Parameters[controller.ParamExportEncryptionKeySecret] = "export-key"
+		vsc := vsc.DeepCopy()
+		vsc.Annotations = map[string]string{
+			controller.AnnotationExportTask:        "snap-export-foo",
+			controller.AnnotationExportState:       controller.ExportStateEncrypting,
+			controller.AnnotationExportJobFailures: "2",
+			controller.AnnotationExportManifest:    `{"bucket":"bucket","path":"snap-foo-foo.qcow2.gz"}`,
+		}
+		job := &batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{Name: "encrypt-vsc-uid", Namespace: "kube-system"},
+			Status: batchv1.JobStatus{Conditions: []batchv1.JobCondition{
+				{Type: batchv1.JobFailed, Status: corev1.ConditionTrue, Message: "BackoffLimitExceeded"},
+			}},
+		}
+		mockCtl := gomock.NewController(t)
+		defer mockCtl.Finish()
+		r, _, k8s := initTest(mockCtl, vsc, class, job)
+		res, err := r.Reconcile(t.Context(), req)
+		require.NoError(t, err)
+		assert.Zero(t, res.RequeueAfter)
+		var updated snapshotv1.VolumeSnapshotContent
+		require.NoError(t, k8s.Get(t.Context(), req.NamespacedName, &updated))
+		assert.Equal(t, string(osc.SnapshotExportTaskStateFailed), updated.Annotations[controller.AnnotationExportState])
+		assert.Equal(t, "encryption has failed: BackoffLimitExceeded", updated.Annotations[controller.AnnotationExportError])
+	})
+	t.Run("Request is requeued when the export has failed", func(t *testing.T) {
+		vsc := vsc.DeepCopy()
+		vsc.Annotations = map[string]string{
+			controller.AnnotationExportTask:           "snap-export-foo",
+			controller.AnnotationExportState:          string(osc.SnapshotExportTaskStateInitializing),
+			controller.AnnotationExportResolvedPrefix: "/vs/ns/2025-11-02",
 		}
 		mockCtl := gomock.NewController(t)
 		defer mockCtl.Finish()
-		r, mockOAPI := initTest(mockCtl, vsc, class)
+		r, mockOAPI, _ := initTest(mockCtl, vsc, class)
 		mockOAPI.EXPECT().ReadSnapshotExportTasks(gomock.Any(), gomock.Eq(osc.ReadSnapshotExportTasksRequest{
 			Filters: &osc.FiltersSnapshotExportTask{
 				TaskIds: &[]string{"snap-export-foo"},
@@ -174,18 +1795,25 @@ func TestReconcile(t *testing.T) {
 				OsuExport: osc.OsuExportSnapshotExportTask{},
 				State:     osc.SnapshotExportTaskStateFailed,
 			}}}, nil)
+		expectTaskSearch(mockOAPI)
+		expectSnapshotCompleted(mockOAPI)
 		mockOAPI.EXPECT().CreateSnapshotExportTask(gomock.Any(), gomock.Eq(osc.CreateSnapshotExportTaskRequest{
 			SnapshotId: "snap-foo",
 			OsuExport: osc.OsuExportToCreate{
 				DiskImageFormat: "qcow2",
 				OsuBucket:       "bucket",
-				OsuPrefix:       new("/vs/ns/" + time.Now().Format(time.DateOnly)),
+				OsuPrefix:       new("/vs/ns/2025-11-02"),
 			},
 		})).
 			Return(&osc.CreateSnapshotExportTaskResponse{SnapshotExportTask: &osc.SnapshotExportTask{
+				TaskId:    "snap-export-bar",
 				OsuExport: osc.OsuExportSnapshotExportTask{},
 				State:     osc.SnapshotExportTaskStatePending,
 			}}, nil)
+		mockOAPI.EXPECT().CreateTags(gomock.Any(), gomock.Eq(osc.CreateTagsRequest{
+			ResourceIds: []string{"snap-export-bar"},
+			Tags:        []osc.ResourceTag{{Key: controller.TagContentUID, Value: "vsc-uid"}, {Key: controller.TagClusterID}},
+		})).Return(&osc.CreateTagsResponse{}, nil)
 		res, err := r.Reconcile(t.Context(), req)
 		require.NoError(t, err)
 		assert.NotZero(t, res.RequeueAfter)
@@ -0,0 +1,79 @@
+/*
+SPDX-FileCopyrightText: 2025 Outscale SAS <opensource@outscale.com>
+
+SPDX-License-Identifier: BSD-3-Clause
+*/
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	volumesnapshotv1 "github.com/kubernetes-csi/external-snapshotter/client/v8/apis/volumesnapshot/v1"
+	"github.com/outscale/csi-snapshot-exporter/internal/objectstore"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// +kubebuilder:webhook:path=/validate-snapshot-storage-k8s-io-v1-volumesnapshotclass,mutating=false,failurePolicy=ignore,sideEffects=None,groups=snapshot.storage.k8s.io,resources=volumesnapshotclasses,verbs=create;update,versions=v1,name=vvolumesnapshotclass-v1.bsu.csi.outscale.com,admissionReviewVersions=v1
+
+// objectLockCheckTimeout bounds the check of object lock on the bucket.
+const objectLockCheckTimeout = 5 * time.Second
+
+// VolumeSnapshotClassValidator validates the export parameters of VolumeSnapshotClasses.
+type VolumeSnapshotClassValidator struct {
+	// Store is used to check that object lock is enabled on the bucket, if set.
+	Store objectstore.Store
+}
+
+var _ admission.CustomValidator = (*VolumeSnapshotClassValidator)(nil)
+
+// SetupWebhookWithManager registers the webhook with the Manager.
+func (v *VolumeSnapshotClassValidator) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(&volumesnapshotv1.VolumeSnapshotClass{}).
+		WithValidator(v).
+		Complete()
+}
+
+func (v *VolumeSnapshotClassValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return nil, v.validate(ctx, obj)
+}
+
+func (v *VolumeSnapshotClassValidator) ValidateUpdate(ctx context.Context, _, obj runtime.Object) (admission.Warnings, error) {
+	return nil, v.validate(ctx, obj)
+}
+
+func (v *VolumeSnapshotClassValidator) ValidateDelete(context.Context, runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+func (v *VolumeSnapshotClassValidator) validate(ctx context.Context, obj runtime.Object) error {
+	class, ok := obj.(*volumesnapshotv1.VolumeSnapshotClass)
+	if !ok {
+		return fmt.Errorf("expected a VolumeSnapshotClass, got %T", obj)
+	}
+	if class.Parameters[ParamExportEnabled] != "true" {
+		return nil
+	}
+	content := &volumesnapshotv1.VolumeSnapshotContent{Spec: volumesnapshotv1.VolumeSnapshotContentSpec{Driver: class.Driver}}
+	scope := NewScope(nil, content, class.Parameters)
+	if err := scope.Validate(); err != nil {
+		return err
+	}
+	if lock, _ := scope.ExportLock(); lock.Enabled() && v.Store != nil {
+		// the admission request times out after 10s
+		ctx, cancel := context.WithTimeout(ctx, objectLockCheckTimeout)
+		defer cancel()
+		enabled, err := v.Store.ObjectLockEnabled(ctx, scope.ExportBucket())
+		switch {
+		case err != nil:
+			return fmt.Errorf("unable to check object lock on bucket %s: %w", scope.ExportBucket(), err)
+		case !enabled:
+			return fmt.Errorf("%s: %w", scope.ExportBucket(), objectstore.ErrObjectLockDisabled)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+/*
+SPDX-FileCopyrightText: 2025 Outscale SAS <opensource@outscale.com>
+
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+// Package encryption encrypts exported images with AES-256-GCM.
+//
+// Streams are split in chunks, each chunk being sealed with a nonce made of a random prefix, the chunk counter and a
+// flag marking the last chunk, so that chunks may not be reordered or truncated.
+//
+//	header: magic (4 bytes) | version (1 byte) | nonce prefix (7 bytes)
+//	chunks: sealed chunk (up to 64KiB + 16 bytes), the last chunk being shorter than a full chunk
+package encryption
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	// Algorithm is the name of the encryption algorithm.
+	Algorithm = "aes-256-gcm"
+	// Extension is appended to the key of encrypted objects.
+	Extension = ".enc"
+	// KeySize is the size of keys.
+	KeySize = 32
+
+	version   = 1
+	chunkSize = 64 << 10
+	prefixLen = 7
+)
+
+var magic = []byte("OSCE")
+
+var ErrInvalidStream = errors.New("invalid encrypted stream")
+
+// KeyID identifies a key, without disclosing it.
+func KeyID(key []byte) string {
+	h := sha256.Sum256(key)
+	return "sha256:" + hex.EncodeToString(h[:8])
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("invalid key size %d, expected %d", len(key), KeySize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func nonce(prefix []byte, counter uint32, last bool) []byte {
+	n := make([]byte, 12)
+	copy(n, prefix)
+	binary.BigEndian.PutUint32(n[prefixLen:], counter)
+	if last {
+		n[11] = 1
+	}
+	return n
+}
+
+// Encrypt encrypts src to dst.
+func Encrypt(dst io.Writer, src io.Reader, key []byte) error {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return err
+	}
+	prefix := make([]byte, prefixLen)
+	if _, err := rand.Read(prefix); err != nil {
+		return err
+	}
+	if _, err := dst.Write(append(append(append([]byte{}, magic...), version), prefix...)); err != nil {
+		return err
+	}
+	buf := make([]byte, chunkSize)
+	out := make([]byte, 0, chunkSize+aead.Overhead())
+	for counter := uint32(0); ; counter++ {
+		n, err := io.ReadFull(src, buf)
+		last := errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+		if err != nil && !last {
+			return err
+		}
+		if _, err := dst.Write(aead.Seal(out[:0], nonce(prefix, counter, last), buf[:n], nil)); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+	}
+}
+
+// Decrypt decrypts src to dst.
+func Decrypt(dst io.Writer, src io.Reader, key []byte) error {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return err
+	}
+	header := make([]byte, len(magic)+1+prefixLen)
+	if _, err := io.ReadFull(src, header); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidStream, err)
+	}
+	if !bytes.Equal(header[:len(magic)], magic) || header[len(magic)] != version {
+		return fmt.Errorf("%w: unknown header", ErrInvalidStream)
+	}
+	prefix := header[len(magic)+1:]
+	buf := make([]byte, chunkSize+aead.Overhead())
+	out := make([]byte, 0, chunkSize)
+	for counter := uint32(0); ; counter++ {
+		n, err := io.ReadFull(src, buf)
+		last := errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+		if err != nil && !last {
+			return err
+		}
+		plain, err := aead.Open(out[:0], nonce(prefix, counter, last), buf[:n], nil)
+		if err != nil {
+			return fmt.Errorf("%w: chunk %d: %w", ErrInvalidStream, counter, err)
+		}
+		if _, err := dst.Write(plain); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,73 @@
+/*
+SPDX-FileCopyrightText: 2025 Outscale SAS <opensource@outscale.com>
+
+SPDX-License-Identifier: BSD-3-Clause
+*/
+package encryption_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+
+	"github.com/outscale/csi-snapshot-exporter/internal/encryption"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func encrypt(t *testing.T, plain, key []byte) []byte {
+	var buf bytes.Buffer
+	require.NoError(t, encryption.Encrypt(&buf, bytes.NewReader(plain), key))
+	return buf.Bytes()
+}
+
+func TestEncrypt(t *testing.T) {
+	key := make([]byte, encryption.KeySize)
+	_, _ = rand.Read(key)
+	for _, size := range []int{0, 10, 64 << 10, 3*(64<<10) + 7} {
+		plain := make([]byte, size)
+		_, _ = rand.Read(plain)
+		enc := encrypt(t, plain, key)
+		if size > 0 {
+			assert.NotContains(t, string(enc), string(plain))
+		}
+		var dec bytes.Buffer
+		require.NoError(t, encryption.Decrypt(&dec, bytes.NewReader(enc), key), "size %d", size)
+		assert.Equal(t, string(plain), dec.String(), "size %d", size)
+	}
+}
+
+func TestDecrypt_Invalid(t *testing.T) {
+	key := make([]byte, encryption.KeySize)
+	_, _ = rand.Read(key)
+	plain := make([]byte, 2*(64<<10))
+	enc := encrypt(t, plain, key)
+
+	otherKey := make([]byte, encryption.KeySize)
+	_, _ = rand.Read(otherKey)
+	tampered := bytes.Clone(enc)
+	tampered[100] ^= 1
+	tcs := []struct {
+		name string
+		enc  []byte
+		key  []byte
+	}{
+		{name: "wrong key", enc: enc, key: otherKey},
+		{name: "tampered chunk", enc: tampered, key: key},
+		{name: "truncated stream", enc: enc[:12+64<<10+16], key: key},
+		{name: "invalid header", enc: []byte("foo"), key: key},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			err := encryption.Decrypt(&bytes.Buffer{}, bytes.NewReader(tc.enc), tc.key)
+			require.ErrorIs(t, err, encryption.ErrInvalidStream)
+		})
+	}
+}
+
+func TestKeyID(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, encryption.KeySize)
+	assert.Equal(t, encryption.KeyID(key), encryption.KeyID(bytes.Clone(key)))
+	assert.NotEqual(t, encryption.KeyID(key), encryption.KeyID(bytes.Repeat([]byte{2}, encryption.KeySize)))
+	assert.NotContains(t, encryption.KeyID(key), string(key))
+}
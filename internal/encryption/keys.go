@@ -0,0 +1,37 @@
+/*
+SPDX-FileCopyrightText: 2025 Outscale SAS <opensource@outscale.com>
+
+SPDX-License-Identifier: BSD-3-Clause
+*/
+package encryption
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+// KeyProvider provides encryption keys.
+// Other providers (e.g. a KMS) may be added by implementing this interface.
+type KeyProvider interface {
+	// Key returns the current key and its ID.
+	Key(ctx context.Context) (id string, key []byte, err error)
+}
+
+// FileKeyProvider reads a raw key from a file, e.g. a mounted Secret.
+type FileKeyProvider struct {
+	Path string
+}
+
+var _ KeyProvider = FileKeyProvider{}
+
+func (p FileKeyProvider) Key(context.Context) (string, []byte, error) {
+	key, err := os.ReadFile(p.Path)
+	if err != nil {
+		return "", nil, fmt.Errorf("unable to read key: %w", err)
+	}
+	if len(key) != KeySize {
+		return "", nil, fmt.Errorf("invalid key size %d, expected %d", len(key), KeySize)
+	}
+	return KeyID(key), key, nil
+}
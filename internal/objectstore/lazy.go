@@ -0,0 +1,112 @@
+/*
+SPDX-FileCopyrightText: 2025 Outscale SAS <opensource@outscale.com>
+
+SPDX-License-Identifier: BSD-3-Clause
+*/
+package objectstore
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// Lazy is a Store created on first use. Creation is retried on the next call if it fails.
+type Lazy struct {
+	new func(ctx context.Context) (Store, error)
+
+	mu    sync.Mutex
+	store Store
+}
+
+var _ Store = (*Lazy)(nil)
+
+func NewLazy(new func(ctx context.Context) (Store, error)) *Lazy {
+	return &Lazy{new: new}
+}
+
+func (l *Lazy) get(ctx context.Context) (Store, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.store == nil {
+		s, err := l.new(ctx)
+		if err != nil {
+			return nil, err
+		}
+		l.store = s
+	}
+	return l.store, nil
+}
+
+func (l *Lazy) Get(ctx context.Context, bucket, key string) (io.ReadCloser, error) {
+	s, err := l.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.Get(ctx, bucket, key)
+}
+
+func (l *Lazy) Put(ctx context.Context, bucket, key string, body io.Reader) error {
+	s, err := l.get(ctx)
+	if err != nil {
+		return err
+	}
+	return s.Put(ctx, bucket, key, body)
+}
+
+func (l *Lazy) Delete(ctx context.Context, bucket, key string) error {
+	s, err := l.get(ctx)
+	if err != nil {
+		return err
+	}
+	return s.Delete(ctx, bucket, key)
+}
+
+func (l *Lazy) Exists(ctx context.Context, bucket, key string) (bool, error) {
+	s, err := l.get(ctx)
+	if err != nil {
+		return false, err
+	}
+	return s.Exists(ctx, bucket, key)
+}
+
+func (l *Lazy) ObjectLockEnabled(ctx context.Context, bucket string) (bool, error) {
+	s, err := l.get(ctx)
+	if err != nil {
+		return false, err
+	}
+	return s.ObjectLockEnabled(ctx, bucket)
+}
+
+func (l *Lazy) SetRetention(ctx context.Context, bucket, key, mode string, until time.Time) error {
+	s, err := l.get(ctx)
+	if err != nil {
+		return err
+	}
+	return s.SetRetention(ctx, bucket, key, mode, until)
+}
+
+func (l *Lazy) SetLegalHold(ctx context.Context, bucket, key string) error {
+	s, err := l.get(ctx)
+	if err != nil {
+		return err
+	}
+	return s.SetLegalHold(ctx, bucket, key)
+}
+
+func (l *Lazy) SetTags(ctx context.Context, bucket, key string, tags map[string]string) error {
+	s, err := l.get(ctx)
+	if err != nil {
+		return err
+	}
+	return s.SetTags(ctx, bucket, key, tags)
+}
+
+func (l *Lazy) SetMetadata(ctx context.Context, bucket, key string, metadata map[string]string) error {
+	s, err := l.get(ctx)
+	if err != nil {
+		return err
+	}
+	return s.SetMetadata(ctx, bucket, key, metadata)
+}
@@ -0,0 +1,49 @@
+/*
+SPDX-FileCopyrightText: 2025 Outscale SAS <opensource@outscale.com>
+
+SPDX-License-Identifier: BSD-3-Clause
+*/
+package objectstore
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+// ErrNotFound is returned when an object does not exist.
+var ErrNotFound = errors.New("object not found")
+
+// ErrObjectLockDisabled is returned when object lock is used on a bucket not having object lock enabled.
+var ErrObjectLockDisabled = errors.New("object lock is not enabled on bucket")
+
+// Object lock retention modes.
+const (
+	LockModeGovernance = "GOVERNANCE"
+	LockModeCompliance = "COMPLIANCE"
+)
+
+// Store is an S3 compatible object store.
+type Store interface {
+	// Get opens an object.
+	Get(ctx context.Context, bucket, key string) (io.ReadCloser, error)
+	// Put writes an object, streaming its body.
+	Put(ctx context.Context, bucket, key string, body io.Reader) error
+	// Delete deletes an object.
+	Delete(ctx context.Context, bucket, key string) error
+	// Exists checks if an object exists.
+	Exists(ctx context.Context, bucket, key string) (bool, error)
+
+	// ObjectLockEnabled checks if object lock is enabled on a bucket.
+	ObjectLockEnabled(ctx context.Context, bucket string) (bool, error)
+	// SetRetention sets the object lock retention of an object.
+	SetRetention(ctx context.Context, bucket, key, mode string, until time.Time) error
+	// SetLegalHold sets a legal hold on an object.
+	SetLegalHold(ctx context.Context, bucket, key string) error
+
+	// SetTags replaces the tags of an object.
+	SetTags(ctx context.Context, bucket, key string, tags map[string]string) error
+	// SetMetadata replaces the user metadata of an object.
+	SetMetadata(ctx context.Context, bucket, key string, metadata map[string]string) error
+}
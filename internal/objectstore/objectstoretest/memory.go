@@ -0,0 +1,178 @@
+/*
+SPDX-FileCopyrightText: 2025 Outscale SAS <opensource@outscale.com>
+
+SPDX-License-Identifier: BSD-3-Clause
+*/
+package objectstoretest
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"maps"
+	"sync"
+	"time"
+
+	"github.com/outscale/csi-snapshot-exporter/internal/objectstore"
+)
+
+// Memory is an in-memory objectstore.Store, used as a local S3 fake in tests.
+type Memory struct {
+	mu          sync.Mutex
+	objects     map[string][]byte
+	lockBuckets map[string]bool
+	retentions  map[string]Retention
+	legalHolds  map[string]bool
+	tags        map[string]map[string]string
+	metadata    map[string]map[string]string
+}
+
+// Retention is the object lock retention of an object.
+type Retention struct {
+	Mode  string
+	Until time.Time
+}
+
+var _ objectstore.Store = (*Memory)(nil)
+
+func NewMemory() *Memory {
+	return &Memory{
+		objects:     map[string][]byte{},
+		lockBuckets: map[string]bool{},
+		retentions:  map[string]Retention{},
+		legalHolds:  map[string]bool{},
+		tags:        map[string]map[string]string{},
+		metadata:    map[string]map[string]string{},
+	}
+}
+
+// EnableObjectLock enables object lock on a bucket.
+func (m *Memory) EnableObjectLock(bucket string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lockBuckets[bucket] = true
+}
+
+// Retention returns the retention of an object.
+func (m *Memory) Retention(bucket, key string) (Retention, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, found := m.retentions[bucket+"/"+key]
+	return r, found
+}
+
+// LegalHold checks if an object has a legal hold.
+func (m *Memory) LegalHold(bucket, key string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.legalHolds[bucket+"/"+key]
+}
+
+// Tags returns the tags of an object.
+func (m *Memory) Tags(bucket, key string) map[string]string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.tags[bucket+"/"+key]
+}
+
+// Metadata returns the user metadata of an object.
+func (m *Memory) Metadata(bucket, key string) map[string]string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.metadata[bucket+"/"+key]
+}
+
+func (m *Memory) Get(_ context.Context, bucket, key string) (io.ReadCloser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	buf, found := m.objects[bucket+"/"+key]
+	if !found {
+		return nil, objectstore.ErrNotFound
+	}
+	return io.NopCloser(bytes.NewReader(buf)), nil
+}
+
+func (m *Memory) Put(_ context.Context, bucket, key string, body io.Reader) error {
+	buf, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.objects[bucket+"/"+key] = buf
+	delete(m.tags, bucket+"/"+key)
+	delete(m.metadata, bucket+"/"+key)
+	return nil
+}
+
+func (m *Memory) Delete(_ context.Context, bucket, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.objects, bucket+"/"+key)
+	delete(m.tags, bucket+"/"+key)
+	delete(m.metadata, bucket+"/"+key)
+	return nil
+}
+
+func (m *Memory) Exists(_ context.Context, bucket, key string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, found := m.objects[bucket+"/"+key]
+	return found, nil
+}
+
+func (m *Memory) ObjectLockEnabled(_ context.Context, bucket string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.lockBuckets[bucket], nil
+}
+
+func (m *Memory) SetRetention(_ context.Context, bucket, key, mode string, until time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkLockable(bucket, key); err != nil {
+		return err
+	}
+	m.retentions[bucket+"/"+key] = Retention{Mode: mode, Until: until}
+	return nil
+}
+
+func (m *Memory) SetLegalHold(_ context.Context, bucket, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkLockable(bucket, key); err != nil {
+		return err
+	}
+	m.legalHolds[bucket+"/"+key] = true
+	return nil
+}
+
+func (m *Memory) SetTags(_ context.Context, bucket, key string, tags map[string]string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, found := m.objects[bucket+"/"+key]; !found {
+		return objectstore.ErrNotFound
+	}
+	m.tags[bucket+"/"+key] = maps.Clone(tags)
+	return nil
+}
+
+func (m *Memory) SetMetadata(_ context.Context, bucket, key string, metadata map[string]string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, found := m.objects[bucket+"/"+key]; !found {
+		return objectstore.ErrNotFound
+	}
+	m.metadata[bucket+"/"+key] = maps.Clone(metadata)
+	return nil
+}
+
+func (m *Memory) checkLockable(bucket, key string) error {
+	if _, found := m.objects[bucket+"/"+key]; !found {
+		return objectstore.ErrNotFound
+	}
+	if !m.lockBuckets[bucket] {
+		return objectstore.ErrObjectLockDisabled
+	}
+	return nil
+}
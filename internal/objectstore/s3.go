@@ -0,0 +1,284 @@
+/*
+SPDX-FileCopyrightText: 2025 Outscale SAS <opensource@outscale.com>
+
+SPDX-License-Identifier: BSD-3-Clause
+*/
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/outscale/osc-sdk-go/v3/pkg/profile"
+)
+
+const (
+	// partSize is the initial size of parts of multipart uploads. As the size of streamed objects is unknown,
+	// it is doubled every partsPerSize parts, up to maxPartSize, so that maxParts parts hold more than 15 TiB,
+	// the maximum size of BSU volumes, while small objects are buffered in small parts.
+	partSize     = 64 << 20
+	partsPerSize = 500
+	maxPartSize  = 2 << 30
+	maxParts     = 10000
+	// maxCopySize is the maximum size of objects copied in a single request,
+	// larger objects are copied in copyPartSize parts.
+	maxCopySize  = 5 << 30
+	copyPartSize = 1 << 30
+	// defaultRegion is used to sign requests to endpoints having no region.
+	defaultRegion = "us-east-1"
+)
+
+// S3 is a Store backed by an S3 compatible API.
+type S3 struct {
+	s3 *s3.Client
+}
+
+var _ Store = (*S3)(nil)
+
+func NewS3(c *s3.Client) *S3 {
+	return &S3{s3: c}
+}
+
+// NewOOS creates a Store for the OOS endpoint of the profile.
+func NewOOS(ctx context.Context, p *profile.Profile) (*S3, error) {
+	endpoint, err := p.GetEndpoint(profile.OscServiceOOS)
+	if err != nil {
+		return nil, fmt.Errorf("oos endpoint: %w", err)
+	}
+	return NewEndpoint(ctx, endpoint, p.Region, p.AccessKey, p.SecretKey)
+}
+
+// NewEndpoint creates a Store for an S3 compatible endpoint, using path-style addressing.
+func NewEndpoint(ctx context.Context, endpoint, region, accessKey, secretKey string) (*S3, error) {
+	if region == "" {
+		region = defaultRegion
+	}
+	cfg, err := config.LoadDefaultConfig(ctx,
+		config.WithRegion(region),
+		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(accessKey, secretKey, "")),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return NewS3(s3.NewFromConfig(cfg, func(o *s3.Options) {
+		o.BaseEndpoint = &endpoint
+		o.UsePathStyle = true
+	})), nil
+}
+
+func (s *S3) Get(ctx context.Context, bucket, key string) (io.ReadCloser, error) {
+	res, err := s.s3.GetObject(ctx, &s3.GetObjectInput{Bucket: &bucket, Key: &key})
+	if err != nil {
+		return nil, mapError(err)
+	}
+	return res.Body, nil
+}
+
+// Put uploads the body in parts, as the size of streamed objects is unknown.
+func (s *S3) Put(ctx context.Context, bucket, key string, body io.Reader) error {
+	buf := make([]byte, partSize)
+	n, err := io.ReadFull(body, buf)
+	switch {
+	case errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF):
+		_, err = s.s3.PutObject(ctx, &s3.PutObjectInput{Bucket: &bucket, Key: &key, Body: bytes.NewReader(buf[:n])})
+		return err
+	case err != nil:
+		return err
+	}
+
+	upload, err := s.s3.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{Bucket: &bucket, Key: &key})
+	if err != nil {
+		return err
+	}
+	var parts []types.CompletedPart
+	for n > 0 {
+		num := int32(len(parts) + 1)
+		if num > maxParts {
+			s.abort(bucket, key, upload.UploadId)
+			return fmt.Errorf("object is larger than %d parts", maxParts)
+		}
+		res, err := s.s3.UploadPart(ctx, &s3.UploadPartInput{
+			Bucket: &bucket, Key: &key, UploadId: upload.UploadId,
+			PartNumber: &num, Body: bytes.NewReader(buf[:n]),
+		})
+		if err != nil {
+			s.abort(bucket, key, upload.UploadId)
+			return fmt.Errorf("upload part %d: %w", num, err)
+		}
+		parts = append(parts, types.CompletedPart{ETag: res.ETag, PartNumber: &num})
+		if size := partSizeOf(num + 1); size != len(buf) {
+			buf = make([]byte, size)
+		}
+		n, err = io.ReadFull(body, buf)
+		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			s.abort(bucket, key, upload.UploadId)
+			return err
+		}
+	}
+	_, err = s.s3.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket: &bucket, Key: &key, UploadId: upload.UploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	})
+	if apiErr, ok := errors.AsType[smithy.APIError](err); ok && apiErr.ErrorCode() == "InvalidRequest" &&
+		strings.Contains(apiErr.ErrorMessage(), "Object Lock") {
+		return fmt.Errorf("%w: %w", ErrObjectLockDisabled, err)
+	}
+	return err
+}
+
+// partSizeOf returns the size of part num of a multipart upload.
+func partSizeOf(num int32) int {
+	return min(partSize<<min((num-1)/partsPerSize, 5), maxPartSize)
+}
+
+func (s *S3) abort(bucket, key string, uploadID *string) {
+	// the upload context may be cancelled
+	_, _ = s.s3.AbortMultipartUpload(context.Background(), &s3.AbortMultipartUploadInput{Bucket: &bucket, Key: &key, UploadId: uploadID})
+}
+
+func (s *S3) Delete(ctx context.Context, bucket, key string) error {
+	_, err := s.s3.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: &bucket, Key: &key})
+	if apiErr, ok := errors.AsType[smithy.APIError](err); ok && apiErr.ErrorCode() == "InvalidRequest" &&
+		strings.Contains(apiErr.ErrorMessage(), "Object Lock") {
+		return fmt.Errorf("%w: %w", ErrObjectLockDisabled, err)
+	}
+	return err
+}
+
+func (s *S3) Exists(ctx context.Context, bucket, key string) (bool, error) {
+	_, err := s.s3.HeadObject(ctx, &s3.HeadObjectInput{Bucket: &bucket, Key: &key})
+	switch err = mapError(err); {
+	case errors.Is(err, ErrNotFound):
+		return false, nil
+	case err != nil:
+		return false, err
+	default:
+		return true, nil
+	}
+}
+
+func (s *S3) ObjectLockEnabled(ctx context.Context, bucket string) (bool, error) {
+	res, err := s.s3.GetObjectLockConfiguration(ctx, &s3.GetObjectLockConfigurationInput{Bucket: &bucket})
+	if apiErr, ok := errors.AsType[smithy.APIError](err); ok && apiErr.ErrorCode() == "ObjectLockConfigurationNotFoundError" {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return res.ObjectLockConfiguration != nil && res.ObjectLockConfiguration.ObjectLockEnabled == types.ObjectLockEnabledEnabled, nil
+}
+
+func (s *S3) SetRetention(ctx context.Context, bucket, key, mode string, until time.Time) error {
+	_, err := s.s3.PutObjectRetention(ctx, &s3.PutObjectRetentionInput{
+		Bucket: &bucket, Key: &key,
+		Retention: &types.ObjectLockRetention{Mode: types.ObjectLockRetentionMode(mode), RetainUntilDate: &until},
+	})
+	return mapError(err)
+}
+
+func (s *S3) SetLegalHold(ctx context.Context, bucket, key string) error {
+	_, err := s.s3.PutObjectLegalHold(ctx, &s3.PutObjectLegalHoldInput{
+		Bucket: &bucket, Key: &key,
+		LegalHold: &types.ObjectLockLegalHold{Status: types.ObjectLockLegalHoldStatusOn},
+	})
+	return mapError(err)
+}
+
+func (s *S3) SetTags(ctx context.Context, bucket, key string, tags map[string]string) error {
+	tagSet := make([]types.Tag, 0, len(tags))
+	for _, k := range slices.Sorted(maps.Keys(tags)) {
+		tagSet = append(tagSet, types.Tag{Key: &k, Value: new(tags[k])})
+	}
+	_, err := s.s3.PutObjectTagging(ctx, &s3.PutObjectTaggingInput{
+		Bucket: &bucket, Key: &key,
+		Tagging: &types.Tagging{TagSet: tagSet},
+	})
+	return mapError(err)
+}
+
+// SetMetadata copies the object onto itself, replacing its metadata. Tags are kept.
+func (s *S3) SetMetadata(ctx context.Context, bucket, key string, metadata map[string]string) error {
+	head, err := s.s3.HeadObject(ctx, &s3.HeadObjectInput{Bucket: &bucket, Key: &key})
+	if err != nil {
+		return mapError(err)
+	}
+	source := bucket + "/" + url.PathEscape(key)
+	if size := *head.ContentLength; size > maxCopySize {
+		return s.copyParts(ctx, bucket, key, source, size, metadata)
+	}
+	_, err = s.s3.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket: &bucket, Key: &key, CopySource: &source,
+		Metadata:          metadata,
+		MetadataDirective: types.MetadataDirectiveReplace,
+		TaggingDirective:  types.TaggingDirectiveCopy,
+	})
+	return mapError(err)
+}
+
+// copyParts copies an object larger than maxCopySize, using a multipart upload.
+func (s *S3) copyParts(ctx context.Context, bucket, key, source string, size int64, metadata map[string]string) error {
+	tags, err := s.s3.GetObjectTagging(ctx, &s3.GetObjectTaggingInput{Bucket: &bucket, Key: &key})
+	if err != nil {
+		return mapError(err)
+	}
+	upload, err := s.s3.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket: &bucket, Key: &key, Metadata: metadata, Tagging: new(encodeTags(tags.TagSet)),
+	})
+	if err != nil {
+		return err
+	}
+	var parts []types.CompletedPart
+	for start := int64(0); start < size; start += copyPartSize {
+		num := int32(len(parts) + 1)
+		end := min(start+copyPartSize, size) - 1
+		res, err := s.s3.UploadPartCopy(ctx, &s3.UploadPartCopyInput{
+			Bucket: &bucket, Key: &key, UploadId: upload.UploadId, PartNumber: &num,
+			CopySource: &source, CopySourceRange: new(fmt.Sprintf("bytes=%d-%d", start, end)),
+		})
+		if err != nil {
+			s.abort(bucket, key, upload.UploadId)
+			return fmt.Errorf("copy part %d: %w", num, err)
+		}
+		parts = append(parts, types.CompletedPart{ETag: res.CopyPartResult.ETag, PartNumber: &num})
+	}
+	_, err = s.s3.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket: &bucket, Key: &key, UploadId: upload.UploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	})
+	return err
+}
+
+func encodeTags(tagSet []types.Tag) string {
+	values := url.Values{}
+	for _, t := range tagSet {
+		values.Set(*t.Key, *t.Value)
+	}
+	return values.Encode()
+}
+
+func mapError(err error) error {
+	if _, ok := errors.AsType[*types.NoSuchKey](err); ok {
+		return fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+	if _, ok := errors.AsType[*types.NotFound](err); ok {
+		return fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+	if apiErr, ok := errors.AsType[smithy.APIError](err); ok && apiErr.ErrorCode() == "InvalidRequest" &&
+		strings.Contains(apiErr.ErrorMessage(), "Object Lock") {
+		return fmt.Errorf("%w: %w", ErrObjectLockDisabled, err)
+	}
+	return err
+}
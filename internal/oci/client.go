@@ -0,0 +1,448 @@
+/*
+SPDX-FileCopyrightText: 2025 Outscale SAS <opensource@outscale.com>
+
+SPDX-License-Identifier: BSD-3-Clause
+*/
+package oci
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultChunkSize is the size of the chunks blobs are uploaded in.
+	defaultChunkSize = 64 << 20
+	// maxChunkAttempts is the number of attempts to upload a chunk, retries being delayed by chunkRetryDelay times the attempt.
+	maxChunkAttempts = 3
+	chunkRetryDelay  = time.Second
+)
+
+// Client pushes artifacts to a repository of a registry, with the distribution API.
+// Anonymous, basic and bearer token authentications are supported.
+type Client struct {
+	base       *url.URL
+	repository string
+	username   string
+	password   string
+	http       *http.Client
+	chunkSize  int
+	// authorization is the Authorization header, set by authenticate
+	authorization string
+	authenticated bool
+}
+
+// NewClient creates a client of a repository. The endpoint is the URL of the registry, http endpoints being insecure registries.
+func NewClient(endpoint, repository, username, password string) (*Client, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid registry %q", endpoint)
+	}
+	if !ValidRepository(repository) {
+		return nil, fmt.Errorf("invalid repository %q", repository)
+	}
+	return &Client{base: u, repository: repository, username: username, password: password, http: http.DefaultClient, chunkSize: defaultChunkSize}, nil
+}
+
+// SetChunkSize sets the size of the chunks blobs are uploaded in, 64 MiB by default.
+func (c *Client) SetChunkSize(size int) {
+	c.chunkSize = size
+}
+
+// Reference returns the reference of a tag, e.g. registry.example.com/snapshots:ns-pvc-20250102.
+func (c *Client) Reference(tag string) string {
+	return c.base.Host + "/" + c.repository + ":" + tag
+}
+
+// Exists checks if a tag exists.
+func (c *Client) Exists(ctx context.Context, tag string) (bool, error) {
+	if err := c.authenticate(ctx); err != nil {
+		return false, err
+	}
+	resp, err := c.request(ctx, http.MethodHead, c.path("manifests/"+tag), nil, http.Header{"Accept": {ManifestMediaType}})
+	if err != nil {
+		return false, err
+	}
+	_ = resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("unable to check tag: unexpected status %s", resp.Status)
+	}
+}
+
+// Artifact is a snapshot artifact.
+type Artifact struct {
+	Config Config
+	// Disk is the exported file, of media type MediaType, and named Title.
+	Disk      io.Reader
+	MediaType string
+	Title     string
+}
+
+// Push pushes an artifact, and tags it. The digest of the manifest is returned.
+// The disk image is streamed in chunks, blobs already pushed are not pushed again.
+func (c *Client) Push(ctx context.Context, tag string, a Artifact) (string, error) {
+	if !ValidTag(tag) {
+		return "", fmt.Errorf("invalid tag %q", tag)
+	}
+	if err := c.authenticate(ctx); err != nil {
+		return "", err
+	}
+	config, err := json.Marshal(a.Config)
+	if err != nil {
+		return "", err
+	}
+	configDesc, err := c.pushBlob(ctx, ConfigMediaType, config)
+	if err != nil {
+		return "", fmt.Errorf("unable to push config: %w", err)
+	}
+	digest, size, err := c.upload(ctx, a.Disk)
+	if err != nil {
+		return "", fmt.Errorf("unable to push disk image: %w", err)
+	}
+	m := Manifest{
+		SchemaVersion: 2,
+		MediaType:     ManifestMediaType,
+		ArtifactType:  ArtifactType,
+		Config:        configDesc,
+		Layers: []Descriptor{{
+			MediaType:   a.MediaType,
+			Digest:      digest,
+			Size:        size,
+			Annotations: map[string]string{AnnotationTitle: a.Title},
+		}},
+	}
+	if !a.Config.CreationTime.IsZero() {
+		m.Annotations = map[string]string{AnnotationCreated: a.Config.CreationTime.UTC().Format("2006-01-02T15:04:05Z")}
+	}
+	manifest, err := json.Marshal(m)
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.do(ctx, http.MethodPut, c.path("manifests/"+tag), bytes.NewReader(manifest),
+		http.Header{"Content-Type": {ManifestMediaType}}, http.StatusCreated)
+	if err != nil {
+		return "", fmt.Errorf("unable to push manifest: %w", err)
+	}
+	return resp.Header.Get("Docker-Content-Digest"), nil
+}
+
+// pushBlob pushes a small blob, unless it exists, in a single request.
+func (c *Client) pushBlob(ctx context.Context, mediaType string, blob []byte) (Descriptor, error) {
+	desc := Descriptor{MediaType: mediaType, Digest: digestOf(blob), Size: int64(len(blob))}
+	resp, err := c.request(ctx, http.MethodHead, c.path("blobs/"+desc.Digest), nil, nil)
+	if err != nil {
+		return Descriptor{}, err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return desc, nil
+	}
+	resp, err = c.do(ctx, http.MethodPost, c.path("blobs/uploads/"), nil, nil, http.StatusAccepted)
+	if err != nil {
+		return Descriptor{}, err
+	}
+	if err := c.commit(ctx, resp.Header.Get("Location"), desc.Digest, blob); err != nil {
+		return Descriptor{}, err
+	}
+	return desc, nil
+}
+
+// upload streams a blob in chunks of known length, computing its digest on the fly.
+// Failed chunks are retried, and skipped if the registry reports they were received.
+func (c *Client) upload(ctx context.Context, r io.Reader) (string, int64, error) {
+	resp, err := c.do(ctx, http.MethodPost, c.path("blobs/uploads/"), nil, nil, http.StatusAccepted)
+	if err != nil {
+		return "", 0, err
+	}
+	location := resp.Header.Get("Location")
+	h := sha256.New()
+	buf := make([]byte, c.chunkSize)
+	var offset int64
+	for {
+		n, readErr := io.ReadFull(r, buf)
+		if n > 0 {
+			_, _ = h.Write(buf[:n])
+			location, err = c.uploadChunk(ctx, location, buf[:n], offset)
+			if err != nil {
+				return "", 0, fmt.Errorf("chunk at offset %d: %w", offset, err)
+			}
+			offset += int64(n)
+		}
+		if errors.Is(readErr, io.EOF) || errors.Is(readErr, io.ErrUnexpectedEOF) {
+			break
+		}
+		if readErr != nil {
+			return "", 0, readErr
+		}
+	}
+	digest := "sha256:" + hex.EncodeToString(h.Sum(nil))
+	if err := c.commit(ctx, location, digest, nil); err != nil {
+		return "", 0, err
+	}
+	return digest, offset, nil
+}
+
+// uploadChunk uploads the chunk starting at offset, and returns the location of the next chunk.
+func (c *Client) uploadChunk(ctx context.Context, location string, chunk []byte, offset int64) (string, error) {
+	end := offset + int64(len(chunk))
+	var err error
+	for attempt := range maxChunkAttempts {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return "", ctx.Err()
+			case <-time.After(time.Duration(attempt) * chunkRetryDelay):
+			}
+			// the chunk may have been received, the response being lost
+			received, next, statusErr := c.uploadStatus(ctx, location)
+			switch {
+			case statusErr != nil:
+				err = statusErr
+				continue
+			case received == end:
+				return next, nil
+			case received != offset:
+				return "", fmt.Errorf("registry has received %d bytes, expected %d", received, offset)
+			}
+			location = next
+		}
+		header := http.Header{
+			"Content-Type":  {"application/octet-stream"},
+			"Content-Range": {strconv.FormatInt(offset, 10) + "-" + strconv.FormatInt(end-1, 10)},
+		}
+		var resp *http.Response
+		resp, err = c.do(ctx, http.MethodPatch, location, bytes.NewReader(chunk), header, http.StatusAccepted)
+		if err == nil {
+			return resp.Header.Get("Location"), nil
+		}
+	}
+	return "", err
+}
+
+// uploadStatus returns the number of bytes received by an upload, and its location.
+func (c *Client) uploadStatus(ctx context.Context, location string) (int64, string, error) {
+	resp, err := c.do(ctx, http.MethodGet, location, nil, nil, http.StatusNoContent)
+	if err != nil {
+		return 0, "", err
+	}
+	next := cmp.Or(resp.Header.Get("Location"), location)
+	r := resp.Header.Get("Range")
+	if r == "" {
+		return 0, next, nil
+	}
+	_, last, _ := strings.Cut(r, "-")
+	n, err := strconv.ParseInt(last, 10, 64)
+	if err != nil {
+		return 0, "", fmt.Errorf("invalid upload range %q", r)
+	}
+	return n + 1, next, nil
+}
+
+// commit completes an upload, with its last chunk, if any.
+func (c *Client) commit(ctx context.Context, location, digest string, chunk []byte) error {
+	u, err := url.Parse(location)
+	if err != nil {
+		return fmt.Errorf("invalid upload location: %w", err)
+	}
+	q := u.Query()
+	q.Set("digest", digest)
+	u.RawQuery = q.Encode()
+	var header http.Header
+	if len(chunk) > 0 {
+		header = http.Header{"Content-Type": {"application/octet-stream"}}
+	}
+	_, err = c.do(ctx, http.MethodPut, u.String(), bytes.NewReader(chunk), header, http.StatusCreated)
+	return err
+}
+
+func (c *Client) path(p string) string {
+	return "/v2/" + c.repository + "/" + p
+}
+
+// authenticate checks the authentication required by the registry, and fetches a token if needed.
+func (c *Client) authenticate(ctx context.Context) error {
+	if c.authenticated {
+		return nil
+	}
+	resp, err := c.send(ctx, http.MethodGet, "/v2/", nil, nil)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		c.authenticated = true
+		return nil
+	case http.StatusUnauthorized:
+	default:
+		return fmt.Errorf("unable to reach registry: unexpected status %s", resp.Status)
+	}
+	scheme, params := parseChallenge(resp.Header.Get("WWW-Authenticate"))
+	switch strings.ToLower(scheme) {
+	case "basic":
+		c.authorization = "Basic " + c.basicAuth()
+	case "bearer":
+		token, err := c.fetchToken(ctx, params)
+		if err != nil {
+			return fmt.Errorf("unable to fetch token: %w", err)
+		}
+		c.authorization = "Bearer " + token
+	default:
+		return fmt.Errorf("unsupported authentication %q", scheme)
+	}
+	c.authenticated = true
+	return nil
+}
+
+func (c *Client) basicAuth() string {
+	return base64.StdEncoding.EncodeToString([]byte(c.username + ":" + c.password))
+}
+
+// fetchToken fetches a push token from the token server of a bearer challenge.
+func (c *Client) fetchToken(ctx context.Context, params map[string]string) (string, error) {
+	realm, err := url.Parse(params["realm"])
+	if err != nil || realm.Host == "" {
+		return "", fmt.Errorf("invalid realm %q", params["realm"])
+	}
+	q := realm.Query()
+	if service := params["service"]; service != "" {
+		q.Set("service", service)
+	}
+	q.Set("scope", "repository:"+c.repository+":pull,push")
+	realm.RawQuery = q.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if c.username != "" {
+		req.Header.Set("Authorization", "Basic "+c.basicAuth())
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("invalid token response: %w", err)
+	}
+	switch {
+	case token.Token != "":
+		return token.Token, nil
+	case token.AccessToken != "":
+		return token.AccessToken, nil
+	default:
+		return "", errors.New("no token in response")
+	}
+}
+
+// request sends a request to a path or URL, relative to the registry.
+// Tokens expire: the request is sent again once authenticated again, if the registry rejects its credentials.
+func (c *Client) request(ctx context.Context, method, ref string, body io.Reader, header http.Header) (*http.Response, error) {
+	resp, err := c.send(ctx, method, ref, body, header)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || !c.authenticated {
+		return resp, err
+	}
+	seeker, rewindable := body.(io.Seeker)
+	if body != nil && !rewindable {
+		return resp, nil
+	}
+	_ = resp.Body.Close()
+	c.authenticated = false
+	if err := c.authenticate(ctx); err != nil {
+		return nil, err
+	}
+	if seeker != nil {
+		if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+	}
+	return c.send(ctx, method, ref, body, header)
+}
+
+// send sends a request to a path or URL, relative to the registry.
+// Credentials are only sent to the registry, not to the storage it may redirect uploads to.
+func (c *Client) send(ctx context.Context, method, ref string, body io.Reader, header http.Header) (*http.Response, error) {
+	u, err := c.base.Parse(ref)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL %q: %w", ref, err)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if c.authorization != "" && u.Host == c.base.Host {
+		req.Header.Set("Authorization", c.authorization)
+	}
+	return c.http.Do(req)
+}
+
+// do sends a request, and checks its status. The body of the response is closed.
+func (c *Client) do(ctx context.Context, method, ref string, body io.Reader, header http.Header, status int) (*http.Response, error) {
+	resp, err := c.request(ctx, method, ref, body, header)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != status {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("%s %s: unexpected status %s: %s", method, ref, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
+
+// parseChallenge parses a WWW-Authenticate header, e.g. Bearer realm="https://auth.example.com/token",service="registry".
+func parseChallenge(v string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(v), " ")
+	params := map[string]string{}
+	for rest = strings.TrimSpace(rest); rest != ""; {
+		key, value, found := strings.Cut(rest, "=")
+		if !found {
+			break
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		if strings.HasPrefix(value, `"`) {
+			end := strings.Index(value[1:], `"`)
+			if end < 0 {
+				break
+			}
+			params[key], rest = value[1:end+1], value[end+2:]
+		} else {
+			params[key], rest, _ = strings.Cut(value, ",")
+		}
+		rest = strings.TrimLeft(rest, ", ")
+	}
+	return scheme, params
+}
+
+func digestOf(blob []byte) string {
+	sum := sha256.Sum256(blob)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
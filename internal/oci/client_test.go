@@ -0,0 +1,149 @@
+/*
+SPDX-FileCopyrightText: 2025 Outscale SAS <opensource@outscale.com>
+
+SPDX-License-Identifier: BSD-3-Clause
+*/
+package oci_test
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/outscale/csi-snapshot-exporter/internal/oci"
+	"github.com/outscale/csi-snapshot-exporter/internal/oci/ocitest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPush(t *testing.T) {
+	config := oci.Config{
+		Namespace:    "ns",
+		PVC:          "pvc",
+		Content:      "vsc",
+		Driver:       "bsu.csi.outscale.com",
+		SnapshotID:   "snap-foo",
+		CreationTime: time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC),
+		Format:       "qcow2",
+		Compression:  "gzip",
+	}
+	artifact := func() oci.Artifact {
+		return oci.Artifact{
+			Config:    config,
+			Disk:      strings.NewReader("snapshot"),
+			MediaType: oci.DiskMediaType("qcow2", "gzip", false),
+			Title:     "vsc.qcow2.gz",
+		}
+	}
+	for name, auth := range map[string]bool{
+		"Artifacts are pushed and tagged":         false,
+		"Artifacts are pushed with bearer tokens": true,
+	} {
+		t.Run(name, func(t *testing.T) {
+			registry := ocitest.NewMemory()
+			if auth {
+				registry.RequireAuth("user", "password")
+			}
+			srv := httptest.NewServer(registry)
+			defer srv.Close()
+			c, err := oci.NewClient(srv.URL, "backups/snapshots", "user", "password")
+			require.NoError(t, err)
+
+			found, err := c.Exists(t.Context(), "ns-pvc-20250102")
+			require.NoError(t, err)
+			assert.False(t, found)
+			digest, err := c.Push(t.Context(), "ns-pvc-20250102", artifact())
+			require.NoError(t, err)
+			assert.True(t, strings.HasPrefix(digest, "sha256:"))
+			found, err = c.Exists(t.Context(), "ns-pvc-20250102")
+			require.NoError(t, err)
+			assert.True(t, found)
+
+			m, found := registry.Manifest("backups/snapshots", "ns-pvc-20250102")
+			require.True(t, found)
+			assert.Equal(t, oci.ArtifactType, m.ArtifactType)
+			assert.Equal(t, oci.ConfigMediaType, m.Config.MediaType)
+			assert.Equal(t, map[string]string{oci.AnnotationCreated: "2025-01-02T03:04:05Z"}, m.Annotations)
+			require.Len(t, m.Layers, 1)
+			assert.Equal(t, "application/vnd.outscale.csi-snapshot.disk.v1.qcow2+gzip", m.Layers[0].MediaType)
+			assert.Equal(t, int64(len("snapshot")), m.Layers[0].Size)
+			assert.Equal(t, map[string]string{oci.AnnotationTitle: "vsc.qcow2.gz"}, m.Layers[0].Annotations)
+			disk, found := registry.Blob(m.Layers[0].Digest)
+			require.True(t, found)
+			assert.Equal(t, "snapshot", string(disk))
+			blob, found := registry.Blob(m.Config.Digest)
+			require.True(t, found)
+			var pushed oci.Config
+			require.NoError(t, json.Unmarshal(blob, &pushed))
+			assert.Equal(t, config, pushed)
+
+			// blobs are shared by tags
+			_, err = c.Push(t.Context(), "latest", artifact())
+			require.NoError(t, err)
+			_, found = registry.Manifest("backups/snapshots", "latest")
+			assert.True(t, found)
+		})
+	}
+	t.Run("Disk images are pushed in chunks, failed chunks being retried", func(t *testing.T) {
+		registry := ocitest.NewMemory()
+		srv := httptest.NewServer(registry)
+		defer srv.Close()
+		c, err := oci.NewClient(srv.URL, "snapshots", "", "")
+		require.NoError(t, err)
+		c.SetChunkSize(3)
+		// the first chunk is lost, the second one is received but its response is lost
+		registry.FailChunk(false)
+		registry.FailChunk(true)
+		_, err = c.Push(t.Context(), "foo", artifact())
+		require.NoError(t, err)
+
+		m, found := registry.Manifest("snapshots", "foo")
+		require.True(t, found)
+		require.Len(t, m.Layers, 1)
+		assert.Equal(t, int64(len("snapshot")), m.Layers[0].Size)
+		disk, found := registry.Blob(m.Layers[0].Digest)
+		require.True(t, found)
+		assert.Equal(t, "snapshot", string(disk))
+	})
+	t.Run("Expired tokens are renewed", func(t *testing.T) {
+		registry := ocitest.NewMemory()
+		registry.RequireAuth("user", "password")
+		srv := httptest.NewServer(registry)
+		defer srv.Close()
+		c, err := oci.NewClient(srv.URL, "snapshots", "user", "password")
+		require.NoError(t, err)
+		_, err = c.Push(t.Context(), "foo", artifact())
+		require.NoError(t, err)
+
+		registry.ExpireToken()
+		_, err = c.Push(t.Context(), "bar", artifact())
+		require.NoError(t, err)
+		_, found := registry.Manifest("snapshots", "bar")
+		assert.True(t, found)
+	})
+	t.Run("Invalid credentials are an error", func(t *testing.T) {
+		registry := ocitest.NewMemory()
+		registry.RequireAuth("user", "password")
+		srv := httptest.NewServer(registry)
+		defer srv.Close()
+		c, err := oci.NewClient(srv.URL, "snapshots", "user", "wrong")
+		require.NoError(t, err)
+		_, err = c.Push(t.Context(), "foo", artifact())
+		require.Error(t, err)
+	})
+	t.Run("Invalid tags are an error", func(t *testing.T) {
+		c, err := oci.NewClient("https://registry.example.com", "snapshots", "", "")
+		require.NoError(t, err)
+		_, err = c.Push(t.Context(), "-foo", artifact())
+		require.Error(t, err)
+	})
+}
+
+func TestSanitizeTag(t *testing.T) {
+	assert.Equal(t, "ns-pvc-2025-01-02T03-04", oci.SanitizeTag("ns-pvc-2025-01-02T03:04"))
+	assert.Equal(t, "_foo.bar", oci.SanitizeTag(".foo.bar"))
+	assert.Len(t, oci.SanitizeTag(strings.Repeat("a", 200)), 128)
+	assert.True(t, oci.ValidTag(oci.SanitizeTag("ns/pvc@2025")))
+}
@@ -0,0 +1,116 @@
+/*
+SPDX-FileCopyrightText: 2025 Outscale SAS <opensource@outscale.com>
+
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+// Package oci pushes exported disk images to OCI registries, as artifacts.
+//
+// An artifact is an OCI image manifest with a config blob describing the snapshot, and a single layer storing the disk image,
+// as exported to the bucket.
+package oci
+
+import (
+	"regexp"
+	"time"
+)
+
+// Media types of snapshot artifacts.
+const (
+	ArtifactType      = "application/vnd.outscale.csi-snapshot.v1"
+	ConfigMediaType   = "application/vnd.outscale.csi-snapshot.config.v1+json"
+	ManifestMediaType = "application/vnd.oci.image.manifest.v1+json"
+
+	// AnnotationTitle is the name of the exported file.
+	AnnotationTitle = "org.opencontainers.image.title"
+	// AnnotationCreated is the creation time of the snapshot.
+	AnnotationCreated = "org.opencontainers.image.created"
+)
+
+// DiskMediaType returns the media type of a disk image layer, e.g. application/vnd.outscale.csi-snapshot.disk.v1.qcow2+gzip.
+// Encrypted images have a +encrypted suffix.
+func DiskMediaType(format, compression string, encrypted bool) string {
+	mediaType := "application/vnd.outscale.csi-snapshot.disk.v1." + format
+	if compression != "" {
+		mediaType += "+" + compression
+	}
+	if encrypted {
+		mediaType += "+encrypted"
+	}
+	return mediaType
+}
+
+// Config is the config blob of snapshot artifacts, describing the snapshot and its exported file.
+type Config struct {
+	Namespace      string    `json:"namespace,omitempty"`
+	VolumeSnapshot string    `json:"volumeSnapshot,omitempty"`
+	PVC            string    `json:"pvc,omitempty"`
+	PV             string    `json:"pv,omitempty"`
+	StorageClass   string    `json:"storageClass,omitempty"`
+	Content        string    `json:"content"`
+	Driver         string    `json:"driver"`
+	SnapshotID     string    `json:"snapshotId"`
+	Cluster        string    `json:"cluster,omitempty"`
+	Size           int64     `json:"size,omitempty"`
+	CreationTime   time.Time `json:"creationTime,omitzero"`
+	Format         string    `json:"format"`
+	Compression    string    `json:"compression,omitempty"`
+	Encryption     string    `json:"encryption,omitempty"`
+	KeyID          string    `json:"keyId,omitempty"`
+	// Kind and Parent are the kind of the file in an incremental chain and the path of its parent, in the bucket.
+	Kind   string `json:"kind,omitempty"`
+	Parent string `json:"parent,omitempty"`
+}
+
+// Descriptor describes a blob.
+type Descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// Manifest is an OCI image manifest.
+type Manifest struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType"`
+	ArtifactType  string            `json:"artifactType,omitempty"`
+	Config        Descriptor        `json:"config"`
+	Layers        []Descriptor      `json:"layers"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+const maxTag = 128
+
+var (
+	tagRegexp        = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9._-]{0,127}$`)
+	repositoryRegexp = regexp.MustCompile(`^[a-z0-9]+((\.|_|__|-+)[a-z0-9]+)*(/[a-z0-9]+((\.|_|__|-+)[a-z0-9]+)*)*$`)
+)
+
+// ValidTag checks if a tag is valid.
+func ValidTag(tag string) bool {
+	return tagRegexp.MatchString(tag)
+}
+
+// ValidRepository checks if a repository name is valid.
+func ValidRepository(repository string) bool {
+	return repositoryRegexp.MatchString(repository)
+}
+
+// SanitizeTag converts a string to a valid tag: invalid characters are replaced by -, and the tag is truncated to 128 characters.
+// An empty string is returned for an empty string.
+func SanitizeTag(s string) string {
+	tag := []byte(s[:min(len(s), maxTag)])
+	for i, c := range tag {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '_':
+		case (c == '.' || c == '-') && i > 0:
+		default:
+			tag[i] = '-'
+			if i == 0 {
+				tag[i] = '_'
+			}
+		}
+	}
+	return string(tag)
+}
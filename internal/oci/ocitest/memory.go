@@ -0,0 +1,257 @@
+/*
+SPDX-FileCopyrightText: 2025 Outscale SAS <opensource@outscale.com>
+
+SPDX-License-Identifier: BSD-3-Clause
+*/
+package ocitest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/outscale/csi-snapshot-exporter/internal/oci"
+)
+
+// Memory is an in-memory registry, used as a local registry in tests.
+// It implements the push and pull endpoints of the distribution API, with optional bearer token authentication.
+type Memory struct {
+	mu        sync.Mutex
+	blobs     map[string][]byte
+	manifests map[string][]byte
+	uploads   map[string][]byte
+	nextID    int
+	username  string
+	password  string
+	// tokens is the number of tokens issued, only the last one being valid
+	tokens int
+	// failures are the failures of the next chunks, true if the chunk is received before failing
+	failures []bool
+}
+
+var _ http.Handler = (*Memory)(nil)
+
+func NewMemory() *Memory {
+	return &Memory{blobs: map[string][]byte{}, manifests: map[string][]byte{}, uploads: map[string][]byte{}}
+}
+
+// RequireAuth requires bearer tokens, issued by /token to clients using the credentials.
+func (m *Memory) RequireAuth(username, password string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.username, m.password = username, password
+}
+
+// ExpireToken expires the token issued, clients having to fetch a new one.
+func (m *Memory) ExpireToken() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tokens++
+}
+
+// FailChunk makes the next chunk upload fail, after receiving the chunk if received is set, as if the response was lost.
+func (m *Memory) FailChunk(received bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.failures = append(m.failures, received)
+}
+
+// Manifest returns the manifest of a tag or digest.
+func (m *Memory) Manifest(repository, reference string) (oci.Manifest, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	buf, found := m.manifests[repository+":"+reference]
+	if !found {
+		return oci.Manifest{}, false
+	}
+	var manifest oci.Manifest
+	_ = json.Unmarshal(buf, &manifest)
+	return manifest, true
+}
+
+// Blob returns a blob.
+func (m *Memory) Blob(digest string) ([]byte, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	blob, found := m.blobs[digest]
+	return blob, found
+}
+
+func (m *Memory) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if r.URL.Path == "/token" {
+		if user, password, _ := r.BasicAuth(); user != m.username || password != m.password {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"token": m.token()})
+		return
+	}
+	if m.username != "" && r.Header.Get("Authorization") != "Bearer "+m.token() {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="http://`+r.Host+`/token",service="memory"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	path, found := strings.CutPrefix(r.URL.Path, "/v2/")
+	switch {
+	case !found:
+		w.WriteHeader(http.StatusNotFound)
+	case path == "":
+		w.WriteHeader(http.StatusOK)
+	case strings.Contains(path, "/blobs/uploads/"):
+		name, id, _ := strings.Cut(path, "/blobs/uploads/")
+		m.serveUpload(w, r, name, id)
+	case strings.Contains(path, "/manifests/"):
+		name, ref, _ := strings.Cut(path, "/manifests/")
+		m.serveManifest(w, r, name, ref)
+	case strings.Contains(path, "/blobs/"):
+		_, digest, _ := strings.Cut(path, "/blobs/")
+		m.serveBlob(w, r, digest)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (m *Memory) serveUpload(w http.ResponseWriter, r *http.Request, name, id string) {
+	location := "/v2/" + name + "/blobs/uploads/"
+	switch r.Method {
+	case http.MethodPost:
+		m.nextID++
+		id = strconv.Itoa(m.nextID)
+		m.uploads[id] = nil
+		w.Header().Set("Location", location+id)
+		w.WriteHeader(http.StatusAccepted)
+		return
+	case http.MethodGet, http.MethodPatch, http.MethodPut:
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	upload, found := m.uploads[id]
+	if !found {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Location", location+id)
+	if r.Method == http.MethodGet {
+		setRange(w, upload)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if start, _, found := strings.Cut(r.Header.Get("Content-Range"), "-"); found && start != strconv.Itoa(len(upload)) {
+		setRange(w, upload)
+		w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if r.Method == http.MethodPatch && len(m.failures) > 0 {
+		if m.failures[0] {
+			m.uploads[id] = append(upload, body...)
+		}
+		m.failures = m.failures[1:]
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	upload = append(upload, body...)
+	if r.Method == http.MethodPatch {
+		m.uploads[id] = upload
+		setRange(w, upload)
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	digest := r.URL.Query().Get("digest")
+	if digest != digestOf(upload) {
+		http.Error(w, "digest mismatch", http.StatusBadRequest)
+		return
+	}
+	delete(m.uploads, id)
+	m.blobs[digest] = upload
+	w.Header().Set("Location", "/v2/"+name+"/blobs/"+digest)
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (m *Memory) token() string {
+	return "memory-token-" + strconv.Itoa(m.tokens)
+}
+
+// setRange sets the Range header of an upload, the range of the bytes received.
+func setRange(w http.ResponseWriter, upload []byte) {
+	if len(upload) > 0 {
+		w.Header().Set("Range", "0-"+strconv.Itoa(len(upload)-1))
+	}
+}
+
+func (m *Memory) serveBlob(w http.ResponseWriter, r *http.Request, digest string) {
+	blob, found := m.blobs[digest]
+	switch {
+	case r.Method != http.MethodGet && r.Method != http.MethodHead:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	case !found:
+		w.WriteHeader(http.StatusNotFound)
+	default:
+		w.Header().Set("Content-Length", strconv.Itoa(len(blob)))
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(blob)
+		}
+	}
+}
+
+func (m *Memory) serveManifest(w http.ResponseWriter, r *http.Request, name, ref string) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		buf, found := m.manifests[name+":"+ref]
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", oci.ManifestMediaType)
+		w.Header().Set("Docker-Content-Digest", digestOf(buf))
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(buf)
+		}
+	case http.MethodPut:
+		if r.Header.Get("Content-Type") != oci.ManifestMediaType {
+			http.Error(w, "unsupported manifest", http.StatusUnsupportedMediaType)
+			return
+		}
+		buf, err := io.ReadAll(r.Body)
+		var manifest oci.Manifest
+		if err == nil {
+			err = json.Unmarshal(buf, &manifest)
+		}
+		if err != nil {
+			http.Error(w, "invalid manifest", http.StatusBadRequest)
+			return
+		}
+		for _, desc := range append([]oci.Descriptor{manifest.Config}, manifest.Layers...) {
+			if blob, found := m.blobs[desc.Digest]; !found || int64(len(blob)) != desc.Size {
+				http.Error(w, "unknown blob "+desc.Digest, http.StatusBadRequest)
+				return
+			}
+		}
+		digest := digestOf(buf)
+		m.manifests[name+":"+ref] = buf
+		m.manifests[name+":"+digest] = buf
+		w.Header().Set("Docker-Content-Digest", digest)
+		w.WriteHeader(http.StatusCreated)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func digestOf(blob []byte) string {
+	sum := sha256.Sum256(blob)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
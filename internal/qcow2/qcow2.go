@@ -0,0 +1,237 @@
+/*
+SPDX-FileCopyrightText: 2025 Outscale SAS <opensource@outscale.com>
+
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+// Package qcow2 writes raw disk images as sparse qcow2 (version 2) images, to a stream.
+//
+// The image is read twice: the first pass finds the clusters holding data, to lay out all metadata
+// before the data clusters, and the second pass writes the image sequentially:
+//
+//	header | L1 table | refcount table | refcount blocks | L2 tables | data clusters
+//
+// Zero clusters are not stored.
+package qcow2
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const (
+	// ClusterBits is the log2 of the size of clusters.
+	ClusterBits = 16
+	// ClusterSize is the size of clusters.
+	ClusterSize = 1 << ClusterBits
+
+	version = 2
+	// refcounts are 16 bits wide in version 2 images
+	refcountsPerBlock = ClusterSize / 2
+	entriesPerTable   = ClusterSize / 8
+	// oflagCopied marks clusters having a refcount of 1
+	oflagCopied = 1 << 63
+)
+
+var magic = []byte{'Q', 'F', 'I', 0xfb}
+
+// Stats describes a written image.
+type Stats struct {
+	Size         int64
+	Clusters     int64
+	DataClusters int64
+}
+
+// layout stores the position of the metadata of an image, in clusters.
+type layout struct {
+	clusters      int64
+	l1Size        int64
+	l1, refTable  int64
+	refTableSize  int64
+	refBlocks     int64
+	refBlockCount int64
+	l2            int64
+	l2Count       int64
+	data          int64
+	dataCount     int64
+	total         int64
+	// allocated is a bitmap of the clusters holding data
+	allocated []uint64
+}
+
+func (l *layout) isAllocated(cluster int64) bool {
+	return l.allocated[cluster/64]&(1<<(cluster%64)) != 0
+}
+
+// hasData checks if a L2 table has data clusters.
+func (l *layout) hasData(table int64) bool {
+	for c := table * entriesPerTable; c < min((table+1)*entriesPerTable, l.clusters); c++ {
+		if l.isAllocated(c) {
+			return true
+		}
+	}
+	return false
+}
+
+// Write writes the raw image of size bytes read from r to w, as a qcow2 image.
+func Write(w io.Writer, r io.ReaderAt, size int64) (Stats, error) {
+	l, err := scan(r, size)
+	if err != nil {
+		return Stats{}, err
+	}
+	bw := bufio.NewWriterSize(w, ClusterSize)
+	if err := l.writeMetadata(bw, size); err != nil {
+		return Stats{}, err
+	}
+	buf := make([]byte, ClusterSize)
+	for i := range l.clusters {
+		if !l.isAllocated(i) {
+			continue
+		}
+		if err := readCluster(r, size, i, buf); err != nil {
+			return Stats{}, err
+		}
+		if _, err := bw.Write(buf); err != nil {
+			return Stats{}, err
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return Stats{}, err
+	}
+	return Stats{Size: size, Clusters: l.clusters, DataClusters: l.dataCount}, nil
+}
+
+// readCluster reads a cluster of the raw image, the last cluster being padded with zeros.
+func readCluster(r io.ReaderAt, size, index int64, buf []byte) error {
+	n, err := r.ReadAt(buf, index*ClusterSize)
+	switch {
+	case err == io.EOF && index*ClusterSize+int64(n) >= size:
+	case err != nil:
+		return fmt.Errorf("read image: %w", err)
+	}
+	clear(buf[n:])
+	return nil
+}
+
+func isZero(buf []byte) bool {
+	for _, b := range buf {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func ceilDiv(a, b int64) int64 {
+	return (a + b - 1) / b
+}
+
+// scan reads the raw image to find the clusters holding data, and computes the layout of the image.
+func scan(r io.ReaderAt, size int64) (*layout, error) {
+	l := &layout{clusters: ceilDiv(size, ClusterSize)}
+	l.allocated = make([]uint64, ceilDiv(l.clusters, 64))
+	buf := make([]byte, ClusterSize)
+	for i := range l.clusters {
+		if err := readCluster(r, size, i, buf); err != nil {
+			return nil, err
+		}
+		if !isZero(buf) {
+			l.allocated[i/64] |= 1 << (i % 64)
+			l.dataCount++
+		}
+	}
+	l.l1Size = ceilDiv(l.clusters, entriesPerTable)
+	for i := range l.l1Size {
+		if l.hasData(i) {
+			l.l2Count++
+		}
+	}
+
+	l1Clusters := ceilDiv(l.l1Size*8, ClusterSize)
+	// refcount structures count themselves, their size is computed until it is stable
+	for {
+		total := 1 + l1Clusters + l.refTableSize + l.refBlockCount + l.l2Count + l.dataCount
+		blocks := ceilDiv(total, refcountsPerBlock)
+		table := ceilDiv(blocks*8, ClusterSize)
+		if blocks == l.refBlockCount && table == l.refTableSize {
+			l.total = total
+			break
+		}
+		l.refBlockCount, l.refTableSize = blocks, table
+	}
+	l.l1 = 1
+	l.refTable = l.l1 + l1Clusters
+	l.refBlocks = l.refTable + l.refTableSize
+	l.l2 = l.refBlocks + l.refBlockCount
+	l.data = l.l2 + l.l2Count
+	return l, nil
+}
+
+func (l *layout) writeMetadata(w io.Writer, size int64) error {
+	header := make([]byte, ClusterSize)
+	copy(header, magic)
+	binary.BigEndian.PutUint32(header[4:], version)
+	binary.BigEndian.PutUint32(header[20:], ClusterBits)
+	binary.BigEndian.PutUint64(header[24:], uint64(size))
+	binary.BigEndian.PutUint32(header[36:], uint32(l.l1Size))
+	binary.BigEndian.PutUint64(header[40:], uint64(l.l1*ClusterSize))
+	binary.BigEndian.PutUint64(header[48:], uint64(l.refTable*ClusterSize))
+	binary.BigEndian.PutUint32(header[56:], uint32(l.refTableSize))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+
+	l1 := make([]uint64, (l.refTable-l.l1)*entriesPerTable)
+	l2Count := int64(0)
+	for i := range l.l1Size {
+		if l.hasData(i) {
+			l1[i] = uint64((l.l2+l2Count)*ClusterSize) | oflagCopied
+			l2Count++
+		}
+	}
+	if err := binary.Write(w, binary.BigEndian, l1); err != nil {
+		return err
+	}
+
+	refTable := make([]uint64, l.refTableSize*entriesPerTable)
+	for i := range l.refBlockCount {
+		refTable[i] = uint64((l.refBlocks + i) * ClusterSize)
+	}
+	if err := binary.Write(w, binary.BigEndian, refTable); err != nil {
+		return err
+	}
+
+	// all clusters are used once
+	refcounts := make([]uint16, refcountsPerBlock)
+	for i := range l.refBlockCount {
+		clear(refcounts)
+		for j := range min(l.total-i*refcountsPerBlock, refcountsPerBlock) {
+			refcounts[j] = 1
+		}
+		if err := binary.Write(w, binary.BigEndian, refcounts); err != nil {
+			return err
+		}
+	}
+
+	l2 := make([]uint64, entriesPerTable)
+	dataCount := int64(0)
+	for i := range l.l1Size {
+		if !l.hasData(i) {
+			continue
+		}
+		clear(l2)
+		first := i * entriesPerTable
+		for c := first; c < min(first+entriesPerTable, l.clusters); c++ {
+			if l.isAllocated(c) {
+				l2[c-first] = uint64((l.data+dataCount)*ClusterSize) | oflagCopied
+				dataCount++
+			}
+		}
+		if err := binary.Write(w, binary.BigEndian, l2); err != nil {
+			return err
+		}
+	}
+	return nil
+}
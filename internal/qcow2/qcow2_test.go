@@ -0,0 +1,99 @@
+/*
+SPDX-FileCopyrightText: 2025 Outscale SAS <opensource@outscale.com>
+
+SPDX-License-Identifier: BSD-3-Clause
+*/
+package qcow2_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/outscale/csi-snapshot-exporter/internal/qcow2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const offsetMask = 0x00fffffffffffe00
+
+// read rebuilds the raw image of a qcow2 image, and checks that all clusters of the file have a refcount of 1.
+func read(t *testing.T, img []byte) []byte {
+	t.Helper()
+	be := binary.BigEndian
+	require.Equal(t, []byte{'Q', 'F', 'I', 0xfb}, img[:4])
+	require.Equal(t, uint32(2), be.Uint32(img[4:]))
+	require.Equal(t, uint32(qcow2.ClusterBits), be.Uint32(img[20:]))
+	require.Zero(t, len(img)%qcow2.ClusterSize)
+	size := be.Uint64(img[24:])
+	l1Size := be.Uint32(img[36:])
+	l1 := be.Uint64(img[40:])
+	refTable := be.Uint64(img[48:])
+
+	clusters := len(img) / qcow2.ClusterSize
+	for c := range clusters {
+		block := be.Uint64(img[refTable+uint64(c/(qcow2.ClusterSize/2))*8:])
+		require.NotZero(t, block)
+		assert.Equal(t, uint16(1), be.Uint16(img[block+uint64(c%(qcow2.ClusterSize/2))*2:]), "refcount of cluster %d", c)
+	}
+
+	raw := make([]byte, size)
+	for i := range uint64(l1Size) {
+		l2 := be.Uint64(img[l1+i*8:]) & offsetMask
+		if l2 == 0 {
+			continue
+		}
+		for j := range uint64(qcow2.ClusterSize / 8) {
+			data := be.Uint64(img[l2+j*8:]) & offsetMask
+			offset := (i*qcow2.ClusterSize/8 + j) * qcow2.ClusterSize
+			if data == 0 || offset >= size {
+				continue
+			}
+			copy(raw[offset:], img[data:data+qcow2.ClusterSize])
+		}
+	}
+	return raw
+}
+
+func write(t *testing.T, raw []byte) ([]byte, qcow2.Stats) {
+	t.Helper()
+	var buf bytes.Buffer
+	stats, err := qcow2.Write(&buf, bytes.NewReader(raw), int64(len(raw)))
+	require.NoError(t, err)
+	return buf.Bytes(), stats
+}
+
+func TestWrite(t *testing.T) {
+	t.Run("Only data clusters are stored", func(t *testing.T) {
+		// 2 L2 tables, with data in the first and the last clusters only
+		raw := make([]byte, 600<<20+123)
+		r := rand.New(rand.NewPCG(1, 1))
+		for i := range 100 {
+			raw[i] = byte(r.Uint32())
+		}
+		for i := len(raw) - 100; i < len(raw); i++ {
+			raw[i] = byte(r.Uint32())
+		}
+		img, stats := write(t, raw)
+		assert.Equal(t, int64(2), stats.DataClusters)
+		assert.Less(t, len(img), 10*qcow2.ClusterSize)
+		assert.Equal(t, raw, read(t, img))
+	})
+	t.Run("Dense images are supported", func(t *testing.T) {
+		raw := make([]byte, 3*qcow2.ClusterSize)
+		r := rand.New(rand.NewPCG(2, 2))
+		for i := range raw {
+			raw[i] = byte(r.Uint32())
+		}
+		img, stats := write(t, raw)
+		assert.Equal(t, int64(3), stats.DataClusters)
+		assert.Equal(t, raw, read(t, img))
+	})
+	t.Run("Empty images have no data cluster", func(t *testing.T) {
+		raw := make([]byte, 10<<20)
+		img, stats := write(t, raw)
+		assert.Zero(t, stats.DataClusters)
+		assert.Equal(t, raw, read(t, img))
+	})
+}
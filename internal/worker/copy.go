@@ -0,0 +1,37 @@
+/*
+SPDX-FileCopyrightText: 2025 Outscale SAS <opensource@outscale.com>
+
+SPDX-License-Identifier: BSD-3-Clause
+*/
+package worker
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/outscale/csi-snapshot-exporter/internal/objectstore"
+	"k8s.io/klog/v2"
+)
+
+// Copy streams an object from the source store to the target store, unless the target object exists.
+func Copy(ctx context.Context, src objectstore.Store, bucket, key string, dst objectstore.Store, dstBucket, dstKey string) error {
+	log := klog.FromContext(ctx)
+	found, err := dst.Exists(ctx, dstBucket, dstKey)
+	switch {
+	case err != nil:
+		return fmt.Errorf("unable to check target object: %w", err)
+	case found:
+		log.V(3).Info("Object is already copied", "bucket", dstBucket, "key", dstKey)
+		return nil
+	}
+	r, err := src.Get(ctx, bucket, key)
+	if err != nil {
+		return fmt.Errorf("unable to read object: %w", err)
+	}
+	defer func() { _ = r.Close() }()
+	if err := dst.Put(ctx, dstBucket, dstKey, r); err != nil {
+		return fmt.Errorf("unable to write target object: %w", err)
+	}
+	log.V(2).Info("Object copied", "bucket", dstBucket, "key", dstKey)
+	return nil
+}
@@ -0,0 +1,39 @@
+/*
+SPDX-FileCopyrightText: 2025 Outscale SAS <opensource@outscale.com>
+
+SPDX-License-Identifier: BSD-3-Clause
+*/
+package worker_test
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/outscale/csi-snapshot-exporter/internal/objectstore/objectstoretest"
+	"github.com/outscale/csi-snapshot-exporter/internal/worker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCopy(t *testing.T) {
+	t.Run("The object is copied to the target", func(t *testing.T) {
+		src, dst := objectstoretest.NewMemory(), objectstoretest.NewMemory()
+		require.NoError(t, src.Put(t.Context(), "bucket", "foo.qcow2.gz", strings.NewReader("snapshot")))
+		require.NoError(t, worker.Copy(t.Context(), src, "bucket", "foo.qcow2.gz", dst, "backups", "dr/foo.qcow2.gz"))
+
+		r, err := dst.Get(t.Context(), "backups", "dr/foo.qcow2.gz")
+		require.NoError(t, err)
+		buf, err := io.ReadAll(r)
+		require.NoError(t, err)
+		assert.Equal(t, "snapshot", string(buf))
+
+		// a retried job succeeds, even if the source is gone
+		require.NoError(t, src.Delete(t.Context(), "bucket", "foo.qcow2.gz"))
+		require.NoError(t, worker.Copy(t.Context(), src, "bucket", "foo.qcow2.gz", dst, "backups", "dr/foo.qcow2.gz"))
+	})
+	t.Run("A missing object is an error", func(t *testing.T) {
+		src, dst := objectstoretest.NewMemory(), objectstoretest.NewMemory()
+		require.Error(t, worker.Copy(t.Context(), src, "bucket", "foo.qcow2.gz", dst, "backups", "foo.qcow2.gz"))
+	})
+}
@@ -0,0 +1,78 @@
+/*
+SPDX-FileCopyrightText: 2025 Outscale SAS <opensource@outscale.com>
+
+SPDX-License-Identifier: BSD-3-Clause
+*/
+package worker
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/outscale/csi-snapshot-exporter/internal/objectstore"
+	"github.com/outscale/csi-snapshot-exporter/internal/qcow2"
+	"k8s.io/klog/v2"
+)
+
+// DataMove streams the block device of a restored snapshot to a raw or qcow2 image, compressed with gzip or not compressed.
+// An existing image is kept.
+func DataMove(ctx context.Context, store objectstore.Store, device, format, compression, bucket, key string) error {
+	log := klog.FromContext(ctx)
+	found, err := store.Exists(ctx, bucket, key)
+	switch {
+	case err != nil:
+		return fmt.Errorf("unable to check image: %w", err)
+	case found:
+		log.V(3).Info("Image is already exported", "key", key)
+		return nil
+	}
+	f, err := os.Open(device)
+	if err != nil {
+		return fmt.Errorf("unable to open device: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return fmt.Errorf("unable to read device size: %w", err)
+	}
+	return move(ctx, store, f, size, format, compression, bucket, key)
+}
+
+// move streams a raw image of size bytes to a raw or qcow2 image.
+func move(ctx context.Context, store objectstore.Store, r io.ReaderAt, size int64, format, compression, bucket, key string) error {
+	log := klog.FromContext(ctx)
+	if compression != "gzip" && compression != "none" {
+		return fmt.Errorf("unsupported compression %q", compression)
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		var w io.Writer = pw
+		var gz *gzip.Writer
+		if compression == "gzip" {
+			gz = gzip.NewWriter(pw)
+			w = gz
+		}
+		var merr error
+		switch format {
+		case "raw":
+			_, merr = io.Copy(w, io.NewSectionReader(r, 0, size))
+		case "qcow2":
+			_, merr = qcow2.Write(w, r, size)
+		default:
+			merr = fmt.Errorf("unsupported format %q", format)
+		}
+		if merr == nil && gz != nil {
+			merr = gz.Close()
+		}
+		_ = pw.CloseWithError(merr)
+	}()
+	if err := store.Put(ctx, bucket, key, pr); err != nil {
+		_ = pr.CloseWithError(err)
+		return fmt.Errorf("unable to write image: %w", err)
+	}
+	log.V(2).Info("Image exported", "key", key, "format", format, "size", size)
+	return nil
+}
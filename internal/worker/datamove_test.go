@@ -0,0 +1,67 @@
+/*
+SPDX-FileCopyrightText: 2025 Outscale SAS <opensource@outscale.com>
+
+SPDX-License-Identifier: BSD-3-Clause
+*/
+package worker_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/outscale/csi-snapshot-exporter/internal/objectstore"
+	"github.com/outscale/csi-snapshot-exporter/internal/objectstore/objectstoretest"
+	"github.com/outscale/csi-snapshot-exporter/internal/qcow2"
+	"github.com/outscale/csi-snapshot-exporter/internal/worker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func getGzip(t *testing.T, store objectstore.Store, key string) []byte {
+	t.Helper()
+	r, err := store.Get(t.Context(), "bucket", key)
+	require.NoError(t, err)
+	defer func() { _ = r.Close() }()
+	gz, err := gzip.NewReader(r)
+	require.NoError(t, err)
+	data, err := io.ReadAll(gz)
+	require.NoError(t, err)
+	return data
+}
+
+func TestDataMove(t *testing.T) {
+	image := bytes.Repeat([]byte("snapshot"), 3*qcow2.ClusterSize)
+	device := filepath.Join(t.TempDir(), "device")
+	require.NoError(t, os.WriteFile(device, image, 0o600))
+
+	t.Run("Devices are exported as raw images", func(t *testing.T) {
+		store := objectstoretest.NewMemory()
+		require.NoError(t, worker.DataMove(t.Context(), store, device, "raw", "gzip", "bucket", "snap.raw.gz"))
+		assert.Equal(t, image, getGzip(t, store, "snap.raw.gz"))
+	})
+	t.Run("Devices are exported as qcow2 images", func(t *testing.T) {
+		store := objectstoretest.NewMemory()
+		require.NoError(t, worker.DataMove(t.Context(), store, device, "qcow2", "gzip", "bucket", "snap.qcow2.gz"))
+		img := getGzip(t, store, "snap.qcow2.gz")
+		assert.Equal(t, []byte{'Q', 'F', 'I', 0xfb}, img[:4])
+	})
+	t.Run("Devices are exported without compression", func(t *testing.T) {
+		store := objectstoretest.NewMemory()
+		require.NoError(t, worker.DataMove(t.Context(), store, device, "raw", "none", "bucket", "snap.raw"))
+		r, err := store.Get(t.Context(), "bucket", "snap.raw")
+		require.NoError(t, err)
+		defer func() { _ = r.Close() }()
+		data, err := io.ReadAll(r)
+		require.NoError(t, err)
+		assert.Equal(t, image, data)
+	})
+	t.Run("Existing images are not exported again", func(t *testing.T) {
+		store := objectstoretest.NewMemory()
+		require.NoError(t, store.Put(t.Context(), "bucket", "snap.raw.gz", bytes.NewReader([]byte("done"))))
+		require.NoError(t, worker.DataMove(t.Context(), store, "/nonexistent", "raw", "gzip", "bucket", "snap.raw.gz"))
+	})
+}
@@ -0,0 +1,131 @@
+/*
+SPDX-FileCopyrightText: 2025 Outscale SAS <opensource@outscale.com>
+
+SPDX-License-Identifier: BSD-3-Clause
+*/
+package worker
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/outscale/csi-snapshot-exporter/internal/blockdiff"
+	"github.com/outscale/csi-snapshot-exporter/internal/objectstore"
+	"k8s.io/klog/v2"
+)
+
+// Diff writes the block-level diff between a gzipped raw image and its parent to output, then deletes the image.
+// The parent is rebuilt from chain, a full image followed by the diffs leading to the parent.
+func Diff(ctx context.Context, store objectstore.Store, bucket, key string, chain []string, output string) error {
+	log := klog.FromContext(ctx)
+	if len(chain) == 0 {
+		return errors.New("missing parent chain")
+	}
+	src, err := store.Get(ctx, bucket, key)
+	switch {
+	case errors.Is(err, objectstore.ErrNotFound):
+		// the image was replaced by a previous attempt
+		found, err := store.Exists(ctx, bucket, output)
+		switch {
+		case err != nil:
+			return fmt.Errorf("unable to check diff: %w", err)
+		case !found:
+			return fmt.Errorf("object %s not found", key)
+		}
+		log.V(3).Info("Diff already exists", "key", output)
+		return nil
+	case err != nil:
+		return fmt.Errorf("unable to read object: %w", err)
+	}
+	defer func() { _ = src.Close() }()
+	current, err := gzip.NewReader(src)
+	if err != nil {
+		return fmt.Errorf("unable to read object: %w", err)
+	}
+	parent, err := rebuild(ctx, store, bucket, chain)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = parent.Close() }()
+
+	pr, pw := io.Pipe()
+	var stats blockdiff.Stats
+	go func() {
+		gz := gzip.NewWriter(pw)
+		var derr error
+		stats, derr = blockdiff.Diff(gz, parent, current)
+		if derr == nil {
+			derr = gz.Close()
+		}
+		_ = pw.CloseWithError(derr)
+	}()
+	if err := store.Put(ctx, bucket, output, pr); err != nil {
+		_ = pr.CloseWithError(err)
+		return fmt.Errorf("unable to write diff: %w", err)
+	}
+	log.V(2).Info("Diff written", "key", output, "size", stats.Size, "changed_blocks", stats.ChangedBlocks, "blocks", stats.Blocks)
+	if err := store.Delete(ctx, bucket, key); err != nil {
+		return fmt.Errorf("unable to delete full image: %w", err)
+	}
+	return nil
+}
+
+// Restore rebuilds the raw image of an exported file, using the manifest stored next to it.
+func Restore(ctx context.Context, store objectstore.Store, bucket, key string, w io.Writer) error {
+	r, err := store.Get(ctx, bucket, key+blockdiff.ManifestSuffix)
+	if err != nil {
+		return fmt.Errorf("unable to read manifest: %w", err)
+	}
+	var m struct {
+		Chain []string `json:"chain"`
+	}
+	err = json.NewDecoder(r).Decode(&m)
+	_ = r.Close()
+	if err != nil {
+		return fmt.Errorf("invalid manifest: %w", err)
+	}
+	image, err := rebuild(ctx, store, bucket, append(m.Chain, key))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = image.Close() }()
+	_, err = io.Copy(w, image)
+	return err
+}
+
+// rebuild streams the raw image rebuilt from a chain of gzipped files, a full image followed by diffs.
+func rebuild(ctx context.Context, store objectstore.Store, bucket string, chain []string) (io.ReadCloser, error) {
+	var (
+		closers []io.Closer
+		readers []io.Reader
+	)
+	closeAll := func() {
+		for _, c := range closers {
+			_ = c.Close()
+		}
+	}
+	for _, key := range chain {
+		obj, err := store.Get(ctx, bucket, key)
+		if err != nil {
+			closeAll()
+			return nil, fmt.Errorf("unable to read %s: %w", key, err)
+		}
+		closers = append(closers, obj)
+		gz, err := gzip.NewReader(obj)
+		if err != nil {
+			closeAll()
+			return nil, fmt.Errorf("unable to read %s: %w", key, err)
+		}
+		readers = append(readers, gz)
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		defer closeAll()
+		_ = pw.CloseWithError(blockdiff.Apply(pw, readers[0], readers[1:]...))
+	}()
+	return pr, nil
+}
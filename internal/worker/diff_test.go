@@ -0,0 +1,69 @@
+/*
+SPDX-FileCopyrightText: 2025 Outscale SAS <opensource@outscale.com>
+
+SPDX-License-Identifier: BSD-3-Clause
+*/
+package worker_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"testing"
+
+	"github.com/outscale/csi-snapshot-exporter/internal/blockdiff"
+	"github.com/outscale/csi-snapshot-exporter/internal/objectstore"
+	"github.com/outscale/csi-snapshot-exporter/internal/objectstore/objectstoretest"
+	"github.com/outscale/csi-snapshot-exporter/internal/worker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func putGzip(t *testing.T, store objectstore.Store, key string, data []byte) {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	_, err := gz.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+	require.NoError(t, store.Put(t.Context(), "bucket", key, &buf))
+}
+
+func TestDiff(t *testing.T) {
+	full := bytes.Repeat([]byte("snapshot"), blockdiff.BlockSize)
+	second := bytes.Clone(full)
+	copy(second[blockdiff.BlockSize:], "changed")
+	third := bytes.Clone(second)
+	copy(third[5*blockdiff.BlockSize:], "changed again")
+
+	store := objectstoretest.NewMemory()
+	putGzip(t, store, "full.raw.gz", full)
+	require.NoError(t, store.Put(t.Context(), "bucket", "full.raw.gz"+blockdiff.ManifestSuffix, bytes.NewReader([]byte(`{}`))))
+	putGzip(t, store, "second.raw.gz", second)
+	putGzip(t, store, "third.raw.gz", third)
+
+	require.NoError(t, worker.Diff(t.Context(), store, "bucket", "second.raw.gz", []string{"full.raw.gz"}, "second.diff.gz"))
+	require.NoError(t, store.Put(t.Context(), "bucket", "second.diff.gz"+blockdiff.ManifestSuffix,
+		bytes.NewReader([]byte(`{"chain":["full.raw.gz"]}`))))
+	require.NoError(t, worker.Diff(t.Context(), store, "bucket", "third.raw.gz", []string{"full.raw.gz", "second.diff.gz"}, "third.diff.gz"))
+	require.NoError(t, store.Put(t.Context(), "bucket", "third.diff.gz"+blockdiff.ManifestSuffix,
+		bytes.NewReader([]byte(`{"chain":["full.raw.gz","second.diff.gz"]}`))))
+
+	t.Run("Full images are replaced by diffs", func(t *testing.T) {
+		found, err := store.Exists(t.Context(), "bucket", "third.raw.gz")
+		require.NoError(t, err)
+		assert.False(t, found)
+		// a retried job succeeds
+		require.NoError(t, worker.Diff(t.Context(), store, "bucket", "third.raw.gz", []string{"full.raw.gz", "second.diff.gz"}, "third.diff.gz"))
+	})
+	t.Run("Each point of the chain is restored", func(t *testing.T) {
+		for key, expected := range map[string][]byte{"full.raw.gz": full, "second.diff.gz": second, "third.diff.gz": third} {
+			var buf bytes.Buffer
+			require.NoError(t, worker.Restore(t.Context(), store, "bucket", key, &buf))
+			assert.Equal(t, expected, buf.Bytes(), key)
+		}
+	})
+	t.Run("A missing parent is an error", func(t *testing.T) {
+		putGzip(t, store, "fourth.raw.gz", third)
+		require.Error(t, worker.Diff(t.Context(), store, "bucket", "fourth.raw.gz", []string{"missing.raw.gz"}, "fourth.diff.gz"))
+	})
+}
@@ -0,0 +1,64 @@
+/*
+SPDX-FileCopyrightText: 2025 Outscale SAS <opensource@outscale.com>
+
+SPDX-License-Identifier: BSD-3-Clause
+*/
+
+// Package worker implements the post-processing steps run by worker Jobs.
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/outscale/csi-snapshot-exporter/internal/encryption"
+	"github.com/outscale/csi-snapshot-exporter/internal/objectstore"
+	"k8s.io/klog/v2"
+)
+
+// Encrypt streams an object from the store, writes it encrypted to `<key>.enc` and deletes the plaintext object.
+// If keyID is set, the key of the provider must match it.
+func Encrypt(ctx context.Context, store objectstore.Store, keys encryption.KeyProvider, bucket, key, keyID string) error {
+	log := klog.FromContext(ctx)
+	id, k, err := keys.Key(ctx)
+	switch {
+	case err != nil:
+		return err
+	case keyID != "" && id != keyID:
+		return fmt.Errorf("key %s does not match expected key %s", id, keyID)
+	}
+	encKey := key + encryption.Extension
+	src, err := store.Get(ctx, bucket, key)
+	switch {
+	case errors.Is(err, objectstore.ErrNotFound):
+		// the object was encrypted by a previous attempt
+		found, err := store.Exists(ctx, bucket, encKey)
+		switch {
+		case err != nil:
+			return fmt.Errorf("unable to check encrypted object: %w", err)
+		case !found:
+			return fmt.Errorf("object %s not found", key)
+		}
+		log.V(3).Info("Object is already encrypted", "key", encKey)
+		return nil
+	case err != nil:
+		return fmt.Errorf("unable to read object: %w", err)
+	}
+	defer func() { _ = src.Close() }()
+
+	pr, pw := io.Pipe()
+	go func() {
+		_ = pw.CloseWithError(encryption.Encrypt(pw, src, k))
+	}()
+	if err := store.Put(ctx, bucket, encKey, pr); err != nil {
+		_ = pr.CloseWithError(err)
+		return fmt.Errorf("unable to write encrypted object: %w", err)
+	}
+	log.V(2).Info("Object encrypted", "key", encKey, "key_id", id)
+	if err := store.Delete(ctx, bucket, key); err != nil {
+		return fmt.Errorf("unable to delete plaintext object: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+/*
+SPDX-FileCopyrightText: 2025 Outscale SAS <opensource@outscale.com>
+
+SPDX-License-Identifier: BSD-3-Clause
+*/
+package worker_test
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/outscale/csi-snapshot-exporter/internal/encryption"
+	"github.com/outscale/csi-snapshot-exporter/internal/objectstore/objectstoretest"
+	"github.com/outscale/csi-snapshot-exporter/internal/worker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func keyFile(t *testing.T, key []byte) encryption.FileKeyProvider {
+	path := filepath.Join(t.TempDir(), "key")
+	require.NoError(t, os.WriteFile(path, key, 0o600))
+	return encryption.FileKeyProvider{Path: path}
+}
+
+func TestEncrypt(t *testing.T) {
+	key := bytes.Repeat([]byte{42}, encryption.KeySize)
+	keys := keyFile(t, key)
+	plain := strings.Repeat("snapshot", 100000)
+
+	t.Run("The object is encrypted and the plaintext is deleted", func(t *testing.T) {
+		store := objectstoretest.NewMemory()
+		require.NoError(t, store.Put(t.Context(), "bucket", "foo.qcow2.gz", strings.NewReader(plain)))
+		require.NoError(t, worker.Encrypt(t.Context(), store, keys, "bucket", "foo.qcow2.gz", encryption.KeyID(key)))
+
+		found, err := store.Exists(t.Context(), "bucket", "foo.qcow2.gz")
+		require.NoError(t, err)
+		assert.False(t, found)
+		r, err := store.Get(t.Context(), "bucket", "foo.qcow2.gz.enc")
+		require.NoError(t, err)
+		enc, err := io.ReadAll(r)
+		require.NoError(t, err)
+		var dec bytes.Buffer
+		require.NoError(t, encryption.Decrypt(&dec, bytes.NewReader(enc), key))
+		assert.Equal(t, plain, dec.String())
+
+		// a retried job succeeds
+		require.NoError(t, worker.Encrypt(t.Context(), store, keys, "bucket", "foo.qcow2.gz", encryption.KeyID(key)))
+	})
+	t.Run("A missing object is an error", func(t *testing.T) {
+		store := objectstoretest.NewMemory()
+		require.Error(t, worker.Encrypt(t.Context(), store, keys, "bucket", "foo.qcow2.gz", ""))
+	})
+	t.Run("An unexpected key is an error", func(t *testing.T) {
+		store := objectstoretest.NewMemory()
+		require.NoError(t, store.Put(t.Context(), "bucket", "foo.qcow2.gz", strings.NewReader(plain)))
+		require.Error(t, worker.Encrypt(t.Context(), store, keys, "bucket", "foo.qcow2.gz", "sha256:0000"))
+		found, err := store.Exists(t.Context(), "bucket", "foo.qcow2.gz")
+		require.NoError(t, err)
+		assert.True(t, found)
+	})
+	t.Run("An invalid key file is an error", func(t *testing.T) {
+		store := objectstoretest.NewMemory()
+		require.NoError(t, store.Put(t.Context(), "bucket", "foo.qcow2.gz", strings.NewReader(plain)))
+		require.Error(t, worker.Encrypt(t.Context(), store, keyFile(t, []byte("short")), "bucket", "foo.qcow2.gz", ""))
+	})
+}
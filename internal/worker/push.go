@@ -0,0 +1,45 @@
+/*
+SPDX-FileCopyrightText: 2025 Outscale SAS <opensource@outscale.com>
+
+SPDX-License-Identifier: BSD-3-Clause
+*/
+package worker
+
+import (
+	"context"
+	"fmt"
+	"path"
+
+	"github.com/outscale/csi-snapshot-exporter/internal/objectstore"
+	"github.com/outscale/csi-snapshot-exporter/internal/oci"
+	"k8s.io/klog/v2"
+)
+
+// Push streams an exported file from the export bucket to an OCI registry, as an artifact described by config, unless the tag exists.
+func Push(ctx context.Context, store objectstore.Store, bucket, key string, registry *oci.Client, tag string, config oci.Config) error {
+	log := klog.FromContext(ctx)
+	found, err := registry.Exists(ctx, tag)
+	switch {
+	case err != nil:
+		return fmt.Errorf("unable to check tag: %w", err)
+	case found:
+		log.V(3).Info("Artifact is already pushed", "reference", registry.Reference(tag))
+		return nil
+	}
+	r, err := store.Get(ctx, bucket, key)
+	if err != nil {
+		return fmt.Errorf("unable to read object, artifacts are pushed from the export bucket: %w", err)
+	}
+	defer func() { _ = r.Close() }()
+	digest, err := registry.Push(ctx, tag, oci.Artifact{
+		Config:    config,
+		Disk:      r,
+		MediaType: oci.DiskMediaType(config.Format, config.Compression, config.Encryption != ""),
+		Title:     path.Base(key),
+	})
+	if err != nil {
+		return fmt.Errorf("unable to push artifact: %w", err)
+	}
+	log.V(2).Info("Artifact pushed", "reference", registry.Reference(tag), "digest", digest)
+	return nil
+}
@@ -0,0 +1,52 @@
+/*
+SPDX-FileCopyrightText: 2025 Outscale SAS <opensource@outscale.com>
+
+SPDX-License-Identifier: BSD-3-Clause
+*/
+package worker_test
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/outscale/csi-snapshot-exporter/internal/objectstore/objectstoretest"
+	"github.com/outscale/csi-snapshot-exporter/internal/oci"
+	"github.com/outscale/csi-snapshot-exporter/internal/oci/ocitest"
+	"github.com/outscale/csi-snapshot-exporter/internal/worker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPush(t *testing.T) {
+	config := oci.Config{Content: "vsc", SnapshotID: "snap-foo", Format: "raw", Compression: "gzip", Encryption: "aes-256-gcm"}
+	t.Run("The object is pushed as an artifact", func(t *testing.T) {
+		store, registry := objectstoretest.NewMemory(), ocitest.NewMemory()
+		srv := httptest.NewServer(registry)
+		defer srv.Close()
+		c, err := oci.NewClient(srv.URL, "snapshots", "", "")
+		require.NoError(t, err)
+		require.NoError(t, store.Put(t.Context(), "bucket", "prod/vsc.raw.gz.enc", strings.NewReader("snapshot")))
+		require.NoError(t, worker.Push(t.Context(), store, "bucket", "prod/vsc.raw.gz.enc", c, "ns-pvc", config))
+
+		m, found := registry.Manifest("snapshots", "ns-pvc")
+		require.True(t, found)
+		require.Len(t, m.Layers, 1)
+		assert.Equal(t, "application/vnd.outscale.csi-snapshot.disk.v1.raw+gzip+encrypted", m.Layers[0].MediaType)
+		assert.Equal(t, "vsc.raw.gz.enc", m.Layers[0].Annotations[oci.AnnotationTitle])
+		disk, _ := registry.Blob(m.Layers[0].Digest)
+		assert.Equal(t, "snapshot", string(disk))
+
+		// a retried job succeeds, even if the source is gone
+		require.NoError(t, store.Delete(t.Context(), "bucket", "prod/vsc.raw.gz.enc"))
+		require.NoError(t, worker.Push(t.Context(), store, "bucket", "prod/vsc.raw.gz.enc", c, "ns-pvc", config))
+	})
+	t.Run("A missing object is an error", func(t *testing.T) {
+		store, registry := objectstoretest.NewMemory(), ocitest.NewMemory()
+		srv := httptest.NewServer(registry)
+		defer srv.Close()
+		c, err := oci.NewClient(srv.URL, "snapshots", "", "")
+		require.NoError(t, err)
+		require.Error(t, worker.Push(t.Context(), store, "bucket", "vsc.raw.gz", c, "ns-pvc", config))
+	})
+}